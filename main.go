@@ -8,17 +8,24 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
-	"regexp"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"dndbot/pkg/ai"
 	"dndbot/pkg/bot"
+	"dndbot/pkg/campaign"
 	"dndbot/pkg/dice"
 	"dndbot/pkg/game"
+	"dndbot/pkg/journal"
+	"dndbot/pkg/prompt"
+	"dndbot/pkg/retrieval"
 	"dndbot/pkg/session"
 	"dndbot/pkg/snapshot"
+	"dndbot/pkg/storage"
 
 	"github.com/joho/godotenv"
 	openai "github.com/sashabaranov/go-openai"
@@ -28,9 +35,27 @@ import (
 // LOCAL_GROUP_ID 用于本地测试的模拟群号
 const LOCAL_GROUP_ID = 1001
 
-var CurrentBackground = "你们身处在这个被遗忘的国度边缘的一个名为'微光镇'的小酒馆里。外面下着暴雨，壁炉里的火光摇曳，酒馆老板正在擦拭着酒杯。"
+// GM_QQ_IDS 环境变量: 逗号分隔的 GM QQ 号，为空时所有人都视为 GM
+var gmIDs map[int64]bool
+
+// defaultBackground 没有通过 .bg 设置背景的群组使用的默认背景，启动时从 bg.md 加载
+var defaultBackground = "你们身处在这个被遗忘的国度边缘的一个名为'微光镇'的小酒馆里。外面下着暴雨，壁炉里的火光摇曳，酒馆老板正在擦拭着酒杯。"
 var OneBotClient *bot.OneBot
 
+// defaultDBPath 未配置 DB_PATH 时的数据库文件
+const defaultDBPath = "data/dndbot.db"
+
+// 进度的持久化: 数据库 (为 nil 时只保存在内存中) 与自动存档
+var (
+	database       *storage.SQLite
+	autosaver      *snapshot.Autosaver
+	autosaveConfig snapshot.AutosaveConfig
+	shutdownOnce   sync.Once
+)
+
+// CONTEXT_WINDOW_TOKENS 环境变量: 模型上下文窗口大小，为空时使用 prompt.DefaultWindow
+var contextWindow int
+
 func main() {
 	// Parse flags
 	cliMode := flag.Bool("cli", false, "Force CLI mode")
@@ -47,15 +72,27 @@ func main() {
 	session.InitManager()
 	game.InitGameState()
 
-	// 3. Load Snapshot (if exists)
-	snap, filename, err := snapshot.LoadLatestSnapshot()
-	if err != nil {
+	if err := game.LoadRules("background/rules.json"); err != nil {
+		logrus.Warnf("Could not load rules.json: %v. Class validation disabled.", err)
+	}
+	if err := game.LoadBestiary("background/bestiary.json"); err != nil {
+		logrus.Warnf("Could not load bestiary.json: %v. spawn_npc templates disabled.", err)
+	}
+
+	// 3. Open Database, then restore from it or from the latest snapshot (if exists)
+	if dir := os.Getenv("SNAPSHOT_DIR"); dir != "" {
+		snapshot.Dir = dir
+	}
+	database = openDatabase(os.Getenv("DB_PATH"))
+	if database != nil && restoreFromDatabase(database) {
+		logrus.Info("Restored game state from database")
+	} else if snap, filename, err := snapshot.LoadLatestSnapshot(); err != nil {
 		logrus.Errorf("Failed to load snapshot: %v", err)
 	} else if snap != nil {
 		logrus.Infof("Restoring game state from %s (Time: %s)", filename, snap.Timestamp)
-		// CurrentBackground = snap.CurrentBackground // 强制使用 bg.md 不使用快照中的背景
 		session.GlobalManager.ImportData(snap.Sessions)
 		game.GlobalGameState.ImportData(snap.GameStates)
+		campaign.GlobalRegistry.ImportData(snap.Campaigns)
 	} else {
 		logrus.Info("Starting fresh game...")
 	}
@@ -64,11 +101,36 @@ func main() {
 	logrus.Info("Loading default background (bg.md)...")
 	content, err := loadBackgroundFile("bg.md")
 	if err == nil {
-		CurrentBackground = content
+		defaultBackground = content
 		logrus.Infof("Successfully loaded background: bg.md")
 	} else {
-		logrus.Warnf("Could not load bg.md: %v. Using current/snapshot background.", err)
+		logrus.Warnf("Could not load bg.md: %v. Using built-in default background.", err)
+	}
+	if err := game.LoadWorldMap(game.MapPathFor("background/bg.md")); err != nil {
+		logrus.Warnf("Could not load bg.map.json: %v. Location tracking disabled.", err)
+	}
+	if err := game.LoadCalendar("background/calendar.json"); err != nil {
+		logrus.Warnf("Could not load calendar.json: %v. Using default calendar.", err)
+	}
+	if err := game.LoadTables("background"); err != nil {
+		logrus.Warnf("Could not load random tables: %v", err)
+	}
+	if err := game.LoadLoot("background/loot.json"); err != nil {
+		logrus.Warnf("Could not load loot.json: %v. Treasure hoards disabled.", err)
+	}
+	if err := game.LoadShops("background/shops.json"); err != nil {
+		logrus.Warnf("Could not load shops.json: %v. Shops disabled.", err)
+	}
+
+	gmIDs = parseGMIDs(os.Getenv("GM_QQ_IDS"))
+	if raw := os.Getenv("CONTEXT_WINDOW_TOKENS"); raw != "" {
+		if contextWindow, err = strconv.Atoi(raw); err != nil || contextWindow <= 0 {
+			logrus.Warnf("Invalid CONTEXT_WINDOW_TOKENS %q, using default %d", raw, prompt.DefaultWindow)
+			contextWindow = 0
+		}
 	}
+	autosaveConfig = parseAutosaveConfig()
+	autosaver = snapshot.StartAutosave(autosaveConfig)
 
 	logrus.SetLevel(logrus.InfoLevel)
 
@@ -110,13 +172,18 @@ func runOneBot(wsURL string) {
 		}()
 
 		handleOneBotChat(groupID, senderID, msg)
+		autosaver.Notify()
 	}
 
 	// Blocking call
 	OneBotClient.Start()
 	// Start returns immediately in our impl? No, I wrote it to spawn a goroutine.
-	// We need to block main.
-	select {}
+	// Block main until SIGINT/SIGTERM (e.g. docker stop), then save a final snapshot.
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	sig := <-sigCh
+	logrus.Infof("Received %s, shutting down...", sig)
+	shutdown()
 }
 
 func runCLI() {
@@ -138,10 +205,20 @@ func runCLI() {
 	}
 
 	fmt.Println("Commands:")
-	fmt.Println("  .st [name] [class] [hp] [str]  - 创建角色")
+	fmt.Println("  .st [name] [class] [hp] [str] [race=种族] [dex=敏捷] [level=等级] - 创建角色")
 	fmt.Println("  .show                          - 显示状态")
-	fmt.Println("  .bg [description]              - 设置背景")
+	fmt.Println("  .bg [description|load 文件名]  - 设置本群背景 / 从 background/ 加载背景文件")
+	fmt.Println("  .campaign [new|list|switch|archive] [名称] - 管理本群的多个战役")
 	fmt.Println("  .r 1d20                        - 投掷骰子")
+	fmt.Println("  .init [show|end|strict on/off] - 投先攻开始战斗 / 查看 / 结束 / 严格回合")
+	fmt.Println("  .next                          - 推进到下一位行动者")
+	fmt.Println("  .atk [target] [weapon] [by=角色名] - 攻击目标，由系统结算命中与伤害")
+	fmt.Println("  .encounter [怪物x数量 ...]     - 评估当前/计划遭遇的难度 (GM)")
+	fmt.Println("  .summary [history|edit|revert] - 查看/修改/回滚剧情摘要")
+	fmt.Println("  .recall [关键词]               - 查看/检索以往的章节摘要")
+	fmt.Println("  .context                       - 查看发送给 AI 的上下文预算明细")
+	fmt.Println("  .undo                          - 撤销上一回合 (发言、DM 回复及其 Action 造成的全部变化)")
+	fmt.Println("  .reroll-dm [要求]              - 丢弃上一条 DM 回复及其 Action 并重新生成")
 	fmt.Println("  .reset                         - 重置记忆")
 	fmt.Println("  .exit / .quit                  - 退出程序")
 	fmt.Println("Directly type to chat with DM AI.")
@@ -153,6 +230,7 @@ func runCLI() {
 	go func() {
 		<-sigCh
 		fmt.Println("\nBye!")
+		shutdown()
 		os.Exit(0)
 	}()
 
@@ -180,7 +258,9 @@ func runCLI() {
 			// Handle Chat
 			handleCLIChat(input)
 		}
+		autosaver.Notify()
 	}
+	shutdown()
 }
 
 func handleCLICommand(input string) {
@@ -194,20 +274,11 @@ func handleCLICommand(input string) {
 
 	switch cmd {
 	case ".st":
-		if len(args) < 4 {
-			fmt.Println("Error: Usage .st [name] [class] [hp] [str]")
+		char, err := parseCharacterArgs(args)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
 			return
 		}
-		hp, _ := strconv.Atoi(args[2])
-		str, _ := strconv.Atoi(args[3])
-
-		char := &game.Character{
-			Name:  args[0],
-			Class: args[1],
-			HP:    hp,
-			MaxHP: hp,
-			STR:   str,
-		}
 		game.GlobalGameState.GetGroupState(groupID).AddCharacter(char)
 		fmt.Printf("Bot: 角色卡已创建: %s (%s)\n", char.Name, char.Class)
 
@@ -221,18 +292,13 @@ func handleCLICommand(input string) {
 		charName := args[0]
 		status := game.GlobalGameState.GetGroupState(groupID).GetCharacterStatus(charName)
 		fmt.Printf("Bot: %s\n", status)
-		// fmt.Printf("Bot: Current Background: %s\n", CurrentBackground) // 背景可能不需要每次显示单独角色时都显示
 
 	case ".bg":
-		if len(args) < 1 {
-			fmt.Println("Error: Usage .bg [description]")
-			return
+		reply, logMsg := handleBackground(groupID, args)
+		fmt.Printf("Bot: %s\n", reply)
+		if logMsg != "" {
+			session.GlobalManager.GetSession(groupID).AddMessage(openai.ChatMessageRoleSystem, logMsg)
 		}
-		newBg := strings.Join(args, " ")
-		CurrentBackground = newBg
-		fmt.Printf("Bot: 背景已更新为: %s\n", CurrentBackground)
-		sess := session.GlobalManager.GetSession(groupID)
-		sess.AddMessage(openai.ChatMessageRoleSystem, fmt.Sprintf("System: DM 将场景/背景更新为: %s", newBg))
 
 	case ".r":
 		if len(args) < 1 {
@@ -249,12 +315,117 @@ func handleCLICommand(input string) {
 		logMsg := fmt.Sprintf("【系统提示】玩家(CLIUser) 投掷了 %s，最终结果: %d (详情: %v)", res.Expression, res.Total, res.Details)
 		sess.AddMessage(openai.ChatMessageRoleUser, logMsg)
 
+	case ".init":
+		reply, logMsg := handleInitiative(groupID, args)
+		fmt.Printf("Bot: %s\n", reply)
+		if logMsg != "" {
+			session.GlobalManager.GetSession(groupID).AddMessage(openai.ChatMessageRoleUser, logMsg)
+		}
+
+	case ".next":
+		reply, logMsg := handleNextTurn(groupID)
+		fmt.Printf("Bot: %s\n", reply)
+		if logMsg != "" {
+			session.GlobalManager.GetSession(groupID).AddMessage(openai.ChatMessageRoleUser, logMsg)
+		}
+
+	case ".atk":
+		turn := journal.GlobalJournal.Begin(groupID, 0, "CLIUser: "+input)
+		defer journal.GlobalJournal.End(turn)
+		reply, logMsg := handleAttack(turn, 0, args)
+		fmt.Printf("Bot: %s\n", reply)
+		if logMsg != "" {
+			turn.AddMessage(openai.ChatMessageRoleUser, logMsg)
+			replyAsDMCLI(groupID, turn, "")
+		}
+
+	case ".encounter":
+		fmt.Printf("Bot: %s\n", handleEncounterRating(groupID, args))
+
+	case ".npcs":
+		fmt.Printf("Bot: %s\n", handleNPCs(groupID, args))
+
+	case ".quests":
+		fmt.Printf("Bot: %s\n", handleQuests(groupID, args))
+
+	case ".where":
+		fmt.Printf("Bot: %s\n", game.GlobalGameState.GetGroupState(groupID).GetWhereSummary(worldMapFor(game.GlobalGameState.GetGroupState(groupID))))
+
+	case ".table":
+		reply, logMsg := handleTableRoll("CLIUser", args)
+		fmt.Printf("Bot: %s\n", reply)
+		if logMsg != "" {
+			session.GlobalManager.GetSession(groupID).AddMessage(openai.ChatMessageRoleUser, logMsg)
+		}
+
+	case ".stash":
+		reply, logMsg := handleStash(groupID, args)
+		fmt.Printf("Bot: %s\n", reply)
+		if logMsg != "" {
+			session.GlobalManager.GetSession(groupID).AddMessage(openai.ChatMessageRoleUser, logMsg)
+		}
+
+	case ".claim":
+		reply, logMsg := handleClaim(groupID, 0, args)
+		fmt.Printf("Bot: %s\n", reply)
+		if logMsg != "" {
+			session.GlobalManager.GetSession(groupID).AddMessage(openai.ChatMessageRoleUser, logMsg)
+		}
+
+	case ".shop", ".buy", ".sell", ".haggle":
+		reply, logMsg := handleShopCommand(groupID, 0, cmd, args)
+		fmt.Printf("Bot: %s\n", reply)
+		if logMsg != "" {
+			session.GlobalManager.GetSession(groupID).AddMessage(openai.ChatMessageRoleUser, logMsg)
+		}
+
+	case ".party", ".march", ".watch", ".supply":
+		reply, logMsg := handlePartyCommand(groupID, cmd, args)
+		fmt.Printf("Bot: %s\n", reply)
+		if logMsg != "" {
+			session.GlobalManager.GetSession(groupID).AddMessage(openai.ChatMessageRoleUser, logMsg)
+		}
+
+	case ".campaign":
+		fmt.Printf("Bot: %s\n", handleCampaign(groupID, args))
+
+	case ".summary":
+		reply, logMsg := handleSummary(groupID, args)
+		fmt.Printf("Bot: %s\n", reply)
+		if logMsg != "" {
+			session.GlobalManager.GetSession(groupID).AddMessage(openai.ChatMessageRoleSystem, logMsg)
+		}
+
+	case ".recall":
+		fmt.Printf("Bot: %s\n", handleRecall(groupID, args))
+
+	case ".context":
+		_, report := buildDMContext(game.GlobalGameState.GetGroupState(groupID), session.GlobalManager.GetSession(groupID))
+		fmt.Printf("Bot: %s\n", report.String())
+
+	case ".time":
+		fmt.Printf("Bot: %s\n", game.GlobalGameState.GetGroupState(groupID).GetTimeSummary(game.GlobalCalendar))
+
+	case ".undo":
+		fmt.Printf("Bot: %s\n", handleUndo(groupID))
+
+	case ".reroll-dm":
+		turn, err := journal.GlobalJournal.Reroll(groupID)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		defer journal.GlobalJournal.End(turn)
+		fmt.Println("Bot: 🎲 已丢弃上一条 DM 回复，正在重新生成…")
+		replyAsDMCLI(groupID, turn, strings.Join(args, " "))
+
 	case ".reset":
 		session.GlobalManager.GetSession(groupID).Clear()
+		journal.GlobalJournal.Clear(groupID)
 		fmt.Println("Bot: 记忆已清除。")
 
 	case ".snapshot":
-		filename, err := snapshot.SaveSnapshot(CurrentBackground)
+		filename, err := snapshot.SaveSnapshot()
 		if err != nil {
 			fmt.Printf("Error saving snapshot: %v\n", err)
 		} else {
@@ -266,7 +437,7 @@ func handleCLICommand(input string) {
 		if err != nil {
 			fmt.Printf("Error deleting snapshot: %v\n", err)
 		} else {
-			fmt.Printf("Bot: Deleted latest snapshot: %s\n", filename)
+			fmt.Printf("Bot: Deleted latest manual snapshot: %s\n", filename)
 		}
 
 	case ".introduce":
@@ -345,30 +516,19 @@ func handleOneBotChat(groupID int64, senderID int64, msg string) {
 	// Handle .st command (Create Character)
 	if strings.HasPrefix(msg, ".st ") {
 		parts := strings.Fields(msg)
-		// Usage: .st [name] [class] [hp] [str]
-		if len(parts) < 5 {
-			OneBotClient.SendGroupMsg(groupID, "Error: Usage .st [name] [class] [hp] [str]")
-			return
-		}
-
-		hp, err1 := strconv.Atoi(parts[3])
-		str, err2 := strconv.Atoi(parts[4])
-
-		if err1 != nil || err2 != nil {
-			OneBotClient.SendGroupMsg(groupID, "Error: HP and STR must be numbers.")
+		char, err := parseCharacterArgs(parts[1:])
+		if err != nil {
+			OneBotClient.SendGroupMsg(groupID, fmt.Sprintf("Error: %v", err))
 			return
 		}
+		char.OwnerID = senderID
 
-		char := &game.Character{
-			Name:  parts[1],
-			Class: parts[2],
-			HP:    hp,
-			MaxHP: hp,
-			STR:   str,
-		}
 		game.GlobalGameState.GetGroupState(groupID).AddCharacter(char)
 		reply := fmt.Sprintf("【角色创建成功】\n姓名: %s\n职业: %s\nHP: %d/%d\nSTR: %d",
 			char.Name, char.Class, char.HP, char.MaxHP, char.STR)
+		if res := char.ResourceSummary(); res != "" {
+			reply += "\n资源: " + res
+		}
 		OneBotClient.SendGroupMsg(groupID, reply)
 
 		// Log into context so AI "DM" knows about it
@@ -379,9 +539,218 @@ func handleOneBotChat(groupID int64, senderID int64, msg string) {
 		return
 	}
 
+	// Handle .init / .next commands (Initiative)
+	if msg == ".init" || strings.HasPrefix(msg, ".init ") {
+		reply, logMsg := handleInitiative(groupID, strings.Fields(msg)[1:])
+		OneBotClient.SendGroupMsg(groupID, reply)
+		if logMsg != "" {
+			session.GlobalManager.GetSession(groupID).AddMessage(openai.ChatMessageRoleUser, logMsg)
+		}
+		return
+	}
+
+	if msg == ".next" {
+		reply, logMsg := handleNextTurn(groupID)
+		OneBotClient.SendGroupMsg(groupID, reply)
+		if logMsg != "" {
+			session.GlobalManager.GetSession(groupID).AddMessage(openai.ChatMessageRoleUser, logMsg)
+		}
+		return
+	}
+
+	// Handle .atk command (engine-resolved attack, then DM narrates)
+	if msg == ".atk" || strings.HasPrefix(msg, ".atk ") {
+		turn := journal.GlobalJournal.Begin(groupID, senderID, fmt.Sprintf("Player(QQ:%d): %s", senderID, msg))
+		defer journal.GlobalJournal.End(turn)
+		if ok, notice := turn.State().CheckTurn(senderID); !ok {
+			OneBotClient.SendGroupMsg(groupID, fmt.Sprintf("[CQ:at,qq=%d] %s", senderID, notice))
+			return
+		}
+		reply, logMsg := handleAttack(turn, senderID, strings.Fields(msg)[1:])
+		OneBotClient.SendGroupMsg(groupID, reply)
+		if logMsg != "" {
+			turn.AddMessage(openai.ChatMessageRoleUser, logMsg)
+			replyAsDM(groupID, turn, "")
+		}
+		return
+	}
+
+	// Handle .encounter command (GM only)
+	if msg == ".encounter" || strings.HasPrefix(msg, ".encounter ") {
+		if !isGM(senderID) {
+			OneBotClient.SendGroupMsg(groupID, "只有 GM 可以使用 .encounter")
+			return
+		}
+		OneBotClient.SendGroupMsg(groupID, handleEncounterRating(groupID, strings.Fields(msg)[1:]))
+		return
+	}
+
+	// Handle .npcs command
+	if msg == ".npcs" || strings.HasPrefix(msg, ".npcs ") {
+		OneBotClient.SendGroupMsg(groupID, handleNPCs(groupID, strings.Fields(msg)[1:]))
+		return
+	}
+
+	// Handle .quests command
+	if msg == ".quests" || strings.HasPrefix(msg, ".quests ") {
+		OneBotClient.SendGroupMsg(groupID, handleQuests(groupID, strings.Fields(msg)[1:]))
+		return
+	}
+
+	// Handle .where command
+	if msg == ".where" {
+		OneBotClient.SendGroupMsg(groupID, game.GlobalGameState.GetGroupState(groupID).GetWhereSummary(worldMapFor(game.GlobalGameState.GetGroupState(groupID))))
+		return
+	}
+
+	// Handle .table command
+	if msg == ".table" || strings.HasPrefix(msg, ".table ") {
+		reply, logMsg := handleTableRoll(fmt.Sprintf("QQ:%d", senderID), strings.Fields(msg)[1:])
+		OneBotClient.SendGroupMsg(groupID, reply)
+		if logMsg != "" {
+			session.GlobalManager.GetSession(groupID).AddMessage(openai.ChatMessageRoleUser, logMsg)
+		}
+		return
+	}
+
+	// Handle .stash / .claim commands
+	if msg == ".stash" || strings.HasPrefix(msg, ".stash ") || msg == ".claim" || strings.HasPrefix(msg, ".claim ") {
+		var reply, logMsg string
+		fields := strings.Fields(msg)
+		if fields[0] == ".claim" {
+			reply, logMsg = handleClaim(groupID, senderID, fields[1:])
+		} else {
+			reply, logMsg = handleStash(groupID, fields[1:])
+		}
+		OneBotClient.SendGroupMsg(groupID, reply)
+		if logMsg != "" {
+			session.GlobalManager.GetSession(groupID).AddMessage(openai.ChatMessageRoleUser, logMsg)
+		}
+		return
+	}
+
+	// Handle .shop / .buy / .sell / .haggle commands
+	if fields := strings.Fields(msg); len(fields) > 0 && (fields[0] == ".shop" || fields[0] == ".buy" || fields[0] == ".sell" || fields[0] == ".haggle") {
+		reply, logMsg := handleShopCommand(groupID, senderID, fields[0], fields[1:])
+		OneBotClient.SendGroupMsg(groupID, reply)
+		if logMsg != "" {
+			session.GlobalManager.GetSession(groupID).AddMessage(openai.ChatMessageRoleUser, logMsg)
+		}
+		return
+	}
+
+	// Handle .party / .march / .watch / .supply commands
+	if fields := strings.Fields(msg); len(fields) > 0 && (fields[0] == ".party" || fields[0] == ".march" || fields[0] == ".watch" || fields[0] == ".supply") {
+		reply, logMsg := handlePartyCommand(groupID, fields[0], fields[1:])
+		OneBotClient.SendGroupMsg(groupID, reply)
+		if logMsg != "" {
+			session.GlobalManager.GetSession(groupID).AddMessage(openai.ChatMessageRoleUser, logMsg)
+		}
+		return
+	}
+
+	// Handle .bg command (GM only)
+	if msg == ".bg" || strings.HasPrefix(msg, ".bg ") {
+		args := strings.Fields(msg)[1:]
+		if len(args) > 0 && !isGM(senderID) {
+			OneBotClient.SendGroupMsg(groupID, "只有 GM 可以更换背景")
+			return
+		}
+		reply, logMsg := handleBackground(groupID, args)
+		OneBotClient.SendGroupMsg(groupID, reply)
+		if logMsg != "" {
+			session.GlobalManager.GetSession(groupID).AddMessage(openai.ChatMessageRoleSystem, logMsg)
+		}
+		return
+	}
+
+	// Handle .campaign command (new/switch/archive GM only)
+	if msg == ".campaign" || strings.HasPrefix(msg, ".campaign ") {
+		args := strings.Fields(msg)[1:]
+		if len(args) > 0 && args[0] != "list" && !isGM(senderID) {
+			OneBotClient.SendGroupMsg(groupID, "只有 GM 可以新建、切换或归档战役")
+			return
+		}
+		OneBotClient.SendGroupMsg(groupID, handleCampaign(groupID, args))
+		return
+	}
+
+	// Handle .undo command (GM 或发起该回合的玩家)
+	if msg == ".undo" {
+		if last := journal.GlobalJournal.Last(groupID); last != nil && last.OwnerID != senderID && !isGM(senderID) {
+			OneBotClient.SendGroupMsg(groupID, "只有 GM 或发起上一回合的玩家可以撤销")
+			return
+		}
+		OneBotClient.SendGroupMsg(groupID, handleUndo(groupID))
+		return
+	}
+
+	// Handle .reroll-dm command (GM 直接重新生成，其他玩家需过半数投票)
+	if msg == ".reroll-dm" || strings.HasPrefix(msg, ".reroll-dm ") {
+		if !isGM(senderID) {
+			votes, err := journal.GlobalJournal.Vote(groupID, senderID)
+			if err != nil {
+				OneBotClient.SendGroupMsg(groupID, fmt.Sprintf("Error: %v", err))
+				return
+			}
+			if need := rerollVotesNeeded(groupID); votes < need {
+				OneBotClient.SendGroupMsg(groupID, fmt.Sprintf("🗳️ 要求重新生成上一条 DM 回复: %d/%d 票", votes, need))
+				return
+			}
+		}
+		turn, err := journal.GlobalJournal.Reroll(groupID)
+		if err != nil {
+			OneBotClient.SendGroupMsg(groupID, fmt.Sprintf("Error: %v", err))
+			return
+		}
+		defer journal.GlobalJournal.End(turn)
+		OneBotClient.SendGroupMsg(groupID, "🎲 已丢弃上一条 DM 回复，正在重新生成…")
+		replyAsDM(groupID, turn, strings.TrimSpace(strings.TrimPrefix(msg, ".reroll-dm")))
+		return
+	}
+
+	// Handle .summary command (edit/revert GM only)
+	if msg == ".summary" || strings.HasPrefix(msg, ".summary ") {
+		args := strings.Fields(msg)[1:]
+		if len(args) > 0 && (args[0] == "edit" || args[0] == "revert") && !isGM(senderID) {
+			OneBotClient.SendGroupMsg(groupID, "只有 GM 可以修改剧情摘要")
+			return
+		}
+		if len(args) > 0 && args[0] == "edit" {
+			// 保留摘要原文中的换行与空格
+			rest := strings.TrimSpace(strings.TrimPrefix(msg, ".summary"))
+			args = []string{"edit", strings.TrimSpace(strings.TrimPrefix(rest, "edit"))}
+		}
+		reply, logMsg := handleSummary(groupID, args)
+		OneBotClient.SendGroupMsg(groupID, reply)
+		if logMsg != "" {
+			session.GlobalManager.GetSession(groupID).AddMessage(openai.ChatMessageRoleSystem, logMsg)
+		}
+		return
+	}
+
+	// Handle .recall command
+	if msg == ".recall" || strings.HasPrefix(msg, ".recall ") {
+		OneBotClient.SendGroupMsg(groupID, handleRecall(groupID, strings.Fields(msg)[1:]))
+		return
+	}
+
+	// Handle .context command
+	if msg == ".context" {
+		_, report := buildDMContext(game.GlobalGameState.GetGroupState(groupID), session.GlobalManager.GetSession(groupID))
+		OneBotClient.SendGroupMsg(groupID, report.String())
+		return
+	}
+
+	// Handle .time command
+	if msg == ".time" {
+		OneBotClient.SendGroupMsg(groupID, game.GlobalGameState.GetGroupState(groupID).GetTimeSummary(game.GlobalCalendar))
+		return
+	}
+
 	// Handle .snapshot command
 	if strings.HasPrefix(msg, ".snapshot") {
-		filename, err := snapshot.SaveSnapshot(CurrentBackground)
+		filename, err := snapshot.SaveSnapshot()
 		if err != nil {
 			OneBotClient.SendGroupMsg(groupID, fmt.Sprintf("Snapshot failed: %v", err))
 		} else {
@@ -396,7 +765,7 @@ func handleOneBotChat(groupID int64, senderID int64, msg string) {
 		if err != nil {
 			OneBotClient.SendGroupMsg(groupID, fmt.Sprintf("Delete failed: %v", err))
 		} else {
-			OneBotClient.SendGroupMsg(groupID, fmt.Sprintf("Deleted latest snapshot: %s", filename))
+			OneBotClient.SendGroupMsg(groupID, fmt.Sprintf("Deleted latest manual snapshot: %s", filename))
 		}
 		return
 	}
@@ -428,27 +797,47 @@ func handleOneBotChat(groupID int64, senderID int64, msg string) {
 		return
 	}
 
-	// Normal Chat Flow
-	sess := session.GlobalManager.GetSession(groupID)
+	// Normal Chat Flow (同一群组的回合串行处理)
 	userLog := fmt.Sprintf("Player(QQ:%d): %s", senderID, msg)
-	sess.AddMessage(openai.ChatMessageRoleUser, userLog)
+	turn := journal.GlobalJournal.Begin(groupID, senderID, userLog)
+	defer journal.GlobalJournal.End(turn)
+	if ok, notice := turn.State().CheckTurn(senderID); !ok {
+		OneBotClient.SendGroupMsg(groupID, fmt.Sprintf("[CQ:at,qq=%d] %s", senderID, notice))
+		return
+	}
+
+	turn.AddMessage(openai.ChatMessageRoleUser, userLog)
+	replyAsDM(groupID, turn, "")
+}
+
+// replyAsDM 请求 DM 回复并处理 Action、回合推进与自动摘要 (OneBot)
+// guidance 非空时作为本次回复的额外要求 (.reroll-dm)，不写入对话记录
+// 回复与 Action 始终作用于回合开始时的会话与群组状态
+func replyAsDM(groupID int64, turn *journal.Entry, guidance string) {
+	sess, groupState := turn.Session(), turn.State()
+	journal.GlobalJournal.MarkReply(turn)
 
 	// Get Reply
-	reply, err := getDMResponse(groupID, sess)
+	reply, err := getDMResponse(groupID, groupState, sess, guidance)
 	if err != nil {
 		OneBotClient.SendGroupMsg(groupID, fmt.Sprintf("(Available) AI Error: %v", err))
 		return
 	}
 
-	// Send Reply
-	OneBotClient.SendGroupMsg(groupID, reply)
-	sess.AddMessage(openai.ChatMessageRoleAssistant, reply)
+	// Send Reply: Action 块只写入对话记录，不发给玩家
+	if text := ai.StripActions(reply); text != "" {
+		OneBotClient.SendGroupMsg(groupID, text)
+	}
+	turn.AddMessage(openai.ChatMessageRoleAssistant, reply)
 
 	// Process Actions
-	actionLogs := processAIActionsAndGetLogs(reply, groupID)
+	actionLogs := processAIActionsAndGetLogs(reply, groupID, turn)
 	if len(actionLogs) > 0 {
 		OneBotClient.SendGroupMsg(groupID, strings.Join(actionLogs, "\n"))
 	}
+	if notice := advanceStrictTurn(turn); notice != "" {
+		OneBotClient.SendGroupMsg(groupID, notice)
+	}
 
 	// Summary Logic
 	checkAndSummarize(groupID, sess)
@@ -456,81 +845,219 @@ func handleOneBotChat(groupID int64, senderID int64, msg string) {
 
 func handleCLIChat(input string) {
 	groupID := int64(LOCAL_GROUP_ID)
-	sess := session.GlobalManager.GetSession(groupID)
-	sess.AddMessage(openai.ChatMessageRoleUser, fmt.Sprintf("CLIUser: %s", input))
+	turn := journal.GlobalJournal.Begin(groupID, 0, "CLIUser: "+input)
+	defer journal.GlobalJournal.End(turn)
+	turn.AddMessage(openai.ChatMessageRoleUser, fmt.Sprintf("CLIUser: %s", input))
+
+	replyAsDMCLI(groupID, turn, "")
+}
+
+// replyAsDMCLI 请求 DM 回复并处理 Action、回合推进与自动摘要 (CLI)
+func replyAsDMCLI(groupID int64, turn *journal.Entry, guidance string) {
+	sess, groupState := turn.Session(), turn.State()
+	journal.GlobalJournal.MarkReply(turn)
 
 	fmt.Print("DM AI (Thinking...)")
 	// Clear line logic... slightly messy in generic func
-	reply, err := getDMResponse(groupID, sess)
+	reply, err := getDMResponse(groupID, groupState, sess, guidance)
 	fmt.Print("\r" + strings.Repeat(" ", 30) + "\r")
 
 	if err != nil {
 		fmt.Printf("Error calling AI: %v\n", err)
 		return
 	}
-	fmt.Printf("DM AI: %s\n", reply)
-	sess.AddMessage(openai.ChatMessageRoleAssistant, reply)
+	fmt.Printf("DM AI: %s\n", ai.StripActions(reply))
+	turn.AddMessage(openai.ChatMessageRoleAssistant, reply)
 
-	actionLogs := processAIActionsAndGetLogs(reply, groupID)
+	actionLogs := processAIActionsAndGetLogs(reply, groupID, turn)
 	for _, log := range actionLogs {
 		fmt.Printf(">> Bot Action: %s\n", log)
 	}
+	if notice := advanceStrictTurn(turn); notice != "" {
+		fmt.Printf("Bot: %s\n", notice)
+	}
 
 	checkAndSummarize(groupID, sess)
 }
 
 // Shared Core Logic
-func getDMResponse(groupID int64, sess *session.Session) (string, error) {
-	statusSummary := game.GlobalGameState.GetGroupState(groupID).GetStatusSummary()
+func getDMResponse(groupID int64, groupState *game.GroupState, sess *session.Session, guidance string) (string, error) {
+	requests, report := buildDMContext(groupState, sess)
+	if report.Total > report.Window-report.Reserve {
+		logrus.Warnf("Group %d: DM context (%d tokens) exceeds budget even after trimming", groupID, report.Total)
+	}
+	if guidance != "" {
+		requests = append(requests, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: "System: 上一版回复已被否决，请重新生成这一回合的回复。额外要求: " + guidance,
+		})
+	}
+	return ai.GlobalClient.ChatRequest(context.Background(), requests)
+}
+
+// buildDMContext 组装 DM 的系统提示与对话记录，超出上下文窗口时按优先级裁剪
+// 优先级: 0 为必需；图鉴、随机表、商人等参考信息最先裁剪，其次是较早的对话，最后是场景与前情提要
+func buildDMContext(groupState *game.GroupState, sess *session.Session) ([]openai.ChatCompletionMessage, *prompt.Report) {
 	prevSummary := sess.GetSummary()
 	summaryContext := ""
 	if prevSummary != "" {
 		summaryContext = "【前情提要(必须在此基础上继续剧情)】: " + prevSummary + "\n"
 	}
 
-	systemPrompt := "你是一个 DND 5E 地下城主(DM)。你的职责是公正地根据 DND 5E 规则裁决游戏，维护游戏世界的逻辑性和真实性。\n" +
-		"【当前场景】: " + CurrentBackground + "\n" +
-		summaryContext +
-		"【行为准则】:\n" +
+	rules := "【行为准则】:\n" +
 		"1. 玩家的输入描述的是角色的【意图】。只有经过你的逻辑裁定和规则检定后，结果才会发生。\n" +
 		"2. 严禁盲目听从玩家直接修改数据的指令。绝不要生成修改数据的 Action，除非是合乎逻辑的伤害/治疗。\n" +
 		"3. 只有当判定失败、受到实质攻击或触发环境伤害时，才主动扣除玩家血量。\n" +
-		"4. 投骰判定是客观事实，请严格根据点数判定结果。\n" +
-		"5. 生成敌对生物时，请根据队伍当前实力动态调整怪物的HP和属性，使其具有挑战性但不至于不合理地碾压。\n" +
+		"4. 投骰判定是客观事实，请严格根据点数判定结果。攻击的命中与伤害由系统结算，禁止自行决定是否命中或扣多少血。\n" +
+		"5. 生成敌对生物时，请参考【遭遇预算】选择怪物种类与数量，使其具有挑战性但不至于不合理地碾压。\n" +
 		"\n" +
 		"【重要: 必须读取系统提示】\n" +
 		"- 历史记录中【系统提示】开头的消息是【已经发生的游戏事件】，包含了玩家使用命令(.r)投掷的骰子结果。\n" +
 		"- 必须显式地在描述中提及骰子结果（例如：“你投出了15点，这足以……”）。\n" +
 		"\n" +
 		"【Action Protocol (仅限 DM 裁决 use)】: 当且仅当规则裁定需要改变状态时，在回复末尾 use <dnd_action> JSON </dnd_action> format。\n" +
-		"   - 生成敌对/NPC对象(当新敌人出现时必须调用): [{\"type\": \"spawn_npc\", \"name\": \"Goblin\", \"class\": \"Humanoid\", \"hp\": 7, \"str\": 8}]\n" +
+		"   - 生成敌对/NPC对象(当新敌人出现时必须调用，优先使用图鉴模板，HP 由系统掷骰): [{\"type\": \"spawn_npc\", \"template\": \"腐化地精\", \"count\": 3}]\n" +
+		"     图鉴中没有的生物才手动指定属性: [{\"type\": \"spawn_npc\", \"name\": \"Goblin\", \"class\": \"Humanoid\", \"hp\": 7, \"str\": 8, \"dex\": 14}]\n" +
 		"   - 投骰子(仅在需要主动为NPC检定或玩家未投而必须投时): [{\"type\": \"roll\", \"expr\": \"1d20\", \"reason\": \"Enemy Attack\"}]\n" +
-		"   - 改血量(仅在确实受到伤害/治疗时): [{\"type\": \"hp\", \"target\": \"Name\", \"value\": -5}] (负数扣血)\n" +
-		statusSummary
+		"   - 攻击(NPC 攻击或需要代为结算攻击时，系统会对比 AC 并自动扣血): [{\"type\": \"attack\", \"attacker\": \"Goblin\", \"target\": \"Name\", \"weapon\": \"短矛\"}] (未登记的武器可加 \"damage\": \"1d6+2\")\n" +
+		"   - 改血量(仅在非攻击造成的伤害/治疗时，如陷阱、药水): [{\"type\": \"hp\", \"target\": \"Name\", \"value\": -5}] (负数扣血)\n" +
+		"   - 记录具名 NPC(首次登场时): [{\"type\": \"npc_add\", \"name\": \"老汤姆\", \"description\": \"酒馆老板，独眼，嗜酒\", \"location\": \"橡木酒馆\"}]\n" +
+		"   - 更新 NPC(态度变化/玩家得知新信息/死亡): [{\"type\": \"npc_update\", \"name\": \"老汤姆\", \"attitude\": {\"PC名\": \"感激\"}, \"fact\": \"他曾是王家卫兵\", \"alive\": true}]\n" +
+		"   - 新任务(玩家接受委托/发现主线时): [{\"type\": \"quest_add\", \"title\": \"失踪的商队\", \"objectives\": [\"找到商队营地\", \"查明袭击者\"], \"rewards\": \"50 金币\"}]\n" +
+		"   - 更新任务(目标达成/新增目标/完成或失败): [{\"type\": \"quest_update\", \"title\": \"失踪的商队\", \"done\": [\"找到商队营地\"], \"status\": \"completed\"}]\n" +
+		"   - 移动队伍(玩家决定前往【当前位置】列出的地点时，系统计算路程耗时并推进时间): [{\"type\": \"move_party\", \"to\": \"镇广场\"}]\n" +
+		"   - 推进时间(搜索、交谈等耗时行动，单位分钟): [{\"type\": \"advance_time\", \"minutes\": 30}]；休息: [{\"type\": \"advance_time\", \"rest\": \"long\"}] (long 长休/short 短休，由系统恢复生命与每日能力)\n" +
+		"   - 定时事件(在若干分钟后发生的剧情，例如追兵抵达): [{\"type\": \"schedule_event\", \"minutes\": 120, \"text\": \"追兵抵达镇广场\"}]\n" +
+		"   - 随机表(遭遇、传闻、战利品等必须从【随机表】掷出，不要自行编造结果): [{\"type\": \"table_roll\", \"table\": \"密林随机遭遇\", \"reason\": \"赶路途中\"}]\n" +
+		"   - 战利品(击败敌人、打开宝箱时由系统按 CR 生成，放入队伍储物): [{\"type\": \"loot\", \"cr\": \"2\", \"reason\": \"腐化熊怪的巢穴\"}]；剧情固定奖励: [{\"type\": \"loot\", \"name\": \"迷雾灯笼\"}]\n" +
+		"   - 持续状态(中毒、束缚等，到时自动解除): [{\"type\": \"set_status\", \"target\": \"Name\", \"status\": \"中毒\", \"minutes\": 60}]\n" +
+		"   - 消耗公用物资(赶路一天消耗口粮、夜里点燃火把等，获得时 value 为正): [{\"type\": \"party_supply\", \"name\": \"口粮\", \"value\": -4, \"reason\": \"四人一天的口粮\"}]\n"
+
+	b := &prompt.Builder{
+		Window:  contextWindow,
+		History: sess.GetHistory(),
+		Sections: []prompt.Section{
+			{Name: "身份", Text: "你是一个 DND 5E 地下城主(DM)。你的职责是公正地根据 DND 5E 规则裁决游戏，维护游戏世界的逻辑性和真实性。\n"},
+			{Name: "场景", Text: sceneContext(groupState), Priority: 2, Truncate: true},
+			{Name: "相关设定", Text: loreContext(groupState, sess), Priority: 3, Truncate: true},
+			{Name: "前情提要", Text: summaryContext, Priority: 1, Truncate: true},
+			{Name: "往事回顾", Text: episodeContext(sess), Priority: 3, Truncate: true},
+			{Name: "规则与指令", Text: rules},
+			{Name: "怪物图鉴", Text: game.GlobalBestiary.Summary(), Priority: 6},
+			{Name: "随机表", Text: game.GlobalTables.Summary(), Priority: 6},
+			{Name: "角色状态", Text: groupState.GetStatusSummary()},
+			{Name: "NPC", Text: groupState.GetRelevantNPCSummary(sceneText(sess, prevSummary)), Priority: 3},
+			{Name: "任务", Text: groupState.GetActiveQuestSummary(), Priority: 3},
+			{Name: "时间", Text: groupState.GetClockSummary(game.GlobalCalendar), Priority: 2},
+			{Name: "队伍储物", Text: groupState.GetStashSummary(), Priority: 5},
+			{Name: "队伍", Text: groupState.GetPartySummary(game.GlobalCalendar), Priority: 4},
+			{Name: "本地商人", Text: game.GlobalShops.Summary(currentLocationName(groupState)), Priority: 5},
+			{Name: "遭遇预算", Text: groupState.GetEncounterBudgetSummary(), Priority: 3},
+			{Name: "职业特性", Text: groupState.GetClassFeatureSummary(), Priority: 4},
+			{Name: "回合顺序", Text: groupState.GetTurnOrderSummary()},
+		},
+	}
+	return b.Build()
+}
+
+// sceneContext 场景设定部分: 背景的核心设定(第一个 --- 之前)与当前地点描述
+// 背景的其余章节不整份注入，由 loreContext 按最近的对话检索
+func sceneContext(groupState *game.GroupState) string {
+	location := groupState.GetLocationSummary(worldMapFor(groupState))
+	return "【世界观】: " + backgroundCore(backgroundFor(groupState)) + "\n" + location
+}
+
+// loreContext 背景中与最近几条玩家发言最相关的章节 (BM25 检索，不含核心设定)
+func loreContext(groupState *game.GroupState, sess *session.Session) string {
+	bg := backgroundFor(groupState)
+	idx := strings.Index(bg, "\n---")
+	if idx < 0 {
+		return ""
+	}
 
+	query := currentLocationName(groupState)
 	history := sess.GetHistory()
+	for i, n := len(history)-1, 0; i >= 0 && n < 3; i-- {
+		if history[i].Role == openai.ChatMessageRoleUser {
+			query += "\n" + history[i].Content
+			n++
+		}
+	}
+	results := retrieval.IndexFor(bg[idx:]).Search(query, 3)
+	if len(results) == 0 {
+		return ""
+	}
 
-	var requests []openai.ChatCompletionMessage
-	requests = append(requests, openai.ChatCompletionMessage{
-		Role:    openai.ChatMessageRoleSystem,
-		Content: systemPrompt,
-	})
-	requests = append(requests, history...)
+	var sb strings.Builder
+	sb.WriteString("【相关设定】(根据最近的对话从背景中检索):\n")
+	for _, r := range results {
+		sb.WriteString("### " + r.Chunk.Heading + "\n" + r.Chunk.Text + "\n")
+	}
+	return sb.String()
+}
 
-	return ai.GlobalClient.ChatRequest(context.Background(), requests)
+// backgroundFor 本群的背景，没有设置时使用默认背景
+func backgroundFor(groupState *game.GroupState) string {
+	if _, bg := groupState.GetBackground(); bg != "" {
+		return bg
+	}
+	return defaultBackground
+}
+
+// worldMapFor 本群背景文件配套的地图，没有加载背景文件时使用 bg.map.json
+func worldMapFor(groupState *game.GroupState) *game.WorldMap {
+	file, _ := groupState.GetBackground()
+	if file == "" {
+		return game.GlobalWorldMap
+	}
+	m, err := game.WorldMapFor("background/" + file)
+	if err != nil {
+		logrus.Warnf("Could not load map for %s: %v", file, err)
+	}
+	return m
+}
+
+// backgroundCore 背景文件的核心设定，即第一个分隔线 --- 之前的部分
+func backgroundCore(bg string) string {
+	if idx := strings.Index(bg, "\n---"); idx >= 0 {
+		return strings.TrimSpace(bg[:idx])
+	}
+	return bg
+}
+
+// sceneText 最近几条对话与前情提要，用于判断哪些 NPC 与当前场景相关
+func sceneText(sess *session.Session, summary string) string {
+	history := sess.GetHistory()
+	start := len(history) - 6
+	if start < 0 {
+		start = 0
+	}
+
+	var sb strings.Builder
+	sb.WriteString(summary)
+	for _, msg := range history[start:] {
+		sb.WriteString("\n" + msg.Content)
+	}
+	return sb.String()
 }
 
+// checkAndSummarize 历史过长时在后台总结，同一会话同时只运行一个总结
 func checkAndSummarize(groupID int64, sess *session.Session) {
 	currentHistory := sess.GetHistory()
-	if len(currentHistory) >= 20 {
-		fmt.Printf("[Auto-Summary] Triggered (History len: %d)...\n", len(currentHistory))
-		go performSummarization(groupID)
+	if len(currentHistory) < 20 {
+		return
 	}
+	job := sess.BeginSummary()
+	if job == nil {
+		return // 上一次总结还没完成，完成后下一条消息会再次检查
+	}
+	fmt.Printf("[Auto-Summary] Triggered for group %d (History len: %d)...\n", groupID, len(currentHistory))
+	go performSummarization(sess, job)
 }
 
 // getCustomAIResponse 用于非对话流的独立请求，如 introduce
 func getCustomAIResponse(prompt string, groupID int64) (string, error) {
-	systemPrompt := "你是一个 DND 5E 地下城主(DM)。【当前场景与设定】: \n" + CurrentBackground
+	systemPrompt := "你是一个 DND 5E 地下城主(DM)。【当前场景与设定】: \n" + backgroundFor(game.GlobalGameState.GetGroupState(groupID))
 
 	req := []openai.ChatCompletionMessage{
 		{Role: openai.ChatMessageRoleSystem, Content: systemPrompt},
@@ -539,14 +1066,15 @@ func getCustomAIResponse(prompt string, groupID int64) (string, error) {
 	return ai.GlobalClient.ChatRequest(context.Background(), req)
 }
 
-func performSummarization(groupID int64) {
-	sess := session.GlobalManager.GetSession(groupID)
-	history := sess.GetHistory()
-	oldSummary := sess.GetSummary()
+// performSummarization 总结 job 覆盖的消息，期间新增的消息不受影响
+func performSummarization(sess *session.Session, job *session.SummaryJob) {
+	history := job.History
+	oldSummary := job.Summary
 
-	promptContent := "请根据之前的摘要和最近的对话记录，生成一个新的、连贯的【剧情摘要】。\n" +
-		"摘要应包含：当前时间/地点、关键NPC、玩家当前状态、正在进行的任务以及重要物品变动。\n" +
-		"请只输出摘要内容，不要包含其他寒暄。\n\n"
+	promptContent := "请根据之前的摘要和最近的对话记录，输出两部分内容，中间用单独一行 " + chapterSeparator + " 分隔：\n" +
+		"第一部分是【本章摘要】：只概括最近对话记录中发生的事件 (地点、遇到的 NPC、做出的选择、战斗与收获)，保留专有名词，作为可供日后查阅的章节记录。\n" +
+		"第二部分是新的、连贯的【剧情摘要】：应包含当前时间/地点、关键NPC、玩家当前状态、正在进行的任务以及重要物品变动。\n" +
+		"请只输出这两部分内容，不要包含标题或其他寒暄。\n\n"
 
 	if oldSummary != "" {
 		promptContent += fmt.Sprintf("【之前的摘要】:\n%s\n\n", oldSummary)
@@ -569,62 +1097,243 @@ func performSummarization(groupID int64) {
 	}
 
 	fmt.Println("[Auto-Summary] Generating summary...")
-	newSummary, err := ai.GlobalClient.ChatRequest(context.Background(), req)
+	output, err := ai.GlobalClient.ChatRequest(context.Background(), req)
 	if err != nil {
 		fmt.Printf("[Auto-Summary] Failed: %v\n", err)
+		sess.AbortSummary(job)
 		return
 	}
 
-	sess.UpdateSummary(newSummary, 5)
-	fmt.Printf("[Auto-Summary] Updated.\nNew Summary: %s\n", newSummary)
-}
-
-// --- AI Action Handling ---
-
-type AIAction struct {
-	Type   string `json:"type"`   // "roll", "hp", "spawn_npc"
-	Expr   string `json:"expr"`   // For roll, e.g., "1d20"
-	Target string `json:"target"` // For hp, character name
-	Value  int    `json:"value"`  // For hp, amount to change
-	Reason string `json:"reason"` // Description
+	// 模型没有按格式输出时，整段同时作为本章摘要与剧情摘要
+	chapter, newSummary := output, output
+	if before, after, ok := strings.Cut(output, "\n"+chapterSeparator+"\n"); ok {
+		chapter, newSummary = strings.TrimSpace(before), strings.TrimSpace(after)
+	}
 
-	// For spawn_npc
-	Name  string `json:"name"`
-	Class string `json:"class"`
-	HP    int    `json:"hp"`
-	MaxHP int    `json:"max_hp"`
-	STR   int    `json:"str"`
-	IsAI  bool   `json:"is_ai"`
+	if !sess.FinishSummary(job, newSummary, 5) {
+		fmt.Println("[Auto-Summary] Discarded: session was reset or summary edited while summarizing.")
+		return
+	}
+	c := sess.AddChapter(chapter)
+	fmt.Printf("[Auto-Summary] Updated.\n%s: %s\nNew Summary: %s\n", c.Title(), chapter, newSummary)
 }
 
-func processAIActionsAndGetLogs(response string, groupID int64) []string {
-	var logs []string
-
-	// Extract JSON block using Regex
-	re := regexp.MustCompile(`(?s)<dnd_action>(.*?)</dnd_action>`)
-	matches := re.FindStringSubmatch(response)
+// chapterSeparator 自动总结输出中本章摘要与剧情摘要的分隔行
+const chapterSeparator = "==="
 
-	if len(matches) < 2 {
-		return logs
+// episodeContext 玩家提到过去的事件时，检索相关的章节摘要
+func episodeContext(sess *session.Session) string {
+	query := sess.RecallQuery(3)
+	if query == "" {
+		return ""
+	}
+	chapters := sess.SearchChapters(query, 2)
+	if len(chapters) == 0 {
+		return ""
 	}
 
-	jsonStr := strings.TrimSpace(matches[1])
-	var actions []AIAction
+	var sb strings.Builder
+	sb.WriteString("【往事回顾】(玩家提到了过去的事件，以下是相关章节的记录):\n")
+	for _, c := range chapters {
+		sb.WriteString(c.Title() + ": " + c.Summary + "\n")
+	}
+	return sb.String()
+}
 
-	// Try parsing
-	err := json.Unmarshal([]byte(jsonStr), &actions)
+// handleUndo 处理 .undo: 撤销上一回合的玩家发言、DM 回复以及回合内 Action 造成的全部变化
+func handleUndo(groupID int64) string {
+	e, err := journal.GlobalJournal.Undo(groupID)
 	if err != nil {
-		var singleAction AIAction
-		if err2 := json.Unmarshal([]byte(jsonStr), &singleAction); err2 == nil {
-			actions = append(actions, singleAction)
-		} else {
-			return logs
-		}
+		return fmt.Sprintf("Error: %v", err)
+	}
+	label := []rune(e.Label)
+	if len(label) > 40 {
+		label = append(label[:40], '…')
 	}
+	return fmt.Sprintf("↩️ 已撤销回合「%s」: 发言、DM 回复以及回合内的血量、怪物、时间、战利品、任务等变化已恢复 (还可以撤销 %d 次)", string(label), journal.GlobalJournal.Len(groupID))
+}
 
-	groupState := game.GlobalGameState.GetGroupState(groupID)
+// rerollVotesNeeded 非 GM 重新生成 DM 回复所需的票数: 有角色的玩家过半数
+func rerollVotesNeeded(groupID int64) int {
+	return game.GlobalGameState.GetGroupState(groupID).CountPlayers()/2 + 1
+}
+
+// handleSummary 处理 .summary / .summary history / .summary edit <摘要> / .summary revert <n>
+// 用于查看并纠正 AI 记住的剧情摘要
+func handleSummary(groupID int64, args []string) (string, string) {
 	sess := session.GlobalManager.GetSession(groupID)
+	usage := "Usage: .summary [history] / .summary edit <摘要> / .summary revert <n>"
 
+	if len(args) == 0 {
+		summary := sess.GetSummary()
+		if summary == "" {
+			return "还没有剧情摘要，对话达到一定长度后会自动生成。", ""
+		}
+		return "📝 当前剧情摘要:\n" + summary, ""
+	}
+
+	switch args[0] {
+	case "history":
+		versions := sess.GetVersions()
+		if len(versions) == 0 {
+			return "没有历史摘要。", ""
+		}
+		var sb strings.Builder
+		sb.WriteString("🕘 历史摘要 (编号越小越新):")
+		for i := len(versions) - 1; i >= 0; i-- {
+			v := versions[i]
+			text := []rune(v.Summary)
+			if len(text) > 60 {
+				text = append(text[:60], '…')
+			}
+			sb.WriteString(fmt.Sprintf("\n  #%d (%s 被%s替换) %s", len(versions)-i, v.ReplacedAt.Format("01-02 15:04"), v.ReplacedBy, string(text)))
+		}
+		sb.WriteString("\n(使用 .summary revert <编号> 恢复)")
+		return sb.String(), ""
+
+	case "edit":
+		text := strings.TrimSpace(strings.Join(args[1:], " "))
+		if text == "" {
+			return usage, ""
+		}
+		sess.EditSummary(text)
+		return "剧情摘要已更新，旧版本可用 .summary history 查看。", "System: GM 修正了剧情摘要，以【前情提要】中的最新内容为准。"
+
+	case "revert":
+		if len(args) < 2 {
+			return usage, ""
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			return usage, ""
+		}
+		if _, err := sess.RevertSummary(n); err != nil {
+			return fmt.Sprintf("Error: %v", err), ""
+		}
+		return fmt.Sprintf("已恢复第 %d 份历史摘要。", n), "System: GM 恢复了之前的剧情摘要，以【前情提要】中的最新内容为准。"
+	}
+	return usage, ""
+}
+
+// handleRecall 处理 .recall [关键词]: 不带参数列出所有章节，带参数检索相关章节
+func handleRecall(groupID int64, args []string) string {
+	sess := session.GlobalManager.GetSession(groupID)
+	if len(args) == 0 {
+		chapters := sess.GetChapters()
+		if len(chapters) == 0 {
+			return "还没有章节记录，剧情推进一段时间后会自动生成。"
+		}
+		var sb strings.Builder
+		sb.WriteString("📖 章节记录:")
+		for _, c := range chapters {
+			summary := []rune(c.Summary)
+			if len(summary) > 40 {
+				summary = append(summary[:40], '…')
+			}
+			sb.WriteString(fmt.Sprintf("\n  %s %s", c.Title(), string(summary)))
+		}
+		sb.WriteString("\n(使用 .recall <关键词> 查看相关章节全文)")
+		return sb.String()
+	}
+
+	chapters := sess.SearchChapters(strings.Join(args, " "), 3)
+	if len(chapters) == 0 {
+		return "没有找到相关的章节。"
+	}
+	parts := make([]string, 0, len(chapters))
+	for _, c := range chapters {
+		parts = append(parts, "📖 "+c.Title()+"\n"+c.Summary)
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// --- AI Action Handling ---
+
+type AIAction struct {
+	Type   string `json:"type"`   // "roll", "hp", "spawn_npc", "attack", "npc_add", "npc_update", "quest_add", "quest_update", "move_party", "advance_time", "schedule_event", "set_status", "table_roll", "loot", "party_supply"
+	Expr   string `json:"expr"`   // For roll, e.g., "1d20"
+	Target string `json:"target"` // For hp/attack, character name
+	Value  int    `json:"value"`  // For hp, amount to change
+	Reason string `json:"reason"` // Description
+
+	// For attack
+	Attacker string `json:"attacker"`
+	Weapon   string `json:"weapon"`
+	Damage   string `json:"damage"` // 未登记武器的伤害骰，例如 1d6+2
+	Bonus    *int   `json:"bonus"`  // 命中加值，省略时按属性计算
+
+	// For spawn_npc
+	Name  string `json:"name"`
+	Class string `json:"class"`
+	HP    int    `json:"hp"`
+	MaxHP int    `json:"max_hp"`
+	STR   int    `json:"str"`
+	DEX   int    `json:"dex"`
+	IsAI  bool   `json:"is_ai"`
+
+	// For spawn_npc from bestiary
+	Template string `json:"template"`
+	Count    int    `json:"count"`
+
+	// For npc_add / npc_update
+	Description string            `json:"description"`
+	Location    string            `json:"location"`
+	Attitude    map[string]string `json:"attitude"` // PC 名称 -> 态度
+	Fact        string            `json:"fact"`
+	Alive       *bool             `json:"alive"`
+
+	// For quest_add / quest_update
+	Title      string   `json:"title"`
+	Status     string   `json:"status"`     // active/completed/failed
+	Objectives []string `json:"objectives"` // 追加的目标
+	Done       []string `json:"done"`       // 已完成的目标
+	Rewards    string   `json:"rewards"`
+
+	// For move_party
+	To string `json:"to"`
+
+	// For advance_time / schedule_event / set_status
+	Minutes int    `json:"minutes"`
+	Rest    string `json:"rest"` // "long" / "short"
+	Text    string `json:"text"` // 定时事件内容
+
+	// For table_roll
+	Table string `json:"table"`
+
+	// For loot
+	CR string `json:"cr"`
+}
+
+// processAIActionsAndGetLogs 执行 DM 回复中的 Action，作用于回合所在的群组状态与会话
+// Action 造成的所有状态变化都记入回合，.undo / .reroll-dm 时反向执行
+func processAIActionsAndGetLogs(response string, groupID int64, turn *journal.Entry) []string {
+	var logs []string
+
+	// Extract JSON block using Regex
+	matches := ai.ActionPattern.FindStringSubmatch(response)
+
+	if len(matches) < 2 {
+		return logs
+	}
+
+	jsonStr := strings.TrimSpace(matches[1])
+	var actions []AIAction
+
+	// Try parsing
+	err := json.Unmarshal([]byte(jsonStr), &actions)
+	if err != nil {
+		var singleAction AIAction
+		if err2 := json.Unmarshal([]byte(jsonStr), &singleAction); err2 == nil {
+			actions = append(actions, singleAction)
+		} else {
+			return logs
+		}
+	}
+
+	groupState := turn.State()
+	// 血量变化与生成的角色逐个记录，其余修改 (时间、事件、地点、储物、NPC、任务、状态……) 比较前后整体记录
+	mark := groupState.MarkState()
+	defer func() { turn.RecordState(groupState.ChangeSince(mark)) }()
 	for _, action := range actions {
 		switch action.Type {
 		case "roll":
@@ -638,54 +1347,85 @@ func processAIActionsAndGetLogs(response string, groupID int64) []string {
 
 			msg := fmt.Sprintf("System: (AI Action) %s, Result: %s", action.Reason, res.String())
 			logs = append(logs, msg)
-			sess.AddMessage(openai.ChatMessageRoleSystem, msg)
+			turn.AddMessage(openai.ChatMessageRoleSystem, msg)
 
 		case "hp":
 			if action.Target == "" {
 				continue
 			}
-			char := groupState.GetCharacter(action.Target)
-			if char == nil {
-				// 尝试自动修正：如果找不到该角色，检查是否只是因为没有创建
-				// 但这里不能凭空创建，因为缺少 MaxHP 等信息
-				logs = append(logs, fmt.Sprintf("Warning: AI tried to modify HP for unknown char '%s'", action.Target))
+			change, err := groupState.ApplyHPChange(action.Target, action.Value)
+			if err != nil {
+				// 找不到角色时不能凭空创建，因为缺少 MaxHP 等信息
+				logs = append(logs, fmt.Sprintf("Warning: AI tried to modify HP for %v", err))
 				continue
 			}
+			turn.RecordHP(change)
 
-			oldHP := char.HP
-			char.HP += action.Value
-			if char.HP > char.MaxHP {
-				char.HP = char.MaxHP
+			msg := fmt.Sprintf("System: (AI Action) %s HP changes by %d (%d -> %d)", change.Name, change.Delta, change.OldHP, change.NewHP)
+			logs = append(logs, msg)
+			turn.AddMessage(openai.ChatMessageRoleSystem, msg) // Update Session
+
+			if deathMsg := deathAnnouncement(change); deathMsg != "" {
+				logs = append(logs, deathMsg)
+				turn.AddMessage(openai.ChatMessageRoleSystem, deathMsg)
+			}
+
+		case "attack":
+			attacker := action.Attacker
+			if attacker == "" {
+				attacker = action.Name
+			}
+			if attacker == "" || action.Target == "" {
+				continue
 			}
+			result, err := groupState.ResolveAttack(game.AttackRequest{
+				Attacker: attacker,
+				Target:   action.Target,
+				Weapon:   action.Weapon,
+				Damage:   action.Damage,
+				Bonus:    action.Bonus,
+			})
+			if err != nil {
+				logs = append(logs, fmt.Sprintf("Warning: AI attack failed: %v", err))
+				continue
+			}
+			turn.RecordHP(result.HP)
 
-			msg := fmt.Sprintf("System: (AI Action) %s HP changes by %d (%d -> %d)", char.Name, action.Value, oldHP, char.HP)
+			msg := fmt.Sprintf("System: (AI Action) %s", result.String())
 			logs = append(logs, msg)
-			sess.AddMessage(openai.ChatMessageRoleSystem, msg) // Update Session
-
-			if char.HP <= 0 {
-				// 对于 NPC，死亡通常意味着移除
-				// 对于 玩家，可能只是倒地（这里简化处理，统一移除或标记）
-				if char.IsAI {
-					groupState.RemoveCharacter(char.Name)
-					deathMsg := fmt.Sprintf("【系统公告】敌对生物 %s 已死亡。", char.Name)
-					logs = append(logs, deathMsg)
-					sess.AddMessage(openai.ChatMessageRoleSystem, deathMsg)
-				} else {
-					char.Status = "昏迷"
-					char.HP = 0
-					deathMsg := fmt.Sprintf("【系统公告】玩家 %s 已昏迷 (HP: 0)。需要治疗或豁免检定。", char.Name)
-					logs = append(logs, deathMsg)
-					sess.AddMessage(openai.ChatMessageRoleSystem, deathMsg)
-				}
+			turn.AddMessage(openai.ChatMessageRoleSystem, msg)
+			if deathMsg := deathAnnouncement(result.HP); deathMsg != "" {
+				logs = append(logs, deathMsg)
+				turn.AddMessage(openai.ChatMessageRoleSystem, deathMsg)
 			}
 
 		case "spawn_npc":
-			if action.Name == "" {
+			if action.Template != "" {
+				monster := game.GlobalBestiary.Get(action.Template)
+				if monster != nil {
+					for _, newChar := range groupState.SpawnMonsters(monster, action.Name, action.Count) {
+						turn.RecordSpawn(newChar, nil)
+						msg := fmt.Sprintf("System: (AI Action) New Entity Appears: %s (%s, CR %s) HP:%d AC:%d",
+							newChar.Name, newChar.Template, newChar.CR, newChar.HP, newChar.AC)
+						logs = append(logs, msg)
+						turn.AddMessage(openai.ChatMessageRoleSystem, msg)
+					}
+					continue
+				}
+				logs = append(logs, fmt.Sprintf("Warning: AI tried to spawn unknown template '%s'", action.Template))
+				if action.Name == "" {
+					action.Name = action.Template
+				}
+			}
+			if action.Name == "" || action.HP <= 0 {
 				continue
 			}
 			if action.MaxHP == 0 {
 				action.MaxHP = action.HP
 			}
+			if action.DEX == 0 {
+				action.DEX = 10
+			}
 			// 默认为 AI
 			if !action.IsAI {
 				action.IsAI = true
@@ -697,13 +1437,183 @@ func processAIActionsAndGetLogs(response string, groupID int64) []string {
 				HP:    action.HP,
 				MaxHP: action.MaxHP,
 				STR:   action.STR,
+				DEX:   action.DEX,
 				IsAI:  action.IsAI,
 			}
+			replaced := groupState.GetCharacter(newChar.Name)
 			groupState.AddCharacter(newChar)
+			turn.RecordSpawn(newChar, replaced)
 
 			msg := fmt.Sprintf("System: (AI Action) New Entity Appears: %s (%s) HP:%d", newChar.Name, newChar.Class, newChar.HP)
 			logs = append(logs, msg)
-			sess.AddMessage(openai.ChatMessageRoleSystem, msg)
+			turn.AddMessage(openai.ChatMessageRoleSystem, msg)
+
+		case "npc_add", "npc_update":
+			if action.Name == "" {
+				continue
+			}
+			npc, created := groupState.UpsertNPC(game.NPCUpdate{
+				Name:        action.Name,
+				Description: action.Description,
+				Location:    action.Location,
+				Attitudes:   action.Attitude,
+				Fact:        action.Fact,
+				Alive:       action.Alive,
+			})
+
+			verb := "Updated"
+			if created {
+				verb = "Recorded"
+			}
+			msg := fmt.Sprintf("System: (AI Action) NPC %s: %s", verb, npc.String())
+			logs = append(logs, msg)
+			turn.AddMessage(openai.ChatMessageRoleSystem, msg)
+
+		case "quest_add", "quest_update":
+			if action.Title == "" {
+				continue
+			}
+			quest, created := groupState.UpsertQuest(game.QuestUpdate{
+				Title:      action.Title,
+				Status:     action.Status,
+				Objectives: action.Objectives,
+				Done:       action.Done,
+				Rewards:    action.Rewards,
+			})
+
+			verb := "Updated"
+			if created {
+				verb = "Added"
+			}
+			msg := fmt.Sprintf("System: (AI Action) Quest %s: %s", verb, quest.String())
+			logs = append(logs, msg)
+			turn.AddMessage(openai.ChatMessageRoleSystem, msg)
+
+		case "move_party":
+			if action.To == "" {
+				continue
+			}
+			travel, err := groupState.MoveParty(worldMapFor(groupState), action.To)
+			if err != nil {
+				logs = append(logs, fmt.Sprintf("Warning: AI move_party failed: %v", err))
+				continue
+			}
+
+			msg := fmt.Sprintf("System: (AI Action) Party travels %s (%s)", strings.Join(travel.Path, " -> "), game.FormatMinutes(travel.Minutes))
+			logs = append(logs, msg)
+			turn.AddMessage(openai.ChatMessageRoleSystem, msg)
+
+			adv := groupState.AdvanceTime(game.GlobalCalendar, travel.Minutes)
+			msg = "System: (AI Action) " + adv.String()
+			logs = append(logs, msg)
+			turn.AddMessage(openai.ChatMessageRoleSystem, msg)
+
+		case "advance_time":
+			var msg string
+			if action.Rest != "" {
+				rest, err := groupState.Rest(game.GlobalCalendar, action.Rest)
+				if err != nil {
+					msg = fmt.Sprintf("System: (AI Action) 休息失败: %v", err)
+				} else {
+					for _, change := range rest.HP {
+						turn.RecordHP(change)
+					}
+					kind := "短休"
+					if rest.Kind == "long" {
+						kind = "长休"
+					}
+					msg = fmt.Sprintf("System: (AI Action) 队伍进行了%s。%s\n恢复: %s", kind, rest.Advance.String(), strings.Join(rest.Healed, ", "))
+				}
+			} else if action.Minutes > 0 {
+				msg = "System: (AI Action) " + groupState.AdvanceTime(game.GlobalCalendar, action.Minutes).String()
+			} else {
+				continue
+			}
+			logs = append(logs, msg)
+			turn.AddMessage(openai.ChatMessageRoleSystem, msg)
+
+		case "schedule_event":
+			if action.Text == "" || action.Minutes <= 0 {
+				continue
+			}
+			groupState.ScheduleEvent(action.Minutes, action.Text)
+			// 事件内容只对 DM 可见: 只记录在服务器日志中，群里只播报已安排事件
+			logrus.Infof("Group %d: event scheduled in %s: %s", groupID, game.FormatMinutes(action.Minutes), action.Text)
+			logs = append(logs, "System: (AI Action) 已安排一个事件")
+
+		case "table_roll":
+			table := game.GlobalTables.Get(action.Table)
+			if table == nil {
+				logs = append(logs, fmt.Sprintf("Warning: AI tried to roll on unknown table '%s'", action.Table))
+				continue
+			}
+			roll, err := table.Roll()
+			if err != nil {
+				logs = append(logs, fmt.Sprintf("Warning: AI table roll failed: %v", err))
+				continue
+			}
+
+			msg := fmt.Sprintf("System: (AI Action) %s, %s", action.Reason, roll.String())
+			logs = append(logs, msg)
+			turn.AddMessage(openai.ChatMessageRoleSystem, msg)
+
+		case "loot":
+			hoard := &game.Hoard{}
+			if action.Name != "" {
+				// 剧情固定奖励，例如祭坛中的迷雾灯笼
+				qty := action.Count
+				if qty < 1 {
+					qty = 1
+				}
+				hoard.Items = []game.Item{{Name: action.Name, Qty: qty}}
+			} else {
+				cr := action.CR
+				if m := game.GlobalBestiary.Get(action.Template); m != nil && cr == "" {
+					cr = m.CR
+				}
+				var err error
+				if hoard, err = game.GlobalLoot.Roll(cr); err != nil {
+					logs = append(logs, fmt.Sprintf("Warning: AI loot failed: %v", err))
+					continue
+				}
+			}
+			groupState.DepositLoot(hoard)
+
+			msg := fmt.Sprintf("System: (AI Action) %s 获得战利品(已放入队伍储物): %s", action.Reason, hoard.String())
+			logs = append(logs, msg)
+			turn.AddMessage(openai.ChatMessageRoleSystem, msg)
+
+		case "party_supply":
+			if action.Name == "" || action.Value == 0 {
+				continue
+			}
+			left, err := groupState.AdjustSupply(action.Name, action.Value)
+			if err != nil {
+				logs = append(logs, fmt.Sprintf("Warning: AI party_supply failed: %v", err))
+				continue
+			}
+
+			msg := fmt.Sprintf("System: (AI Action) %s 公用物资 %s %+d，剩余 %d", action.Reason, action.Name, action.Value, left)
+			logs = append(logs, msg)
+			turn.AddMessage(openai.ChatMessageRoleSystem, msg)
+
+		case "set_status":
+			if action.Target == "" {
+				continue
+			}
+			if err := groupState.SetCondition(action.Target, action.Status, action.Minutes); err != nil {
+				logs = append(logs, fmt.Sprintf("Warning: AI set_status failed: %v", err))
+				continue
+			}
+
+			msg := fmt.Sprintf("System: (AI Action) %s 的状态变为 [%s]", action.Target, action.Status)
+			if action.Status == "" {
+				msg = fmt.Sprintf("System: (AI Action) %s 的状态已解除", action.Target)
+			} else if action.Minutes > 0 {
+				msg += fmt.Sprintf("，持续 %s", game.FormatMinutes(action.Minutes))
+			}
+			logs = append(logs, msg)
+			turn.AddMessage(openai.ChatMessageRoleSystem, msg)
 
 		default:
 		}
@@ -711,8 +1621,755 @@ func processAIActionsAndGetLogs(response string, groupID int64) []string {
 	return logs
 }
 
+// --- Combat Helpers ---
+
+// deathAnnouncement 生命归零时的系统公告，未归零返回空字符串
+func deathAnnouncement(change *game.HPChange) string {
+	switch {
+	case change == nil:
+		return ""
+	case change.Removed:
+		return fmt.Sprintf("【系统公告】敌对生物 %s 已死亡。", change.Name)
+	case change.Downed:
+		return fmt.Sprintf("【系统公告】玩家 %s 已昏迷 (HP: 0)。需要治疗或豁免检定。", change.Name)
+	}
+	return ""
+}
+
+// handleAttack 处理玩家 .atk [target] [weapon]，返回结算文本与写入上下文的日志
+// 造成的血量变化记入回合，可以 .undo
+func handleAttack(turn *journal.Entry, ownerID int64, args []string) (string, string) {
+	var by string
+	var rest []string
+	for _, arg := range args {
+		if name, ok := strings.CutPrefix(arg, "by="); ok {
+			by = name
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	if len(rest) < 1 {
+		return "Usage: .atk [target] [weapon] [by=角色名]", ""
+	}
+
+	groupState := turn.State()
+	attacker, err := groupState.AttackerFor(ownerID, by)
+	if err != nil {
+		return err.Error(), ""
+	}
+
+	req := game.AttackRequest{Attacker: attacker.Name, Target: rest[0]}
+	if len(rest) > 1 {
+		req.Weapon = rest[1]
+	}
+	result, err := groupState.ResolveAttack(req)
+	if err != nil {
+		return fmt.Sprintf("Error: %v", err), ""
+	}
+	turn.RecordHP(result.HP)
+
+	reply := result.String()
+	if deathMsg := deathAnnouncement(result.HP); deathMsg != "" {
+		reply += "\n" + deathMsg
+	}
+	return reply, "【系统提示】" + reply
+}
+
+// handleInitiative 处理 .init [show|end|strict]，返回回复文本与需要写入上下文的日志
+func handleInitiative(groupID int64, args []string) (string, string) {
+	groupState := game.GlobalGameState.GetGroupState(groupID)
+
+	if len(args) > 0 {
+		switch args[0] {
+		case "show":
+			enc := groupState.GetEncounter()
+			if enc == nil {
+				return "当前没有进行中的战斗。", ""
+			}
+			return enc.String(), ""
+		case "end":
+			if !groupState.EndEncounter() {
+				return "当前没有进行中的战斗。", ""
+			}
+			return "⚔️ 战斗结束。", "【系统提示】战斗结束，先攻顺序已清除。"
+		case "strict":
+			strict := len(args) < 2 || args[1] == "on"
+			if err := groupState.SetStrictTurns(strict); err != nil {
+				return err.Error(), ""
+			}
+			if !strict {
+				return "严格回合模式已关闭，所有玩家都可以自由发言。", ""
+			}
+			return "严格回合模式已开启：只有当前行动者的发言会转交给 DM，NPC 回合由 DM 自动结算。", ""
+		default:
+			return "Usage: .init [show|end|strict on/off]", ""
+		}
+	}
+
+	enc := groupState.StartEncounter()
+	if len(enc.Order) == 0 {
+		groupState.EndEncounter()
+		return "当前没有角色，无法投先攻。", ""
+	}
+	current := enc.Current()
+	reply := fmt.Sprintf("⚔️ 战斗开始！\n%s\n轮到 %s 行动。", enc.String(), current.Name)
+	return reply, "【系统提示】战斗开始，已投先攻。\n" + enc.String()
+}
+
+// handleNextTurn 处理 .next，推进回合
+func handleNextTurn(groupID int64) (string, string) {
+	current, newRound, err := game.GlobalGameState.GetGroupState(groupID).NextTurn()
+	if err != nil {
+		return err.Error(), ""
+	}
+
+	reply := fmt.Sprintf("轮到 %s 行动。", current.Name)
+	if newRound {
+		enc := game.GlobalGameState.GetGroupState(groupID).GetEncounter()
+		reply = fmt.Sprintf("—— 第 %d 轮 ——\n%s", enc.Round, reply)
+	}
+	return reply, "【系统提示】" + reply
+}
+
+// advanceStrictTurn 严格回合模式下，DM 结算完当前行动后推进到下一位玩家
+// 返回需要播报的提示，非严格模式返回空字符串
+func advanceStrictTurn(turn *journal.Entry) string {
+	mark := turn.State().MarkTurn()
+	current, skipped := turn.State().AdvanceStrictTurn()
+	if current == nil {
+		return ""
+	}
+	turn.RecordTurn(mark)
+
+	notice := fmt.Sprintf("轮到 %s 行动。", current.Name)
+	if len(skipped) > 0 {
+		notice = fmt.Sprintf("(NPC %s 的回合已结算) %s", strings.Join(skipped, "、"), notice)
+	}
+	turn.AddMessage(openai.ChatMessageRoleUser, "【系统提示】"+notice)
+	return notice
+}
+
+// handleEncounterRating 处理 .encounter [怪物x数量 ...]
+// 不带参数时评估当前场上的 NPC，带参数时评估计划中的怪物组合
+func handleEncounterRating(groupID int64, args []string) string {
+	groupState := game.GlobalGameState.GetGroupState(groupID)
+	levels := groupState.PartyLevels()
+	if len(levels) == 0 {
+		return "当前没有玩家角色，无法计算遭遇预算。"
+	}
+
+	if len(args) == 0 {
+		return "【遭遇评估: 当前敌人】\n" + groupState.RateCurrentEnemies().String()
+	}
+
+	var crs []string
+	for _, arg := range args {
+		name, count := arg, 1
+		if idx := strings.LastIndexAny(arg, "xX*"); idx > 0 {
+			if n, err := strconv.Atoi(arg[idx+1:]); err == nil && n > 0 {
+				name, count = arg[:idx], n
+			}
+		}
+		monster := game.GlobalBestiary.Get(name)
+		if monster == nil {
+			return fmt.Sprintf("图鉴中没有怪物: %s", name)
+		}
+		for i := 0; i < count; i++ {
+			crs = append(crs, monster.CR)
+		}
+	}
+	return "【遭遇评估: " + strings.Join(args, " ") + "】\n" + game.RateEncounter(levels, crs).String()
+}
+
 // --- Helper Functions ---
 
+// handleNPCs 处理 .npcs [名称]
+// 不带参数时列出所有已记录的 NPC，带参数时显示该 NPC 的完整档案
+func handleNPCs(groupID int64, args []string) string {
+	groupState := game.GlobalGameState.GetGroupState(groupID)
+	if len(args) > 0 {
+		name := strings.Join(args, " ")
+		npc := groupState.GetNPC(name)
+		if npc == nil {
+			return fmt.Sprintf("没有关于 %s 的记录。", name)
+		}
+		return npc.String()
+	}
+
+	npcs := groupState.ListNPCs()
+	if len(npcs) == 0 {
+		return "还没有记录任何 NPC。"
+	}
+	var sb strings.Builder
+	sb.WriteString("【NPC 名录】")
+	for _, npc := range npcs {
+		line := "\n- " + npc.Name
+		if !npc.Alive {
+			line += " [已死亡]"
+		}
+		if npc.Description != "" {
+			line += ": " + npc.Description
+		}
+		sb.WriteString(line)
+	}
+	return sb.String()
+}
+
+// handleTableRoll 处理 .table [表名]，不带参数时列出所有随机表
+func handleTableRoll(roller string, args []string) (string, string) {
+	if len(args) == 0 {
+		names := game.GlobalTables.Names()
+		if len(names) == 0 {
+			return "background/ 下没有找到随机表。", ""
+		}
+		return "可用的随机表: " + strings.Join(names, ", "), ""
+	}
+
+	name := strings.Join(args, " ")
+	table := game.GlobalTables.Get(name)
+	if table == nil {
+		return fmt.Sprintf("找不到随机表: %s", name), ""
+	}
+	roll, err := table.Roll()
+	if err != nil {
+		return fmt.Sprintf("Error: %v", err), ""
+	}
+	return roll.String(), fmt.Sprintf("【系统提示】玩家(%s) 在随机表上掷骰 %s", roller, roll.String())
+}
+
+// handleStash 处理 .stash [split]
+// 不带参数时查看队伍储物，split 将钱币平分给所有玩家角色
+func handleStash(groupID int64, args []string) (string, string) {
+	groupState := game.GlobalGameState.GetGroupState(groupID)
+	if len(args) > 0 && args[0] == "split" {
+		share, names, err := groupState.SplitCoins()
+		if err != nil {
+			return fmt.Sprintf("Error: %v", err), ""
+		}
+		reply := fmt.Sprintf("储物中的钱币已平分: %s 每人获得 %s", strings.Join(names, "、"), game.FormatCoins(share))
+		return reply, "【系统提示】" + reply
+	}
+
+	summary := groupState.GetStashSummary()
+	if summary == "" {
+		return "队伍储物是空的。", ""
+	}
+	return strings.TrimSpace(summary) + "\n(使用 .claim <物品> [数量] 领取，.claim 30银 领取钱币，.stash split 平分钱币)", ""
+}
+
+// handleClaim 处理 .claim <物品|金额> [数量]，从队伍储物领取到自己的角色
+func handleClaim(groupID int64, ownerID int64, args []string) (string, string) {
+	if len(args) < 1 {
+		return "Usage: .claim <物品> [数量] 或 .claim 30银", ""
+	}
+	groupState := game.GlobalGameState.GetGroupState(groupID)
+	char := groupState.FindCharacterByOwner(ownerID)
+	if char == nil {
+		return "你还没有角色卡，请先使用 .st 创建角色。", ""
+	}
+
+	var reply string
+	if copper, ok := game.ParseCoins(args[0]); ok && len(args) == 1 {
+		if err := groupState.ClaimCoins(char.Name, copper); err != nil {
+			return fmt.Sprintf("Error: %v", err), ""
+		}
+		reply = fmt.Sprintf("%s 从队伍储物中取走了 %s", char.Name, game.FormatCoins(copper))
+	} else {
+		item, qty := strings.Join(args, " "), 1
+		if len(args) > 1 {
+			if n, err := strconv.Atoi(args[len(args)-1]); err == nil && n > 0 {
+				item, qty = strings.Join(args[:len(args)-1], " "), n
+			}
+		}
+		name, err := groupState.ClaimItem(char.Name, item, qty)
+		if err != nil {
+			return fmt.Sprintf("Error: %v", err), ""
+		}
+		reply = fmt.Sprintf("%s 从队伍储物中领取了 %s x%d", char.Name, name, qty)
+	}
+	return reply, "【系统提示】" + reply
+}
+
+// handlePartyCommand 处理 .party / .march [角色...] / .watch [班次...|clear] / .supply [add|use <物品> [数量]]
+// .watch 的每个参数是一班，同一班多人用 + 连接，例如 .watch 亚瑟 派蒙+梅林
+func handlePartyCommand(groupID int64, cmd string, args []string) (string, string) {
+	groupState := game.GlobalGameState.GetGroupState(groupID)
+
+	var reply string
+	switch {
+	case cmd == ".party" || len(args) == 0:
+		block := groupState.GetParty().String(game.GlobalCalendar.LongRestHours * 60)
+		if block == "" {
+			return "还没有设置行进队列、守夜或公用物资。\n(使用 .march、.watch、.supply add 设置)", ""
+		}
+		return "👥 队伍\n" + block, ""
+
+	case cmd == ".march":
+		if args[0] == "clear" {
+			args = nil
+		}
+		if err := groupState.SetMarchingOrder(args); err != nil {
+			return fmt.Sprintf("Error: %v", err), ""
+		}
+		reply = "行进队列已清除"
+		if len(args) > 0 {
+			reply = "行进队列更新为: " + strings.Join(groupState.GetParty().MarchingOrder, " → ")
+		}
+
+	case cmd == ".watch":
+		var shifts [][]string
+		if args[0] != "clear" {
+			for _, a := range args {
+				shifts = append(shifts, strings.Split(a, "+"))
+			}
+		}
+		if err := groupState.SetWatches(shifts); err != nil {
+			return fmt.Sprintf("Error: %v", err), ""
+		}
+		reply = "守夜安排已清除"
+		if len(shifts) > 0 {
+			var parts []string
+			for i, shift := range groupState.GetParty().Watches {
+				parts = append(parts, fmt.Sprintf("第%d班 %s", i+1, strings.Join(shift, "+")))
+			}
+			reply = "守夜安排更新为: " + strings.Join(parts, ", ")
+		}
+
+	default: // .supply
+		if len(args) < 2 || (args[0] != "add" && args[0] != "use") {
+			return "Usage: .supply [add|use] <物品> [数量]", ""
+		}
+		item, qty := strings.Join(args[1:], " "), 1
+		if len(args) > 2 {
+			if n, err := strconv.Atoi(args[len(args)-1]); err == nil && n > 0 {
+				item, qty = strings.Join(args[1:len(args)-1], " "), n
+			}
+		}
+		delta, verb := qty, "存入"
+		if args[0] == "use" {
+			delta, verb = -qty, "消耗"
+		}
+		left, err := groupState.AdjustSupply(item, delta)
+		if err != nil {
+			return fmt.Sprintf("Error: %v", err), ""
+		}
+		reply = fmt.Sprintf("公用物资%s %s x%d，剩余 %d", verb, item, qty, left)
+	}
+	return reply, "【系统提示】" + reply
+}
+
+// handleBackground 处理 .bg [场景描述] / .bg load <文件名>
+// 不带参数时显示本群背景与 background/ 下可加载的文件
+func handleBackground(groupID int64, args []string) (string, string) {
+	groupState := game.GlobalGameState.GetGroupState(groupID)
+	if len(args) == 0 {
+		file, bg := groupState.GetBackground()
+		current := "默认背景 (bg.md)"
+		switch {
+		case file != "":
+			current = file
+		case bg != "":
+			current = "手写的场景描述"
+		}
+		files, _ := filepath.Glob("background/*.md")
+		for i := range files {
+			files[i] = filepath.Base(files[i])
+		}
+		return fmt.Sprintf("本群背景: %s\n可加载: %s\n(使用 .bg load <文件名> 更换背景，.bg <描述> 更新当前场景)", current, strings.Join(files, ", ")), ""
+	}
+
+	if args[0] == "load" {
+		if len(args) != 2 {
+			return "Usage: .bg load <文件名>", ""
+		}
+		file := args[1]
+		if !strings.HasSuffix(file, ".md") {
+			file += ".md"
+		}
+		content, err := loadBackgroundFile(file)
+		if err != nil {
+			return fmt.Sprintf("Error: 无法加载背景 %s: %v", file, err), ""
+		}
+		groupState.SetBackground(file, content)
+
+		reply := fmt.Sprintf("本群背景已切换为 %s", file)
+		if m := worldMapFor(groupState); m.Loaded() {
+			reply += fmt.Sprintf("，已加载配套地图 (%d 个地点)", len(m.Locations))
+		}
+		return reply, fmt.Sprintf("System: DM 将本群的背景设定切换为 %s，之前的场景不再适用", file)
+	}
+
+	newBg := strings.Join(args, " ")
+	file, _ := groupState.GetBackground()
+	groupState.SetBackground(file, newBg)
+	return fmt.Sprintf("本群背景已更新为: %s", newBg), fmt.Sprintf("System: DM 将场景/背景更新为: %s", newBg)
+}
+
+// handleCampaign 处理 .campaign [list] / new <名称> / switch <名称> / archive <名称>
+// 每个战役有独立的对话历史、摘要、角色与背景，切换后 DM 从该战役的进度继续
+// DM 正在回复时拒绝新建与切换，回复只会写入回合开始时的战役
+func handleCampaign(groupID int64, args []string) string {
+	if len(args) == 0 || args[0] == "list" {
+		active := campaign.GlobalRegistry.Active(groupID).Name
+		var sb strings.Builder
+		sb.WriteString("📚 本群战役:")
+		for _, c := range campaign.GlobalRegistry.List(groupID) {
+			line := "\n  " + c.Name
+			switch {
+			case c.Name == active:
+				line += " ← 当前"
+			case c.Archived:
+				line += " [已归档]"
+			}
+			sb.WriteString(line)
+		}
+		sb.WriteString("\n(使用 .campaign new/switch/archive <名称> 管理战役)")
+		return sb.String()
+	}
+	if len(args) < 2 {
+		return "Usage: .campaign [list] / .campaign new|switch|archive <名称>"
+	}
+
+	name := strings.Join(args[1:], " ")
+	switch args[0] {
+	case "new":
+		err := journal.GlobalJournal.Replace(groupID, func() error {
+			return campaign.GlobalRegistry.New(groupID, name)
+		})
+		if err != nil {
+			return fmt.Sprintf("Error: %v", err)
+		}
+		return fmt.Sprintf("已创建并切换到新战役 %s，请使用 .st 创建角色。之前的战役已暂存，可用 .campaign switch 切换回去。", name)
+	case "switch":
+		var canonical string
+		err := journal.GlobalJournal.Replace(groupID, func() (err error) {
+			canonical, err = campaign.GlobalRegistry.Switch(groupID, name)
+			return err
+		})
+		if err != nil {
+			return fmt.Sprintf("Error: %v", err)
+		}
+		return fmt.Sprintf("已切换到战役 %s，故事从上次离开的地方继续。", canonical)
+	case "archive":
+		canonical, err := campaign.GlobalRegistry.Archive(groupID, name)
+		if err != nil {
+			return fmt.Sprintf("Error: %v", err)
+		}
+		return fmt.Sprintf("战役 %s 已归档，进度会保留在快照中。", canonical)
+	}
+	return "Usage: .campaign [list] / .campaign new|switch|archive <名称>"
+}
+
+// currentLocationName 队伍所在地点名称，未加载地图时为空
+func currentLocationName(groupState *game.GroupState) string {
+	if here := groupState.CurrentLocation(worldMapFor(groupState)); here != nil {
+		return here.Name
+	}
+	return ""
+}
+
+// handleShopCommand 处理 .shop [商人] / .buy <物品> [数量] / .sell <物品> [数量] / .haggle [商人]
+// 只能与队伍所在地点的商人交易
+func handleShopCommand(groupID int64, ownerID int64, cmd string, args []string) (string, string) {
+	groupState := game.GlobalGameState.GetGroupState(groupID)
+	merchants := game.GlobalShops.MerchantsAt(currentLocationName(groupState))
+	if len(merchants) == 0 {
+		return "这里没有商人。", ""
+	}
+	char := groupState.FindCharacterByOwner(ownerID)
+	if char == nil {
+		return "你还没有角色卡，请先使用 .st 创建角色。", ""
+	}
+
+	// pickMerchant 按名称选择商人，只有一个商人时可省略
+	pickMerchant := func() (*game.Merchant, string) {
+		if len(args) == 0 {
+			if len(merchants) == 1 {
+				return merchants[0], ""
+			}
+			names := make([]string, 0, len(merchants))
+			for _, m := range merchants {
+				names = append(names, m.Name)
+			}
+			return nil, "这里的商人: " + strings.Join(names, ", ")
+		}
+		name := strings.Join(args, " ")
+		for _, m := range merchants {
+			if strings.EqualFold(m.Name, name) {
+				return m, ""
+			}
+		}
+		return nil, fmt.Sprintf("这里没有商人 %s", name)
+	}
+
+	// itemArgs 拆出物品名与末尾的数量
+	itemArgs := func() (string, int) {
+		item, qty := strings.Join(args, " "), 1
+		if len(args) > 1 {
+			if n, err := strconv.Atoi(args[len(args)-1]); err == nil && n > 0 {
+				item, qty = strings.Join(args[:len(args)-1], " "), n
+			}
+		}
+		return item, qty
+	}
+
+	switch cmd {
+	case ".shop":
+		if len(args) == 0 {
+			listings := make([]string, 0, len(merchants))
+			for _, m := range merchants {
+				listings = append(listings, groupState.ShopListing(m, char.Name))
+			}
+			return strings.Join(listings, "\n") + fmt.Sprintf("\n(%s 的钱包: %s)", char.Name, game.FormatCoins(char.Purse)), ""
+		}
+		m, errMsg := pickMerchant()
+		if m == nil {
+			return errMsg, ""
+		}
+		return groupState.ShopListing(m, char.Name), ""
+
+	case ".buy", ".sell":
+		if len(args) < 1 {
+			return fmt.Sprintf("Usage: %s <物品> [数量]", cmd), ""
+		}
+		item, qty := itemArgs()
+		// 买: 找出售该物品的商人; 卖: 优先找专门收购的商人
+		m := merchants[0]
+		for _, candidate := range merchants {
+			if (cmd == ".buy" && candidate.Sells(item)) || (cmd == ".sell" && candidate.WantsItem(item)) {
+				m = candidate
+				break
+			}
+		}
+		var (
+			trade *game.Trade
+			err   error
+		)
+		if cmd == ".buy" {
+			trade, err = groupState.Buy(m, char.Name, item, qty)
+		} else {
+			trade, err = groupState.Sell(game.GlobalShops, m, char.Name, item, qty)
+		}
+		if err != nil {
+			return fmt.Sprintf("交易失败: %v", err), ""
+		}
+		verb := "购买"
+		if cmd == ".sell" {
+			verb = "出售"
+		}
+		reply := fmt.Sprintf("%s 向 %s %s了 %s x%d，共 %s (钱包剩余 %s)",
+			trade.Character, trade.Merchant, verb, trade.Item, trade.Qty, game.FormatCoins(trade.Total), game.FormatCoins(trade.Purse))
+		return reply, "【系统提示】" + reply
+
+	default: // .haggle
+		m, errMsg := pickMerchant()
+		if m == nil {
+			return errMsg, ""
+		}
+		result, err := groupState.Haggle(m, char.Name)
+		if err != nil {
+			return fmt.Sprintf("Error: %v", err), ""
+		}
+		return result.String(), "【系统提示】" + result.String()
+	}
+}
+
+// handleQuests 处理 .quests [all]
+// 默认只显示进行中的任务，all 显示包括已完成/已失败在内的全部任务
+func handleQuests(groupID int64, args []string) string {
+	all := len(args) > 0 && args[0] == "all"
+	quests := game.GlobalGameState.GetGroupState(groupID).ListQuests(!all)
+	if len(quests) == 0 {
+		if all {
+			return "任务日志是空的。"
+		}
+		return "当前没有进行中的任务。(.quests all 查看全部)"
+	}
+
+	parts := make([]string, 0, len(quests))
+	for _, q := range quests {
+		parts = append(parts, q.String())
+	}
+	return "【任务日志】\n" + strings.Join(parts, "\n")
+}
+
+// parseGMIDs 解析逗号分隔的 GM QQ 号列表
+func parseGMIDs(raw string) map[int64]bool {
+	ids := make(map[int64]bool)
+	for _, part := range strings.Split(raw, ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+		if err == nil {
+			ids[id] = true
+		}
+	}
+	return ids
+}
+
+// openDatabase 打开 SQLite 数据库并接入会话、游戏状态与战役管理器，之后的每次修改都会立即写入
+// path 为空时使用 defaultDBPath，为 off 或打开失败时返回 nil，进度只保存在内存中
+func openDatabase(path string) *storage.SQLite {
+	if path == "off" {
+		logrus.Warn("DB_PATH=off, progress is kept in memory only. Use .snapshot to save it.")
+		return nil
+	}
+	if path == "" {
+		path = defaultDBPath
+	}
+	db, err := storage.Open(path)
+	if err != nil {
+		logrus.Errorf("Failed to open database %s: %v. Progress is kept in memory only.", path, err)
+		return nil
+	}
+	session.GlobalManager.SetStore(db)
+	game.GlobalGameState.SetStore(db)
+	campaign.GlobalRegistry.SetStore(db)
+	logrus.Infof("Using database %s", path)
+	return db
+}
+
+// restoreFromDatabase 从数据库恢复进度，数据库为空 (首次启动) 时返回 false，由调用方改用快照
+// 数据库无法读取或已有进度但读取失败时拒绝启动: 继续运行会用快照或空白状态覆盖数据库中较新的进度
+func restoreFromDatabase(db *storage.SQLite) bool {
+	const hint = "Refusing to start so the stored progress is not overwritten; fix or move the database (DB_PATH) and restart"
+
+	hasData, err := db.HasData()
+	if err != nil {
+		logrus.Fatalf("Failed to read database: %v. %s", err, hint)
+	}
+	if !hasData {
+		return false
+	}
+	if err := session.GlobalManager.Load(db); err != nil {
+		logrus.Fatalf("Failed to load sessions from database: %v. %s", err, hint)
+	}
+	if err := game.GlobalGameState.Load(db); err != nil {
+		logrus.Fatalf("Failed to load game states from database: %v. %s", err, hint)
+	}
+	if err := campaign.GlobalRegistry.Load(db); err != nil {
+		logrus.Fatalf("Failed to load campaigns from database: %v. %s", err, hint)
+	}
+	return true
+}
+
+// parseAutosaveConfig 读取自动存档配置
+// AUTOSAVE_INTERVAL (默认 30m，0 关闭)、AUTOSAVE_MESSAGES (默认 100，0 关闭)、
+// SNAPSHOT_KEEP_LAST (默认 10)、SNAPSHOT_KEEP_DAILY (默认 7)
+func parseAutosaveConfig() snapshot.AutosaveConfig {
+	cfg := snapshot.AutosaveConfig{
+		Interval: 30 * time.Minute,
+		Messages: envInt("AUTOSAVE_MESSAGES", 100),
+		Retention: snapshot.Retention{
+			KeepLast:  envInt("SNAPSHOT_KEEP_LAST", 10),
+			KeepDaily: envInt("SNAPSHOT_KEEP_DAILY", 7),
+		},
+	}
+	if raw := os.Getenv("AUTOSAVE_INTERVAL"); raw == "0" {
+		cfg.Interval = 0
+	} else if raw != "" {
+		if d, err := time.ParseDuration(raw); err != nil || d < 0 {
+			logrus.Warnf("Invalid AUTOSAVE_INTERVAL %q, using default %s", raw, cfg.Interval)
+		} else {
+			cfg.Interval = d
+		}
+	}
+	return cfg
+}
+
+// envInt 读取非负整数环境变量，为空或无效时返回 def
+func envInt(name string, def int) int {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		logrus.Warnf("Invalid %s %q, using default %d", name, raw, def)
+		return def
+	}
+	return n
+}
+
+// shutdown 退出前停止自动存档、保存最终快照并关闭数据库，只执行一次
+func shutdown() {
+	shutdownOnce.Do(func() {
+		autosaver.Stop()
+		if filename, err := snapshot.SaveAutoSnapshot(autosaveConfig.Retention); err != nil {
+			logrus.Errorf("Failed to save final snapshot: %v", err)
+		} else {
+			logrus.Infof("Final snapshot saved to %s", filename)
+		}
+		if database != nil {
+			database.Close()
+		}
+	})
+}
+
+// isGM 判断玩家是否为 GM，未配置 GM_QQ_IDS 时所有人都是 GM
+func isGM(senderID int64) bool {
+	return len(gmIDs) == 0 || gmIDs[senderID]
+}
+
+// parseCharacterArgs 解析 .st 参数: [name] [class] [hp] [str] [race=..] [dex=..] [level=..]
+// 并按职业规则校验、填充起始资源
+func parseCharacterArgs(args []string) (*game.Character, error) {
+	if len(args) < 4 {
+		return nil, fmt.Errorf("Usage .st [name] [class] [hp] [str] [race=种族] [dex=敏捷] [cha=魅力] [level=等级]")
+	}
+
+	hp, err1 := strconv.Atoi(args[2])
+	str, err2 := strconv.Atoi(args[3])
+	if err1 != nil || err2 != nil {
+		return nil, fmt.Errorf("HP and STR must be numbers.")
+	}
+
+	char := &game.Character{
+		Name:  args[0],
+		Class: args[1],
+		HP:    hp,
+		MaxHP: hp,
+		STR:   str,
+		DEX:   10,
+		CHA:   10,
+	}
+
+	for _, opt := range args[4:] {
+		key, value, ok := strings.Cut(opt, "=")
+		if !ok {
+			return nil, fmt.Errorf("无法识别的参数: %s (格式 key=value)", opt)
+		}
+		switch strings.ToLower(key) {
+		case "race":
+			char.Race = value
+		case "dex":
+			dex, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("DEX must be a number.")
+			}
+			char.DEX = dex
+		case "cha":
+			cha, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("CHA must be a number.")
+			}
+			char.CHA = cha
+		case "level":
+			level, err := strconv.Atoi(value)
+			if err != nil || level < 1 || level > 20 {
+				return nil, fmt.Errorf("level must be 1-20.")
+			}
+			char.Level = level
+		default:
+			return nil, fmt.Errorf("未知参数: %s", key)
+		}
+	}
+
+	if err := game.GlobalRules.ValidateCharacter(char); err != nil {
+		return nil, err
+	}
+	game.GlobalRules.ApplyClassDefaults(char)
+	return char, nil
+}
+
 func loadBackgroundFile(filename string) (string, error) {
 	// Ensure directory exists
 	if _, err := os.Stat("background"); os.IsNotExist(err) {
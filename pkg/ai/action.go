@@ -0,0 +1,20 @@
+package ai
+
+import (
+	"regexp"
+	"strings"
+)
+
+// ActionPattern DM 回复中的 Action 块，第一个子匹配为其中的 JSON
+var ActionPattern = regexp.MustCompile(`(?s)<dnd_action>(.*?)</dnd_action>`)
+
+// unclosedAction 被截断、没有结束标签的 Action 块
+var unclosedAction = regexp.MustCompile(`(?s)<dnd_action>.*$`)
+
+// StripActions 去掉回复中的 Action 块，返回可以发给玩家的文本
+// Action 中可能包含隐藏的事件与裁定，只能写入对话记录，不能直接发出
+func StripActions(reply string) string {
+	text := ActionPattern.ReplaceAllString(reply, "")
+	text = unclosedAction.ReplaceAllString(text, "")
+	return strings.TrimSpace(text)
+}
@@ -0,0 +1,26 @@
+package ai
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStripActions_HidesScheduledEvent(t *testing.T) {
+	reply := "你们在营地安顿下来。\n<dnd_action>[{\"type\":\"schedule_event\",\"minutes\":120,\"text\":\"强盗夜袭营地\"}]</dnd_action>"
+
+	text := StripActions(reply)
+	if strings.Contains(text, "强盗夜袭营地") || strings.Contains(text, "dnd_action") {
+		t.Errorf("action leaked into the broadcast: %q", text)
+	}
+	if text != "你们在营地安顿下来。" {
+		t.Errorf("unexpected text %q", text)
+	}
+	if m := ActionPattern.FindStringSubmatch(reply); len(m) < 2 || !strings.Contains(m[1], "强盗夜袭营地") {
+		t.Error("action should still be parsed from the full reply")
+	}
+
+	// 被截断的 Action 块同样不能发出
+	if text := StripActions("门开了。<dnd_action>[{\"type\":\"schedule_event\",\"text\":\"伏兵"); text != "门开了。" {
+		t.Errorf("unclosed action leaked: %q", text)
+	}
+}
@@ -0,0 +1,311 @@
+package campaign
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"dndbot/pkg/game"
+	"dndbot/pkg/session"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultName 没有创建过战役的群组使用的战役名
+const DefaultName = "默认"
+
+// Campaign 群组中的一个战役，拥有独立的对话历史、摘要、角色与背景 (背景保存在群组状态中)
+// 当前战役的会话与状态保存在 session.GlobalManager / game.GlobalGameState 中，
+// 其余战役的会话与状态暂存在这里，切换时互换
+type Campaign struct {
+	Name      string
+	Archived  bool
+	CreatedAt time.Time
+
+	session *session.Session
+	state   *game.GroupState
+}
+
+// groupCampaigns 一个群组的所有战役
+type groupCampaigns struct {
+	Active    string
+	Campaigns map[string]*Campaign // Key: 小写战役名
+}
+
+// Registry 全局战役注册表
+type Registry struct {
+	groups map[int64]*groupCampaigns
+	store  Store
+	mutex  sync.Mutex
+}
+
+// Store 战役列表的持久化存储，新建、切换、归档战役后写入
+// 非当前战役的会话与状态随列表一起保存，当前战役的由 session/game 的存储负责
+type Store interface {
+	SaveCampaigns(groupID int64, data *GroupData) error
+	// SaveSwitch 切换战役时在一个事务中写入新的当前会话、群组状态与战役列表
+	SaveSwitch(groupID int64, sess *session.SessionData, state *game.GroupStateData, campaigns *GroupData) error
+	LoadCampaigns() (map[int64]*GroupData, error)
+}
+
+// CampaignData 用于导出的战役数据，当前战役的 Session/State 为空 (已包含在快照的 Sessions/GameStates 中)
+type CampaignData struct {
+	Name      string
+	Archived  bool `json:",omitempty"`
+	CreatedAt time.Time
+	Session   *session.SessionData `json:",omitempty"`
+	State     *game.GroupStateData `json:",omitempty"`
+}
+
+// GroupData 用于导出的群组战役列表
+type GroupData struct {
+	Active    string
+	Campaigns []*CampaignData
+}
+
+var GlobalRegistry = NewRegistry()
+
+// NewRegistry 创建空的战役注册表
+func NewRegistry() *Registry {
+	return &Registry{groups: make(map[int64]*groupCampaigns)}
+}
+
+// group 获取或创建群组的战役列表，调用方需持有锁
+func (r *Registry) group(groupID int64) *groupCampaigns {
+	if g, ok := r.groups[groupID]; ok {
+		return g
+	}
+	g := &groupCampaigns{
+		Active: DefaultName,
+		Campaigns: map[string]*Campaign{
+			strings.ToLower(DefaultName): {Name: DefaultName, CreatedAt: time.Now()},
+		},
+	}
+	r.groups[groupID] = g
+	return g
+}
+
+// Active 群组当前的战役
+func (r *Registry) Active(groupID int64) Campaign {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	g := r.group(groupID)
+	return *g.Campaigns[strings.ToLower(g.Active)]
+}
+
+// List 群组的所有战役，按创建时间排序
+func (r *Registry) List(groupID int64) []Campaign {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	g := r.group(groupID)
+	list := make([]Campaign, 0, len(g.Campaigns))
+	for _, c := range g.Campaigns {
+		list = append(list, *c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list
+}
+
+// New 创建新战役并切换过去，新战役从空白的会话与角色开始
+func (r *Registry) New(groupID int64, name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return fmt.Errorf("战役名不能为空")
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	g := r.group(groupID)
+	if _, exists := g.Campaigns[strings.ToLower(name)]; exists {
+		return fmt.Errorf("战役 %s 已存在", name)
+	}
+	c := &Campaign{
+		Name:      name,
+		CreatedAt: time.Now(),
+		session:   session.NewSession(groupID),
+		state:     game.NewGroupState(groupID),
+	}
+	g.Campaigns[strings.ToLower(name)] = c
+	if err := r.activate(groupID, g, c); err != nil {
+		delete(g.Campaigns, strings.ToLower(name))
+		return err
+	}
+	return nil
+}
+
+// Switch 切换到已有战役，切换到已归档的战役会将其恢复
+func (r *Registry) Switch(groupID int64, name string) (string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	g := r.group(groupID)
+	c := g.Campaigns[strings.ToLower(strings.TrimSpace(name))]
+	if c == nil {
+		return "", fmt.Errorf("没有名为 %s 的战役", name)
+	}
+	if strings.EqualFold(c.Name, g.Active) {
+		return "", fmt.Errorf("当前已经是战役 %s", c.Name)
+	}
+	if err := r.activate(groupID, g, c); err != nil {
+		return "", err
+	}
+	return c.Name, nil
+}
+
+// activate 暂存当前战役的会话与状态，换上 c 的，调用方需持有锁
+// 新的当前会话、群组状态与战役列表在一个事务中写入存储，写入失败时撤回切换
+func (r *Registry) activate(groupID int64, g *groupCampaigns, c *Campaign) error {
+	cur := g.Campaigns[strings.ToLower(g.Active)]
+	sess, state := orNewSession(groupID, c.session), orNewState(groupID, c.state)
+	archived := c.Archived
+
+	cur.session = session.GlobalManager.Swap(groupID, sess)
+	cur.state = game.GlobalGameState.Swap(groupID, state)
+	c.session, c.state = nil, nil
+	c.Archived = false
+	g.Active = c.Name
+
+	if r.store == nil {
+		return nil
+	}
+	if err := r.store.SaveSwitch(groupID, sess.Data(), state.Data(), g.data()); err != nil {
+		logrus.Errorf("Failed to save campaign switch of group %d: %v", groupID, err)
+		session.GlobalManager.Swap(groupID, orNewSession(groupID, cur.session))
+		game.GlobalGameState.Swap(groupID, orNewState(groupID, cur.state))
+		c.session, c.state = sess, state
+		c.Archived = archived
+		cur.session, cur.state = nil, nil
+		g.Active = cur.Name
+		return fmt.Errorf("保存战役切换失败: %w", err)
+	}
+	return nil
+}
+
+func orNewSession(groupID int64, s *session.Session) *session.Session {
+	if s == nil {
+		return session.NewSession(groupID)
+	}
+	return s
+}
+
+func orNewState(groupID int64, s *game.GroupState) *game.GroupState {
+	if s == nil {
+		return game.NewGroupState(groupID)
+	}
+	return s
+}
+
+// Archive 归档一个非当前战役，归档的战役保留全部进度，可以随时切换回来
+func (r *Registry) Archive(groupID int64, name string) (string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	g := r.group(groupID)
+	c := g.Campaigns[strings.ToLower(strings.TrimSpace(name))]
+	if c == nil {
+		return "", fmt.Errorf("没有名为 %s 的战役", name)
+	}
+	if strings.EqualFold(c.Name, g.Active) {
+		return "", fmt.Errorf("不能归档当前战役，请先切换到其他战役")
+	}
+	c.Archived = true
+	r.save(groupID, g)
+	return c.Name, nil
+}
+
+// ExportData 导出所有群组的战役列表
+func (r *Registry) ExportData() map[int64]*GroupData {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	data := make(map[int64]*GroupData)
+	for id, g := range r.groups {
+		data[id] = g.data()
+	}
+	return data
+}
+
+// data 导出一个群组的战役列表，调用方需持有注册表的锁
+func (g *groupCampaigns) data() *GroupData {
+	gd := &GroupData{Active: g.Active}
+	for _, c := range g.Campaigns {
+		cd := &CampaignData{Name: c.Name, Archived: c.Archived, CreatedAt: c.CreatedAt}
+		if c.session != nil {
+			cd.Session = c.session.Data()
+		}
+		if c.state != nil {
+			cd.State = c.state.Data()
+		}
+		gd.Campaigns = append(gd.Campaigns, cd)
+	}
+	sort.Slice(gd.Campaigns, func(i, j int) bool { return gd.Campaigns[i].CreatedAt.Before(gd.Campaigns[j].CreatedAt) })
+	return gd
+}
+
+// ImportData 导入战役列表，旧快照没有战役数据时所有群组都在默认战役中
+// 设置了存储时同时写入存储
+func (r *Registry) ImportData(data map[int64]*GroupData) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.importData(data)
+	for id, g := range r.groups {
+		r.save(id, g)
+	}
+}
+
+// importData 导入战役列表，调用方需持有锁
+func (r *Registry) importData(data map[int64]*GroupData) {
+	for id, gd := range data {
+		g := &groupCampaigns{Active: gd.Active, Campaigns: make(map[string]*Campaign)}
+		for _, cd := range gd.Campaigns {
+			c := &Campaign{Name: cd.Name, Archived: cd.Archived, CreatedAt: cd.CreatedAt}
+			if cd.Session != nil {
+				c.session = session.FromData(cd.Session)
+			}
+			if cd.State != nil {
+				c.state = game.GroupStateFromData(cd.State)
+			}
+			g.Campaigns[strings.ToLower(c.Name)] = c
+		}
+		if g.Campaigns[strings.ToLower(g.Active)] == nil {
+			continue // 数据损坏，保持默认战役
+		}
+		r.groups[id] = g
+	}
+}
+
+// SetStore 设置存储，之后新建、切换、归档战役都会写入 st
+func (r *Registry) SetStore(st Store) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.store = st
+}
+
+// Load 从存储读取所有群组的战役列表，不会回写
+func (r *Registry) Load(st Store) error {
+	data, err := st.LoadCampaigns()
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.importData(data)
+	return nil
+}
+
+// save 将群组的战役列表写入存储，调用方需持有锁
+func (r *Registry) save(groupID int64, g *groupCampaigns) {
+	if r.store == nil {
+		return
+	}
+	if err := r.store.SaveCampaigns(groupID, g.data()); err != nil {
+		logrus.Errorf("Failed to save campaigns of group %d: %v", groupID, err)
+	}
+}
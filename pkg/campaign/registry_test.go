@@ -0,0 +1,84 @@
+package campaign
+
+import (
+	"errors"
+	"testing"
+
+	"dndbot/pkg/game"
+	"dndbot/pkg/session"
+)
+
+func TestRegistry_SwitchKeepsStateApart(t *testing.T) {
+	session.InitManager()
+	game.InitGameState()
+	r := NewRegistry()
+	const gid = 42
+
+	session.GlobalManager.GetSession(gid).AddMessage("user", "战役 A 的第一句话")
+	game.GlobalGameState.GetGroupState(gid).AddCharacter(&game.Character{Name: "Arthur"})
+
+	if err := r.New(gid, "一发团"); err != nil {
+		t.Fatal(err)
+	}
+	if r.Active(gid).Name != "一发团" {
+		t.Fatalf("unexpected active campaign %+v", r.Active(gid))
+	}
+	if len(session.GlobalManager.GetSession(gid).GetHistory()) != 0 {
+		t.Error("new campaign should start with empty history")
+	}
+	if game.GlobalGameState.GetGroupState(gid).GetCharacter("Arthur") != nil {
+		t.Error("new campaign should start without characters")
+	}
+	if _, err := r.Archive(gid, "一发团"); err == nil {
+		t.Error("archiving the active campaign should fail")
+	}
+
+	// 导出再导入后切换回默认战役，进度仍在
+	data := r.ExportData()
+	r = NewRegistry()
+	r.ImportData(data)
+	if _, err := r.Switch(gid, DefaultName); err != nil {
+		t.Fatal(err)
+	}
+	if h := session.GlobalManager.GetSession(gid).GetHistory(); len(h) != 1 {
+		t.Errorf("default campaign history lost: %v", h)
+	}
+	if game.GlobalGameState.GetGroupState(gid).GetCharacter("Arthur") == nil {
+		t.Error("default campaign character lost")
+	}
+	if name, err := r.Archive(gid, "一发团"); err != nil || name != "一发团" {
+		t.Errorf("archive: %q %v", name, err)
+	}
+	if list := r.List(gid); len(list) != 2 || !list[1].Archived {
+		t.Errorf("unexpected list %+v", list)
+	}
+}
+
+// failingStore 写入总是失败的存储
+type failingStore struct{}
+
+func (failingStore) SaveCampaigns(int64, *GroupData) error { return errors.New("disk full") }
+func (failingStore) SaveSwitch(int64, *session.SessionData, *game.GroupStateData, *GroupData) error {
+	return errors.New("disk full")
+}
+func (failingStore) LoadCampaigns() (map[int64]*GroupData, error) { return nil, nil }
+
+func TestRegistry_FailedSwitchIsRolledBack(t *testing.T) {
+	session.InitManager()
+	game.InitGameState()
+	r := NewRegistry()
+	r.SetStore(failingStore{})
+	const gid = 43
+
+	sess := session.GlobalManager.GetSession(gid)
+	sess.AddMessage("user", "战役 A")
+	if err := r.New(gid, "一发团"); err == nil {
+		t.Fatal("switch should fail when it cannot be saved")
+	}
+	if r.Active(gid).Name != DefaultName || len(r.List(gid)) != 1 {
+		t.Errorf("failed switch should leave the campaign list unchanged: %+v", r.List(gid))
+	}
+	if session.GlobalManager.GetSession(gid) != sess {
+		t.Error("failed switch should keep the current session")
+	}
+}
@@ -21,42 +21,58 @@ type RollResult struct {
 	Modifier   int
 }
 
-// Roll 简单的骰子解析 (支持 XdY格式)
-// 例如: 1d20, 2d6
-func Roll(expression string) (*RollResult, error) {
+// Parse 解析骰子表达式，返回数量、面数与修饰符
+func Parse(expression string) (count int, sides int, modifier int, err error) {
 	expression = strings.ToLower(strings.TrimSpace(expression))
 	re := regexp.MustCompile(`^(\d*)d(\d+)([+-]\d+)?$`)
 	matches := re.FindStringSubmatch(expression)
 
 	if matches == nil {
-		return nil, fmt.Errorf("骰子格式错误，请使用 [数量]d[面数][+/-修饰符] 的格式 (例如 d20, 1d20+5, 2d6-1)")
+		return 0, 0, 0, fmt.Errorf("骰子格式错误，请使用 [数量]d[面数][+/-修饰符] 的格式 (例如 d20, 1d20+5, 2d6-1)")
 	}
 
-	count := 1
+	count = 1
 	if matches[1] != "" {
-		var err error
 		count, err = strconv.Atoi(matches[1])
 		if err != nil || count <= 0 {
-			return nil, fmt.Errorf("骰子数量必须为正整数")
+			return 0, 0, 0, fmt.Errorf("骰子数量必须为正整数")
 		}
 	}
 	// 解析面数
-	sides, err := strconv.Atoi(matches[2])
+	sides, err = strconv.Atoi(matches[2])
 	if err != nil || sides <= 0 {
-		return nil, fmt.Errorf("骰子面数必须为正整数")
+		return 0, 0, 0, fmt.Errorf("骰子面数必须为正整数")
 	}
 
 	if count > 100 {
-		return nil, fmt.Errorf("too many dice")
+		return 0, 0, 0, fmt.Errorf("too many dice")
 	}
 
-	modifier := 0
 	if matches[3] != "" {
 		modifier, err = strconv.Atoi(matches[3])
 		if err != nil {
-			return nil, fmt.Errorf("无效的修饰符")
+			return 0, 0, 0, fmt.Errorf("无效的修饰符")
 		}
 	}
+	return count, sides, modifier, nil
+}
+
+// Format 将数量、面数与修饰符组合为表达式，例如 2d6+3
+func Format(count, sides, modifier int) string {
+	if modifier == 0 {
+		return fmt.Sprintf("%dd%d", count, sides)
+	}
+	return fmt.Sprintf("%dd%d%+d", count, sides, modifier)
+}
+
+// Roll 简单的骰子解析 (支持 XdY格式)
+// 例如: 1d20, 2d6
+func Roll(expression string) (*RollResult, error) {
+	expression = strings.ToLower(strings.TrimSpace(expression))
+	count, sides, modifier, err := Parse(expression)
+	if err != nil {
+		return nil, err
+	}
 	rolls := make([]int, count)
 	total := 0
 	for i := 0; i < count; i++ {
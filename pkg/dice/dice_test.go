@@ -236,3 +236,28 @@ func TestRollResult_String_MultipleDice(t *testing.T) {
 		t.Errorf("got %q, want %q", s, expected)
 	}
 }
+
+// === Parse / Format ===
+
+func TestParse_Components(t *testing.T) {
+	count, sides, mod, err := Parse("2d8+3")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if count != 2 || sides != 8 || mod != 3 {
+		t.Errorf("expected 2d8+3, got %dd%d%+d", count, sides, mod)
+	}
+}
+
+func TestFormat_RoundTrip(t *testing.T) {
+	cases := []string{"1d20", "2d6-1", "4d8+3"}
+	for _, expr := range cases {
+		count, sides, mod, err := Parse(expr)
+		if err != nil {
+			t.Fatalf("parse %s: %v", expr, err)
+		}
+		if got := Format(count, sides, mod); got != expr {
+			t.Errorf("Format(Parse(%q)) = %q", expr, got)
+		}
+	}
+}
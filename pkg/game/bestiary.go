@@ -0,0 +1,162 @@
+package game
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"dndbot/pkg/dice"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MaxSpawnCount 一次最多生成的怪物数量，防止 DM 的 Action 刷出大量角色
+const MaxSpawnCount = 20
+
+// Attack 怪物的天生武器/固定攻击
+type Attack struct {
+	Name   string `json:"name"`
+	Bonus  int    `json:"bonus"`  // 命中加值
+	Damage string `json:"damage"` // 伤害骰，已包含属性修正，例如 1d6+2
+	Notes  string `json:"notes"`
+}
+
+// MonsterDef 怪物图鉴中的数据块
+type MonsterDef struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	CR       string   `json:"cr"` // 挑战等级: "1/4", "1", "3"...
+	AC       int      `json:"ac"`
+	HitDice  string   `json:"hit_dice"` // 生命骰，生成时掷骰决定 HP
+	STR      int      `json:"str"`
+	DEX      int      `json:"dex"`
+	Attacks  []Attack `json:"attacks"`
+	Traits   []string `json:"traits"`
+	Weakness string   `json:"weakness"`
+}
+
+// Bestiary 怪物图鉴，从 background/ 下的数据文件加载
+type Bestiary struct {
+	Monsters []*MonsterDef `json:"monsters"`
+}
+
+var GlobalBestiary = &Bestiary{}
+
+// LoadBestiary 从 JSON 文件加载怪物图鉴并设置为全局图鉴
+func LoadBestiary(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var b Bestiary
+	if err := json.Unmarshal(data, &b); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	for _, m := range b.Monsters {
+		if _, _, _, err := dice.Parse(m.HitDice); err != nil {
+			return fmt.Errorf("%s: invalid hit_dice %q", m.Name, m.HitDice)
+		}
+		for _, a := range m.Attacks {
+			if _, _, _, err := dice.Parse(a.Damage); err != nil {
+				return fmt.Errorf("%s: invalid damage %q for attack %s", m.Name, a.Damage, a.Name)
+			}
+		}
+	}
+
+	GlobalBestiary = &b
+	return nil
+}
+
+// Get 按名称查找怪物模板
+func (b *Bestiary) Get(name string) *MonsterDef {
+	for _, m := range b.Monsters {
+		if strings.EqualFold(m.Name, name) {
+			return m
+		}
+	}
+	return nil
+}
+
+// Summary 可用模板一览，用于注入 Prompt
+func (b *Bestiary) Summary() string {
+	if len(b.Monsters) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(b.Monsters))
+	for _, m := range b.Monsters {
+		parts = append(parts, fmt.Sprintf("%s(CR %s)", m.Name, m.CR))
+	}
+	return "【怪物图鉴】可用模板: " + strings.Join(parts, ", ") + "\n"
+}
+
+// NewCharacter 按模板掷生命骰生成一个 NPC
+func (m *MonsterDef) NewCharacter(name string) *Character {
+	hp := 1
+	if res, err := dice.Roll(m.HitDice); err == nil && res.Total > 1 {
+		hp = res.Total
+	}
+
+	char := &Character{
+		Name:     name,
+		Class:    m.Type,
+		HP:       hp,
+		MaxHP:    hp,
+		STR:      m.STR,
+		DEX:      m.DEX,
+		AC:       m.AC,
+		IsAI:     true,
+		Template: m.Name,
+		CR:       m.CR,
+		Attacks:  append([]Attack(nil), m.Attacks...),
+	}
+	if len(m.Attacks) > 0 {
+		char.Weapon = m.Attacks[0].Name
+	}
+	return char
+}
+
+// SpawnMonsters 按模板生成 count 个 NPC 并加入群组
+// 多个同名怪物自动编号 ("Goblin 1", "Goblin 2")，并跳过已存在的名字；count 超过 MaxSpawnCount 时截断
+func (g *GroupState) SpawnMonsters(m *MonsterDef, baseName string, count int) []*Character {
+	if baseName == "" {
+		baseName = m.Name
+	}
+	if count < 1 {
+		count = 1
+	}
+	if count > MaxSpawnCount {
+		logrus.Warnf("Group %d: spawn of %d %s clamped to %d", g.GroupID, count, baseName, MaxSpawnCount)
+		count = MaxSpawnCount
+	}
+
+	var spawned []*Character
+	next := 1
+	for i := 0; i < count; i++ {
+		name := baseName
+		if count > 1 || g.GetCharacter(name) != nil {
+			for {
+				name = fmt.Sprintf("%s %d", baseName, next)
+				next++
+				if g.GetCharacter(name) == nil {
+					break
+				}
+			}
+		}
+		char := m.NewCharacter(name)
+		g.AddCharacter(char)
+		spawned = append(spawned, char)
+	}
+	return spawned
+}
+
+// findAttack 查找角色自带的攻击
+func (c *Character) findAttack(name string) *Attack {
+	for i := range c.Attacks {
+		if strings.EqualFold(c.Attacks[i].Name, name) {
+			return &c.Attacks[i]
+		}
+	}
+	return nil
+}
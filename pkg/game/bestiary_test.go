@@ -0,0 +1,69 @@
+package game
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadBestiary_ShippedFile(t *testing.T) {
+	if err := LoadBestiary("../../background/bestiary.json"); err != nil {
+		t.Fatalf("load bestiary: %v", err)
+	}
+	defer func() { GlobalBestiary = &Bestiary{} }()
+
+	if GlobalBestiary.Get("腐化地精") == nil {
+		t.Error("expected 腐化地精 in bestiary")
+	}
+}
+
+func TestLoadBestiary_RejectsBadAttackDamage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bestiary.json")
+	data := `{"monsters":[{"name":"Goblin","hit_dice":"2d6","attacks":[{"name":"Scimitar","damage":"1d6+x"}]}]}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadBestiary(path); err == nil {
+		t.Error("expected error for invalid attack damage")
+	}
+}
+
+func TestSpawnMonsters_AutoSuffix(t *testing.T) {
+	goblin := &MonsterDef{Name: "Goblin", Type: "Humanoid", CR: "1/4", AC: 13, HitDice: "2d6", DEX: 14,
+		Attacks: []Attack{{Name: "Scimitar", Bonus: 4, Damage: "1d6+2"}}}
+	g := newTestGroup()
+
+	spawned := g.SpawnMonsters(goblin, "", 2)
+	if len(spawned) != 2 || spawned[0].Name != "Goblin 1" || spawned[1].Name != "Goblin 2" {
+		t.Fatalf("expected Goblin 1, Goblin 2, got %v", spawned)
+	}
+	for _, c := range spawned {
+		if c.HP < 2 || c.HP > 12 || c.HP != c.MaxHP {
+			t.Errorf("%s HP %d outside 2d6", c.Name, c.HP)
+		}
+		if !c.IsAI || c.AC != 13 || c.Weapon != "Scimitar" {
+			t.Errorf("template stats not applied: %+v", c)
+		}
+	}
+
+	more := g.SpawnMonsters(goblin, "", 1)
+	if more[0].Name != "Goblin" {
+		t.Errorf("single spawn should keep plain name when free, got %s", more[0].Name)
+	}
+	again := g.SpawnMonsters(goblin, "", 1)
+	if again[0].Name != "Goblin 3" {
+		t.Errorf("expected next free suffix Goblin 3, got %s", again[0].Name)
+	}
+}
+
+func TestSpawnMonsters_ClampsCount(t *testing.T) {
+	rat := &MonsterDef{Name: "Rat", HitDice: "1d4"}
+	g := newTestGroup()
+
+	if spawned := g.SpawnMonsters(rat, "", 10000); len(spawned) != MaxSpawnCount {
+		t.Errorf("expected %d rats, got %d", MaxSpawnCount, len(spawned))
+	}
+	if n := len(g.Characters); n != MaxSpawnCount {
+		t.Errorf("expected %d characters in the group, got %d", MaxSpawnCount, n)
+	}
+}
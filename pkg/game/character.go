@@ -2,31 +2,81 @@ package game
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 )
 
 // Character 极简角色卡
 type Character struct {
-	Name   string `json:"name"`
-	Class  string `json:"class"` // 职业: 战士, 法师...
-	HP     int    `json:"hp"`
-	MaxHP  int    `json:"max_hp"`
-	STR    int    `json:"str"` // 力量
-	IsAI   bool   `json:"is_ai"`
-	Status string `json:"status"` // 状态: 如"中毒", "倒地"
+	Name        string         `json:"name"`
+	Class       string         `json:"class"` // 职业: 战士, 法师...
+	Race        string         `json:"race,omitempty"`
+	Level       int            `json:"level,omitempty"` // 角色等级，0 视为 1
+	HP          int            `json:"hp"`
+	MaxHP       int            `json:"max_hp"`
+	STR         int            `json:"str"`              // 力量
+	DEX         int            `json:"dex"`              // 敏捷，影响先攻
+	CHA         int            `json:"cha,omitempty"`    // 魅力，影响讲价
+	AC          int            `json:"ac"`               // 护甲等级，0 视为 10
+	Weapon      string         `json:"weapon,omitempty"` // 默认武器
+	IsAI        bool           `json:"is_ai"`
+	OwnerID     int64          `json:"owner_id,omitempty"`     // 创建该角色的玩家 QQ
+	Status      string         `json:"status"`                 // 状态: 如"中毒", "倒地"
+	StatusUntil int            `json:"status_until,omitempty"` // 状态到期的游戏时刻(分钟)，0 表示不会自动解除
+	Resources   map[string]int `json:"resources,omitempty"`    // 职业资源: 魔力、每日能力次数
+	Template    string         `json:"template,omitempty"`     // 生成该 NPC 的怪物模板
+	CR          string         `json:"cr,omitempty"`           // 挑战等级
+	Attacks     []Attack       `json:"attacks,omitempty"`      // 怪物自带的攻击
+	Inventory   []Item         `json:"inventory,omitempty"`    // 背包
+	Purse       int            `json:"purse,omitempty"`        // 钱包 (铜币)
+}
+
+// Clone 深拷贝角色卡
+func (c *Character) Clone() *Character {
+	cVal := *c
+	if c.Resources != nil {
+		cVal.Resources = make(map[string]int, len(c.Resources))
+		for k, v := range c.Resources {
+			cVal.Resources[k] = v
+		}
+	}
+	if c.Attacks != nil {
+		cVal.Attacks = append([]Attack(nil), c.Attacks...)
+	}
+	if c.Inventory != nil {
+		cVal.Inventory = append([]Item(nil), c.Inventory...)
+	}
+	return &cVal
 }
 
 // GroupState 管理一个群内的游戏状态
 type GroupState struct {
-	GroupID    int64
-	Characters map[string]*Character // Key: Character Name (lowercase)
-	Mutex      sync.RWMutex
+	GroupID      int64
+	Characters   map[string]*Character // Key: Character Name (lowercase)
+	Encounter    *Encounter            // 进行中的战斗，nil 表示非战斗
+	NPCs         map[string]*NPCRecord // Key: NPC Name (lowercase)
+	Quests       []*Quest              // 按接取顺序排列
+	Location     string                // 队伍所在地点，空表示地图起点
+	Discovered   map[string]bool       // 队伍发现过的地点
+	Clock        int                   // 开局以来经过的游戏分钟数
+	NextLongRest int                   // 可以再次长休的游戏时刻
+	Events       []*TimedEvent         // 待触发的定时事件，按时间排序
+	Stash        Stash                 // 队伍共享的战利品
+	ShopSold     map[string]int        // Key: 商人/商品，已售出的限量商品数量
+	Haggles      map[string]int        // Key: 角色/商人，讲价得到的价格调整百分比
+	Party        Party                 // 公用物资、行进队列与守夜轮班
+	Background   string                // 本群的背景设定，为空时使用默认背景 (bg.md)
+	BgFile       string                // .bg load 加载的背景文件名，用于查找配套地图
+	Mutex        sync.RWMutex
+
+	store Store // 为 nil 时只保存在内存中
 }
 
 // StateManager 全局游戏状态管理器
 type StateManager struct {
 	groups map[int64]*GroupState
+	store  Store
 	mutex  sync.RWMutex
 }
 
@@ -34,8 +84,22 @@ var GlobalGameState *StateManager
 
 // GroupStateData 用于导出的数据结构
 type GroupStateData struct {
-	GroupID    int64
-	Characters map[string]*Character
+	GroupID      int64
+	Characters   map[string]*Character
+	Encounter    *Encounter
+	NPCs         map[string]*NPCRecord `json:",omitempty"`
+	Quests       []*Quest              `json:",omitempty"`
+	Location     string                `json:",omitempty"`
+	Discovered   map[string]bool       `json:",omitempty"`
+	Clock        int                   `json:",omitempty"`
+	NextLongRest int                   `json:",omitempty"`
+	Events       []*TimedEvent         `json:",omitempty"`
+	Stash        Stash
+	ShopSold     map[string]int `json:",omitempty"`
+	Haggles      map[string]int `json:",omitempty"`
+	Party        Party
+	Background   string `json:",omitempty"`
+	BgFile       string `json:",omitempty"`
 }
 
 func InitGameState() {
@@ -53,19 +117,71 @@ func (m *StateManager) GetGroupState(groupID int64) *GroupState {
 		return state
 	}
 
-	newState := &GroupState{
+	newState := NewGroupState(groupID)
+	newState.store = m.store
+	m.groups[groupID] = newState
+	return newState
+}
+
+// NewGroupState 创建空白群组状态
+func NewGroupState(groupID int64) *GroupState {
+	return &GroupState{
 		GroupID:    groupID,
 		Characters: make(map[string]*Character),
 	}
-	m.groups[groupID] = newState
-	return newState
+}
+
+// Swap 用 state 替换群组当前的状态并返回被替换的状态 (不存在时为 nil)
+// 被替换的状态不再写入存储；新状态之后的修改会写入存储，但不会立即整体写入一次，
+// 由调用方负责 (切换战役时与战役列表在同一个事务中写入)
+func (m *StateManager) Swap(groupID int64, state *GroupState) *GroupState {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	old := m.groups[groupID]
+	if old != nil {
+		old.attach(nil)
+	}
+	state.Mutex.Lock()
+	state.store = m.store
+	state.Mutex.Unlock()
+	m.groups[groupID] = state
+	return old
+}
+
+// SetBackground 设置本群背景，file 为空表示手写的场景描述 (保留原背景文件)
+// 更换背景文件意味着换了一张地图，队伍位置与已发现地点随之重置
+func (g *GroupState) SetBackground(file, content string) {
+	g.Mutex.Lock()
+	defer g.Mutex.Unlock()
+	defer g.save()
+
+	if file != "" && file != g.BgFile {
+		g.BgFile = file
+		g.Location = ""
+		g.Discovered = nil
+	}
+	g.Background = content
+}
+
+// GetBackground 本群的背景文件名与背景内容，未设置时均为空
+func (g *GroupState) GetBackground() (string, string) {
+	g.Mutex.RLock()
+	defer g.Mutex.RUnlock()
+	return g.BgFile, g.Background
 }
 
 // AddCharacter 添加角色
 func (g *GroupState) AddCharacter(char *Character) {
 	g.Mutex.Lock()
 	defer g.Mutex.Unlock()
+	defer g.save()
 	g.Characters[strings.ToLower(char.Name)] = char
+
+	// 战斗中途加入的角色自动投先攻
+	if g.Encounter != nil {
+		g.Encounter.insert(char)
+	}
 }
 
 // GetCharacter 获取角色
@@ -79,7 +195,31 @@ func (g *GroupState) GetCharacter(name string) *Character {
 func (g *GroupState) RemoveCharacter(name string) {
 	g.Mutex.Lock()
 	defer g.Mutex.Unlock()
+	defer g.save()
 	delete(g.Characters, strings.ToLower(name))
+	if g.Encounter != nil {
+		g.Encounter.remove(name)
+	}
+	g.Party.removeMember(name)
+}
+
+// CountPlayers 拥有角色的玩家人数 (按 OwnerID 去重，不含 AI 角色)
+func (g *GroupState) CountPlayers() int {
+	return len(g.PlayerOwners())
+}
+
+// PlayerOwners 拥有角色的玩家 (OwnerID)，不含 AI 角色与无主角色
+func (g *GroupState) PlayerOwners() map[int64]bool {
+	g.Mutex.RLock()
+	defer g.Mutex.RUnlock()
+
+	owners := make(map[int64]bool)
+	for _, char := range g.Characters {
+		if !char.IsAI && char.OwnerID != 0 {
+			owners[char.OwnerID] = true
+		}
+	}
+	return owners
 }
 
 // GetStatusSummary生成状态摘要，用于注入 Prompt
@@ -102,12 +242,63 @@ func (g *GroupState) GetStatusSummary() string {
 		if char.Status != "" {
 			statusApp = fmt.Sprintf(" [%s]", char.Status)
 		}
-		sb.WriteString(fmt.Sprintf("- [%s] %s (%s): HP %d/%d, STR %d%s\n",
-			roleType, char.Name, char.Class, char.HP, char.MaxHP, char.STR, statusApp))
+		if res := char.ResourceSummary(); res != "" {
+			statusApp += " 资源: " + res
+		}
+		sb.WriteString(fmt.Sprintf("- [%s] %s (%s): HP %d/%d, AC %d, STR %d, DEX %d%s\n",
+			roleType, char.Name, char.Class, char.HP, char.MaxHP, char.ArmorClass(), char.STR, char.DEX, statusApp))
 	}
 	return sb.String()
 }
 
+// GetClassFeatureSummary 列出当前玩家所选职业的特性，用于注入 Prompt
+func (g *GroupState) GetClassFeatureSummary() string {
+	g.Mutex.RLock()
+	defer g.Mutex.RUnlock()
+
+	seen := make(map[string]bool)
+	var sb strings.Builder
+	for _, char := range g.Characters {
+		if char.IsAI || seen[char.Class] {
+			continue
+		}
+		seen[char.Class] = true
+		if class := GlobalRules.GetClass(char.Class); class != nil {
+			sb.WriteString(class.FeatureSummary())
+		}
+	}
+	if sb.Len() == 0 {
+		return ""
+	}
+	return "【职业特性(裁定能力使用时以此为准)】:\n" + sb.String()
+}
+
+// ArmorClass 护甲等级，未设置时视为 10
+func (c *Character) ArmorClass() int {
+	if c.AC == 0 {
+		return 10
+	}
+	return c.AC
+}
+
+// ResourceSummary 以 "魔力 5, 战吼 2" 的形式列出角色资源
+func (c *Character) ResourceSummary() string {
+	if len(c.Resources) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(c.Resources))
+	for k := range c.Resources {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s %d", k, c.Resources[k]))
+	}
+	return strings.Join(parts, ", ")
+}
+
 // GetCharacterStatus 获取单个角色的详细状态
 func (g *GroupState) GetCharacterStatus(name string) string {
 	char := g.GetCharacter(name)
@@ -115,8 +306,30 @@ func (g *GroupState) GetCharacterStatus(name string) string {
 		return fmt.Sprintf("找不到角色: %s", name)
 	}
 
-	return fmt.Sprintf("【角色详情】\nName: %s\nClass: %s\nHP: %d/%d\nSTR: %d",
-		char.Name, char.Class, char.HP, char.MaxHP, char.STR)
+	status := fmt.Sprintf("【角色详情】\nName: %s\nClass: %s\nHP: %d/%d\nAC: %d\nSTR: %d\nDEX: %d",
+		char.Name, char.Class, char.HP, char.MaxHP, char.ArmorClass(), char.STR, char.DEX)
+	if char.CHA != 0 {
+		status += fmt.Sprintf("\nCHA: %d", char.CHA)
+	}
+	if char.Weapon != "" {
+		status += fmt.Sprintf("\nWeapon: %s", char.Weapon)
+	}
+	if char.Race != "" {
+		status += fmt.Sprintf("\nRace: %s", char.Race)
+	}
+	if !char.IsAI {
+		status += fmt.Sprintf("\nLevel: %d", char.EffectiveLevel())
+	}
+	if res := char.ResourceSummary(); res != "" {
+		status += fmt.Sprintf("\nResources: %s", res)
+	}
+	if !char.IsAI {
+		status += fmt.Sprintf("\nPurse: %s", FormatCoins(char.Purse))
+	}
+	if len(char.Inventory) > 0 {
+		status += fmt.Sprintf("\nInventory: %s", FormatItems(char.Inventory))
+	}
+	return status
 }
 
 // ExportData 导出所有游戏状态
@@ -126,38 +339,81 @@ func (m *StateManager) ExportData() map[int64]*GroupStateData {
 
 	data := make(map[int64]*GroupStateData)
 	for id, gs := range m.groups {
-		gs.Mutex.RLock()
-		charsCopy := make(map[string]*Character)
-		for k, v := range gs.Characters {
-			// Deep copy character struct
-			cVal := *v
-			charsCopy[k] = &cVal
-		}
-
-		data[id] = &GroupStateData{
-			GroupID:    gs.GroupID,
-			Characters: charsCopy,
-		}
-		gs.Mutex.RUnlock()
+		data[id] = gs.Data()
 	}
 	return data
 }
 
-// ImportData 导入游戏状态
+// Data 导出单个群组的状态
+func (gs *GroupState) Data() *GroupStateData {
+	gs.Mutex.RLock()
+	defer gs.Mutex.RUnlock()
+	return gs.data()
+}
+
+// data 导出单个群组的状态，调用方需持有锁
+func (gs *GroupState) data() *GroupStateData {
+	charsCopy := make(map[string]*Character)
+	for k, v := range gs.Characters {
+		// Deep copy character struct
+		charsCopy[k] = v.Clone()
+	}
+
+	return &GroupStateData{
+		GroupID:      gs.GroupID,
+		Characters:   charsCopy,
+		Encounter:    gs.Encounter.clone(),
+		NPCs:         cloneNPCs(gs.NPCs),
+		Quests:       cloneQuests(gs.Quests),
+		Location:     gs.Location,
+		Discovered:   cloneDiscovered(gs.Discovered),
+		Clock:        gs.Clock,
+		NextLongRest: gs.NextLongRest,
+		Events:       cloneEvents(gs.Events),
+		Stash:        gs.Stash.clone(),
+		ShopSold:     cloneCounts(gs.ShopSold),
+		Haggles:      cloneCounts(gs.Haggles),
+		Party:        gs.Party.clone(),
+		Background:   gs.Background,
+		BgFile:       gs.BgFile,
+	}
+}
+
+// ImportData 导入游戏状态，设置了存储时同时写入存储
 func (m *StateManager) ImportData(data map[int64]*GroupStateData) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
 	for id, gData := range data {
-		newState := &GroupState{
-			GroupID:    gData.GroupID,
-			Characters: make(map[string]*Character),
-		}
+		gs := GroupStateFromData(gData)
+		gs.attach(m.store)
+		m.groups[id] = gs
+	}
+}
 
-		for k, v := range gData.Characters {
-			cVal := *v // Copy value
-			newState.Characters[k] = &cVal
-		}
-		m.groups[id] = newState
+// GroupStateFromData 从导出数据重建群组状态
+func GroupStateFromData(gData *GroupStateData) *GroupState {
+	newState := &GroupState{
+		GroupID:      gData.GroupID,
+		Characters:   make(map[string]*Character),
+		Encounter:    gData.Encounter.clone(),
+		NPCs:         cloneNPCs(gData.NPCs),
+		Quests:       cloneQuests(gData.Quests),
+		Location:     gData.Location,
+		Discovered:   cloneDiscovered(gData.Discovered),
+		Clock:        gData.Clock,
+		NextLongRest: gData.NextLongRest,
+		Events:       cloneEvents(gData.Events),
+		Stash:        gData.Stash.clone(),
+		ShopSold:     cloneCounts(gData.ShopSold),
+		Haggles:      cloneCounts(gData.Haggles),
+		Party:        gData.Party.clone(),
+		Background:   gData.Background,
+		BgFile:       gData.BgFile,
 	}
+
+	for k, v := range gData.Characters {
+		newState.Characters[k] = v.Clone()
+	}
+	return newState
 }
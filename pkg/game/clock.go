@@ -0,0 +1,420 @@
+package game
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"dndbot/pkg/dice"
+)
+
+// Month 历法中的一个月
+type Month struct {
+	Name string `json:"name"`
+	Days int    `json:"days"`
+}
+
+// DayPeriod 一天中的时段，从 From 点开始
+type DayPeriod struct {
+	Name  string `json:"name"`
+	From  int    `json:"from"`
+	Night bool   `json:"night"`
+}
+
+// Calendar 游戏历法与休息规则，从 background/calendar.json 加载
+type Calendar struct {
+	Era        string      `json:"era"` // 纪年名称，例如 "精灵历"
+	Months     []Month     `json:"months"`
+	Periods    []DayPeriod `json:"periods"`
+	StartYear  int         `json:"start_year"`
+	StartMonth int         `json:"start_month"`
+	StartDay   int         `json:"start_day"`
+	StartHour  int         `json:"start_hour"`
+
+	LongRestHours         int    `json:"long_rest_hours"`
+	LongRestCooldownHours int    `json:"long_rest_cooldown_hours"` // 两次长休之间的最短间隔
+	ShortRestMinutes      int    `json:"short_rest_minutes"`
+	ShortRestHeal         string `json:"short_rest_heal"` // 短休恢复的生命骰
+}
+
+// GameTime 游戏内的一个时刻
+type GameTime struct {
+	Year   int
+	Month  string
+	Day    int
+	Hour   int
+	Minute int
+	Period string
+	Night  bool
+}
+
+// TimedEvent 到点触发的剧情事件，只有 DM 知道
+type TimedEvent struct {
+	At   int    `json:"at"` // 触发时刻 (游戏分钟)
+	Text string `json:"text"`
+}
+
+// TimeAdvance 一次时间推进的结果
+type TimeAdvance struct {
+	From    GameTime
+	To      GameTime
+	Minutes int
+	Expired []string // 到期解除的状态
+	Events  []string // 触发的事件
+}
+
+// RestResult 一次休息的结果
+type RestResult struct {
+	Kind    string // "long" / "short"
+	Advance *TimeAdvance
+	Healed  []string
+	HP      []*HPChange // 恢复的生命值，用于撤销
+}
+
+// DefaultCalendar 没有 calendar.json 时使用的历法: 12 个 30 天的月份
+func DefaultCalendar() *Calendar {
+	c := &Calendar{StartYear: 1, StartMonth: 1, StartDay: 1, StartHour: 18}
+	for i := 1; i <= 12; i++ {
+		c.Months = append(c.Months, Month{Name: fmt.Sprintf("%d月", i), Days: 30})
+	}
+	c.applyDefaults()
+	return c
+}
+
+func (c *Calendar) applyDefaults() {
+	if len(c.Periods) == 0 {
+		c.Periods = []DayPeriod{
+			{Name: "深夜", From: 0, Night: true},
+			{Name: "黎明", From: 5},
+			{Name: "上午", From: 7},
+			{Name: "正午", From: 11},
+			{Name: "下午", From: 13},
+			{Name: "黄昏", From: 18},
+			{Name: "夜晚", From: 20, Night: true},
+		}
+	}
+	sort.Slice(c.Periods, func(i, j int) bool { return c.Periods[i].From < c.Periods[j].From })
+	if c.StartMonth < 1 {
+		c.StartMonth = 1
+	}
+	if c.StartDay < 1 {
+		c.StartDay = 1
+	}
+	if c.LongRestHours == 0 {
+		c.LongRestHours = 8
+	}
+	if c.LongRestCooldownHours == 0 {
+		c.LongRestCooldownHours = 24
+	}
+	if c.ShortRestMinutes == 0 {
+		c.ShortRestMinutes = 60
+	}
+	if c.ShortRestHeal == "" {
+		c.ShortRestHeal = "2d6"
+	}
+}
+
+var GlobalCalendar = DefaultCalendar()
+
+// LoadCalendar 从 JSON 文件加载历法并设置为全局历法
+func LoadCalendar(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var c Calendar
+	if err := json.Unmarshal(data, &c); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	if len(c.Months) == 0 {
+		return fmt.Errorf("%s: no months defined", path)
+	}
+	for _, m := range c.Months {
+		if m.Days < 1 {
+			return fmt.Errorf("%s: month %s has no days", path, m.Name)
+		}
+	}
+	if c.StartMonth > len(c.Months) {
+		return fmt.Errorf("%s: start_month out of range", path)
+	}
+	if _, _, _, err := dice.Parse(c.ShortRestHeal); c.ShortRestHeal != "" && err != nil {
+		return fmt.Errorf("%s: invalid short_rest_heal %q", path, c.ShortRestHeal)
+	}
+
+	c.applyDefaults()
+	GlobalCalendar = &c
+	return nil
+}
+
+// Time 将开局以来经过的分钟数换算为日历时刻
+func (c *Calendar) Time(elapsed int) GameTime {
+	yearDays := 0
+	startDay := c.StartDay - 1
+	for i, m := range c.Months {
+		yearDays += m.Days
+		if i < c.StartMonth-1 {
+			startDay += m.Days
+		}
+	}
+
+	total := (startDay*24+c.StartHour)*60 + elapsed
+	t := GameTime{Year: c.StartYear + total/(yearDays*1440)}
+	rem := total % (yearDays * 1440)
+
+	day := rem / 1440
+	for _, m := range c.Months {
+		if day < m.Days {
+			t.Month = m.Name
+			t.Day = day + 1
+			break
+		}
+		day -= m.Days
+	}
+	t.Hour = rem % 1440 / 60
+	t.Minute = rem % 60
+
+	for _, p := range c.Periods {
+		if p.From <= t.Hour {
+			t.Period, t.Night = p.Name, p.Night
+		}
+	}
+	if t.Period == "" && len(c.Periods) > 0 {
+		last := c.Periods[len(c.Periods)-1]
+		t.Period, t.Night = last.Name, last.Night
+	}
+	return t
+}
+
+// String 例如 "精灵历 1年 3月 5日 18:30 (黄昏)"
+func (t GameTime) String() string {
+	return fmt.Sprintf("%d年 %s %d日 %02d:%02d (%s)", t.Year, t.Month, t.Day, t.Hour, t.Minute, t.Period)
+}
+
+// format 带纪年名称的时间文本
+func (c *Calendar) format(t GameTime) string {
+	if c.Era == "" {
+		return t.String()
+	}
+	return c.Era + " " + t.String()
+}
+
+func cloneEvents(events []*TimedEvent) []*TimedEvent {
+	if events == nil {
+		return nil
+	}
+	c := make([]*TimedEvent, len(events))
+	for i, e := range events {
+		ev := *e
+		c[i] = &ev
+	}
+	return c
+}
+
+// advanceTime 推进时钟并处理到期状态与定时事件，调用方需持有锁
+func (g *GroupState) advanceTime(c *Calendar, minutes int) *TimeAdvance {
+	if minutes < 0 {
+		minutes = 0
+	}
+	adv := &TimeAdvance{From: c.Time(g.Clock), Minutes: minutes}
+	g.Clock += minutes
+	adv.To = c.Time(g.Clock)
+
+	for _, name := range sortedKeys(g.Characters) {
+		char := g.Characters[name]
+		if char.StatusUntil > 0 && char.StatusUntil <= g.Clock {
+			adv.Expired = append(adv.Expired, fmt.Sprintf("%s 的 %s 状态结束", char.Name, char.Status))
+			char.Status = ""
+			char.StatusUntil = 0
+		}
+	}
+
+	var pending []*TimedEvent
+	for _, e := range g.Events {
+		if e.At <= g.Clock {
+			adv.Events = append(adv.Events, e.Text)
+		} else {
+			pending = append(pending, e)
+		}
+	}
+	g.Events = pending
+	return adv
+}
+
+// AdvanceTime 推进游戏时间
+func (g *GroupState) AdvanceTime(c *Calendar, minutes int) *TimeAdvance {
+	g.Mutex.Lock()
+	defer g.Mutex.Unlock()
+	defer g.save()
+	return g.advanceTime(c, minutes)
+}
+
+// ScheduleEvent 安排一个在 minutes 分钟后触发的事件
+func (g *GroupState) ScheduleEvent(minutes int, text string) {
+	g.Mutex.Lock()
+	defer g.Mutex.Unlock()
+	defer g.save()
+	g.Events = append(g.Events, &TimedEvent{At: g.Clock + minutes, Text: text})
+	sort.SliceStable(g.Events, func(i, j int) bool { return g.Events[i].At < g.Events[j].At })
+}
+
+// SetCondition 为角色设置状态，minutes > 0 时到期自动解除
+func (g *GroupState) SetCondition(name, status string, minutes int) error {
+	g.Mutex.Lock()
+	defer g.Mutex.Unlock()
+	defer g.save()
+
+	char := g.Characters[strings.ToLower(name)]
+	if char == nil {
+		return fmt.Errorf("unknown char '%s'", name)
+	}
+	char.Status = status
+	char.StatusUntil = 0
+	if minutes > 0 && status != "" {
+		char.StatusUntil = g.Clock + minutes
+	}
+	return nil
+}
+
+// Rest 队伍休息: 长休恢复全部生命与每日资源，短休恢复生命骰
+// 生命值归零的角色两种休息都不会恢复
+func (g *GroupState) Rest(c *Calendar, kind string) (*RestResult, error) {
+	g.Mutex.Lock()
+	defer g.Mutex.Unlock()
+	defer g.save()
+
+	result := &RestResult{Kind: kind}
+	switch kind {
+	case "long":
+		if g.NextLongRest > g.Clock {
+			return nil, fmt.Errorf("距离上次长休不足 %d 小时，还需 %s", c.LongRestCooldownHours, FormatMinutes(g.NextLongRest-g.Clock))
+		}
+		start := g.Clock
+		result.Advance = g.advanceTime(c, c.LongRestHours*60)
+		g.NextLongRest = start + c.LongRestCooldownHours*60
+		g.Haggles = nil // 商人第二天不再记得讲价
+
+		for _, name := range sortedKeys(g.Characters) {
+			char := g.Characters[name]
+			if char.IsAI {
+				continue
+			}
+			if char.HP <= 0 {
+				// 倒地的角色不会因为睡一觉而复活，需要先救治
+				result.Healed = append(result.Healed, fmt.Sprintf("%s 仍然倒地，无法从长休中恢复", char.Name))
+				continue
+			}
+			if char.HP != char.MaxHP {
+				result.HP = append(result.HP, &HPChange{Name: char.Name, Delta: char.MaxHP - char.HP, OldHP: char.HP, NewHP: char.MaxHP})
+			}
+			char.HP = char.MaxHP
+			char.Status = ""
+			char.StatusUntil = 0
+			if class := GlobalRules.GetClass(char.Class); class != nil && len(class.Resources) > 0 {
+				char.Resources = make(map[string]int, len(class.Resources))
+				for k, v := range class.Resources {
+					char.Resources[k] = v
+				}
+			}
+			result.Healed = append(result.Healed, fmt.Sprintf("%s HP %d/%d", char.Name, char.HP, char.MaxHP))
+		}
+
+	case "short":
+		result.Advance = g.advanceTime(c, c.ShortRestMinutes)
+		for _, name := range sortedKeys(g.Characters) {
+			char := g.Characters[name]
+			if char.IsAI || char.HP <= 0 {
+				continue
+			}
+			heal, err := dice.Roll(c.ShortRestHeal)
+			if err != nil {
+				return nil, err
+			}
+			old := char.HP
+			char.HP += heal.Total
+			if char.HP > char.MaxHP {
+				char.HP = char.MaxHP
+			}
+			if char.HP != old {
+				result.HP = append(result.HP, &HPChange{Name: char.Name, Delta: char.HP - old, OldHP: old, NewHP: char.HP})
+			}
+			result.Healed = append(result.Healed, fmt.Sprintf("%s HP %d -> %d", char.Name, old, char.HP))
+		}
+
+	default:
+		return nil, fmt.Errorf("未知的休息类型: %s", kind)
+	}
+	return result, nil
+}
+
+// String 时间推进文本
+func (a *TimeAdvance) String() string {
+	s := fmt.Sprintf("时间流逝 %s，现在是 %s", FormatMinutes(a.Minutes), a.To.String())
+	if a.From.Night != a.To.Night {
+		if a.To.Night {
+			s += "，夜幕降临"
+		} else {
+			s += "，天亮了"
+		}
+	}
+	for _, e := range a.Expired {
+		s += "\n- " + e
+	}
+	for _, e := range a.Events {
+		s += "\n- 【事件】" + e
+	}
+	return s
+}
+
+// GetTimeSummary 玩家视角的时间信息
+func (g *GroupState) GetTimeSummary(c *Calendar) string {
+	g.Mutex.RLock()
+	now := c.Time(g.Clock)
+	wait := g.NextLongRest - g.Clock
+	g.Mutex.RUnlock()
+
+	s := "🕰️ " + c.format(now)
+	if wait > 0 {
+		s += fmt.Sprintf("\n距离可以再次长休还需 %s", FormatMinutes(wait))
+	} else {
+		s += "\n现在可以进行长休"
+	}
+	return s
+}
+
+// GetClockSummary 当前时间、长休状态与待触发事件，用于注入 Prompt
+func (g *GroupState) GetClockSummary(c *Calendar) string {
+	g.Mutex.RLock()
+	defer g.Mutex.RUnlock()
+
+	now := c.Time(g.Clock)
+	s := "【游戏时间】" + c.format(now)
+	if now.Night {
+		s += "，夜间视野受限"
+	}
+	if g.NextLongRest > g.Clock {
+		s += fmt.Sprintf("。队伍还需 %s 才能再次长休", FormatMinutes(g.NextLongRest-g.Clock))
+	}
+	s += "\n"
+	if len(g.Events) > 0 {
+		s += "【待触发事件(仅 DM 可见)】: "
+		parts := make([]string, 0, len(g.Events))
+		for _, e := range g.Events {
+			parts = append(parts, fmt.Sprintf("%s后: %s", FormatMinutes(e.At-g.Clock), e.Text))
+		}
+		s += strings.Join(parts, "; ") + "\n"
+	}
+	return s
+}
+
+// sortedKeys 按字母顺序返回角色 Key，保证结算顺序稳定
+func sortedKeys(chars map[string]*Character) []string {
+	keys := make([]string, 0, len(chars))
+	for k := range chars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,105 @@
+package game
+
+import "testing"
+
+func TestLoadCalendar_ShippedFile(t *testing.T) {
+	if err := LoadCalendar("../../background/calendar.json"); err != nil {
+		t.Fatalf("load calendar: %v", err)
+	}
+	defer func() { GlobalCalendar = DefaultCalendar() }()
+
+	now := GlobalCalendar.Time(0)
+	if now.Month != "幽光月" || now.Day != 13 || now.Hour != 18 {
+		t.Errorf("unexpected start time %v", now)
+	}
+}
+
+func TestCalendarTime_Rollover(t *testing.T) {
+	c := DefaultCalendar() // 12 x 30 天，从 1年 1月 1日 18:00 开始
+
+	tm := c.Time(6*60 + 30)
+	if tm.Day != 2 || tm.Hour != 0 || tm.Minute != 30 || !tm.Night {
+		t.Errorf("expected day 2 00:30 at night, got %+v", tm)
+	}
+	tm = c.Time(360 * 1440)
+	if tm.Year != 2 || tm.Month != "1月" || tm.Day != 1 {
+		t.Errorf("expected rollover to year 2, got %+v", tm)
+	}
+}
+
+func TestAdvanceTime_ExpiresConditionsAndFiresEvents(t *testing.T) {
+	c := DefaultCalendar()
+	g := newTestGroup(&Character{Name: "Hero", HP: 10, MaxHP: 10})
+
+	if err := g.SetCondition("Hero", "中毒", 60); err != nil {
+		t.Fatal(err)
+	}
+	g.ScheduleEvent(90, "追兵抵达")
+
+	adv := g.AdvanceTime(c, 60)
+	if len(adv.Expired) != 1 || g.GetCharacter("Hero").Status != "" {
+		t.Errorf("poison should expire after 60 minutes: %+v", adv)
+	}
+	if len(adv.Events) != 0 {
+		t.Errorf("event fired too early: %v", adv.Events)
+	}
+	if adv = g.AdvanceTime(c, 30); len(adv.Events) != 1 || adv.Events[0] != "追兵抵达" {
+		t.Errorf("expected event to fire, got %v", adv.Events)
+	}
+}
+
+func TestRest_LongRestCooldown(t *testing.T) {
+	c := DefaultCalendar()
+	g := newTestGroup(&Character{Name: "Hero", HP: 3, MaxHP: 12, Status: "昏迷"})
+
+	res, err := g.Rest(c, "long")
+	if err != nil {
+		t.Fatalf("first long rest: %v", err)
+	}
+	if hero := g.GetCharacter("Hero"); hero.HP != 12 || hero.Status != "" || res.Advance.Minutes != 480 {
+		t.Errorf("long rest did not restore: %+v", hero)
+	}
+	if _, err := g.Rest(c, "long"); err == nil {
+		t.Error("second long rest within 24 hours should fail")
+	}
+
+	g.AdvanceTime(c, 16*60)
+	if _, err := g.Rest(c, "long"); err != nil {
+		t.Errorf("long rest after 24 hours should succeed: %v", err)
+	}
+}
+
+func TestRest_LongRestDoesNotReviveDowned(t *testing.T) {
+	c := DefaultCalendar()
+	g := newTestGroup(
+		&Character{Name: "Hero", HP: 0, MaxHP: 12, Status: "昏迷"},
+		&Character{Name: "Mage", HP: 2, MaxHP: 8},
+	)
+
+	if _, err := g.Rest(c, "long"); err != nil {
+		t.Fatal(err)
+	}
+	if hero := g.GetCharacter("Hero"); hero.HP != 0 || hero.Status != "昏迷" {
+		t.Errorf("downed character should not be revived by a long rest: %+v", hero)
+	}
+	if mage := g.GetCharacter("Mage"); mage.HP != 8 {
+		t.Errorf("conscious character should be healed: %+v", mage)
+	}
+}
+
+func TestRest_RecordsHealing(t *testing.T) {
+	c := DefaultCalendar()
+	g := newTestGroup(&Character{Name: "Hero", HP: 3, MaxHP: 12})
+
+	res, err := g.Rest(c, "long")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.HP) != 1 {
+		t.Fatalf("expected one HP change, got %+v", res.HP)
+	}
+	g.RevertHPChange(res.HP[0])
+	if hero := g.GetCharacter("Hero"); hero.HP != 3 {
+		t.Errorf("healing not reverted: %d", hero.HP)
+	}
+}
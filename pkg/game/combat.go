@@ -0,0 +1,345 @@
+package game
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"dndbot/pkg/dice"
+)
+
+// HPChange 一次生命值变化的结果
+type HPChange struct {
+	Name    string
+	IsAI    bool
+	Delta   int
+	OldHP   int
+	NewHP   int
+	Removed bool // NPC 死亡后被移除
+	Downed  bool // 玩家倒地昏迷
+
+	// 用于 RevertHPChange
+	oldStatus string
+	removed   *Character       // 被移除的 NPC
+	entry     *InitiativeEntry // 被移除的 NPC 在先攻列表中的一项
+}
+
+// ApplyHPChange 修改角色生命值并处理死亡/昏迷
+// NPC 生命归零时移出群组，玩家则标记为昏迷
+func (g *GroupState) ApplyHPChange(name string, delta int) (*HPChange, error) {
+	g.Mutex.Lock()
+	defer g.Mutex.Unlock()
+	defer g.save()
+
+	char := g.Characters[strings.ToLower(name)]
+	if char == nil {
+		return nil, fmt.Errorf("unknown char '%s'", name)
+	}
+
+	change := &HPChange{Name: char.Name, IsAI: char.IsAI, Delta: delta, OldHP: char.HP, oldStatus: char.Status}
+	char.HP += delta
+	if char.HP > char.MaxHP {
+		char.HP = char.MaxHP
+	}
+	change.NewHP = char.HP
+
+	if char.HP <= 0 {
+		if char.IsAI {
+			delete(g.Characters, strings.ToLower(char.Name))
+			if g.Encounter != nil {
+				if idx := g.Encounter.indexOf(char.Name); idx >= 0 {
+					entry := *g.Encounter.Order[idx]
+					change.entry = &entry
+				}
+				g.Encounter.remove(char.Name)
+			}
+			change.Removed = true
+			change.removed = char
+		} else {
+			char.Status = "昏迷"
+			char.HP = 0
+			change.NewHP = 0
+			change.Downed = true
+		}
+	}
+	return change, nil
+}
+
+// RevertHPChange 撤销一次生命值变化 (用于撤销回合)
+// 按实际变化量反向修改血量，期间的其他变化会保留；死亡被移除的 NPC 以原来的先攻重新加入，
+// 同名角色已经存在或角色已被移除时不做修改
+func (g *GroupState) RevertHPChange(c *HPChange) {
+	g.Mutex.Lock()
+	defer g.Mutex.Unlock()
+	defer g.save()
+
+	key := strings.ToLower(c.Name)
+	if c.Removed {
+		if c.removed == nil || g.Characters[key] != nil {
+			return
+		}
+		c.removed.HP = c.OldHP
+		g.Characters[key] = c.removed
+		if g.Encounter != nil && c.entry != nil {
+			entry := *c.entry
+			g.Encounter.insertEntry(&entry)
+		}
+		return
+	}
+
+	char := g.Characters[key]
+	if char == nil {
+		return
+	}
+	char.HP -= c.NewHP - c.OldHP
+	if char.HP > char.MaxHP {
+		char.HP = char.MaxHP
+	}
+	if char.HP < 0 {
+		char.HP = 0
+	}
+	if c.Downed && char.HP > 0 && char.Status == "昏迷" {
+		char.Status = c.oldStatus
+	}
+}
+
+// AttackRequest 一次攻击的输入
+type AttackRequest struct {
+	Attacker string
+	Target   string
+	Weapon   string // 武器名，为空时使用攻击者默认武器
+	Damage   string // 自定义伤害骰(未登记的武器/天生武器)，例如 1d6+2
+	Bonus    *int   // 自定义命中加值，nil 时按属性修正 + 熟练计算
+}
+
+// AttackResult 引擎结算后的结构化攻击结果，交给 DM 叙述
+type AttackResult struct {
+	Attacker    string
+	Target      string
+	Weapon      string
+	Roll        int
+	Bonus       int
+	Total       int
+	TargetAC    int
+	Hit         bool
+	Critical    bool
+	DamageExpr  string
+	DamageRolls []int
+	Damage      int
+	HP          *HPChange
+}
+
+// attackProfile 计算攻击者使用某武器的命中加值与伤害骰
+func attackProfile(attacker *Character, req AttackRequest) (string, int, string, error) {
+	weaponName := req.Weapon
+	if weaponName == "" && req.Damage == "" {
+		weaponName = attacker.Weapon
+	}
+
+	// 怪物自带的攻击已包含命中与伤害修正
+	if a := attacker.findAttack(weaponName); a != nil && req.Damage == "" {
+		bonus := a.Bonus
+		if req.Bonus != nil {
+			bonus = *req.Bonus
+		}
+		return a.Name, bonus, a.Damage, nil
+	}
+
+	abilityMod := AbilityModifier(attacker.STR)
+	damage := req.Damage
+	if w := GlobalRules.GetWeapon(weaponName); w != nil {
+		weaponName = w.Name
+		if strings.EqualFold(w.Ability, "DEX") {
+			abilityMod = attacker.DexModifier()
+		}
+		if damage == "" {
+			damage = w.Damage
+		}
+	}
+	if damage == "" {
+		if weaponName != "" {
+			return "", 0, "", fmt.Errorf("未知武器: %s", weaponName)
+		}
+		weaponName = "徒手"
+		damage = "1d4"
+	}
+
+	count, sides, mod, err := dice.Parse(damage)
+	if err != nil {
+		return "", 0, "", err
+	}
+	if req.Damage == "" {
+		mod += abilityMod
+	}
+
+	bonus := abilityMod + GlobalRules.Proficiency
+	if req.Bonus != nil {
+		bonus = *req.Bonus
+	}
+	return weaponName, bonus, dice.Format(count, sides, mod), nil
+}
+
+// ResolveAttack 掷命中骰对比目标 AC，命中后掷伤害并扣除目标生命
+// 天然 20 必定命中且伤害骰数量翻倍，天然 1 必定未命中
+func (g *GroupState) ResolveAttack(req AttackRequest) (*AttackResult, error) {
+	attacker := g.GetCharacter(req.Attacker)
+	if attacker == nil {
+		return nil, fmt.Errorf("找不到攻击者: %s", req.Attacker)
+	}
+	target := g.GetCharacter(req.Target)
+	if target == nil {
+		return nil, fmt.Errorf("找不到目标: %s", req.Target)
+	}
+
+	g.Mutex.RLock()
+	weapon, bonus, damageExpr, err := attackProfile(attacker, req)
+	targetAC := target.ArmorClass()
+	attackerName, targetName := attacker.Name, target.Name
+	downed := attacker.HP <= 0
+	g.Mutex.RUnlock()
+	if downed {
+		return nil, fmt.Errorf("%s 已经倒地昏迷，无法攻击", attackerName)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	toHit, _ := dice.Roll("1d20")
+	natural := toHit.Details[0]
+	result := &AttackResult{
+		Attacker: attackerName,
+		Target:   targetName,
+		Weapon:   weapon,
+		Roll:     natural,
+		Bonus:    bonus,
+		Total:    natural + bonus,
+		TargetAC: targetAC,
+		Critical: natural == 20,
+	}
+	result.Hit = natural != 1 && (result.Critical || result.Total >= targetAC)
+	if !result.Hit {
+		return result, nil
+	}
+
+	count, sides, mod, _ := dice.Parse(damageExpr)
+	if result.Critical {
+		count *= 2
+	}
+	result.DamageExpr = dice.Format(count, sides, mod)
+	dmg, err := dice.Roll(result.DamageExpr)
+	if err != nil {
+		return nil, err
+	}
+	result.DamageRolls = dmg.Details
+	result.Damage = dmg.Total
+	if result.Damage < 1 {
+		result.Damage = 1
+	}
+
+	result.HP, err = g.ApplyHPChange(targetName, -result.Damage)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// String 攻击结果文本，供播报与写入上下文
+func (r *AttackResult) String() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("⚔️ %s 使用 %s 攻击 %s: 🎲 d20 [%d] %+d = %d vs AC %d → ",
+		r.Attacker, r.Weapon, r.Target, r.Roll, r.Bonus, r.Total, r.TargetAC))
+
+	switch {
+	case r.Roll == 1:
+		sb.WriteString("大失败，未命中")
+		return sb.String()
+	case !r.Hit:
+		sb.WriteString("未命中")
+		return sb.String()
+	case r.Critical:
+		sb.WriteString("重击!")
+	default:
+		sb.WriteString("命中!")
+	}
+
+	rolls := make([]string, len(r.DamageRolls))
+	for i, v := range r.DamageRolls {
+		rolls[i] = fmt.Sprint(v)
+	}
+	sb.WriteString(fmt.Sprintf(" 伤害 %s [%s] = %d", r.DamageExpr, strings.Join(rolls, ", "), r.Damage))
+	if r.HP != nil {
+		sb.WriteString(fmt.Sprintf(" (%s HP %d -> %d)", r.HP.Name, r.HP.OldHP, r.HP.NewHP))
+	}
+	return sb.String()
+}
+
+// AttackerFor 确定 .atk 的攻击者
+// 指定 name 时使用该角色: 没有记录创建者的旧角色 (OwnerID 为 0) 会归属给 ownerID；
+// 未指定时优先当前行动者，其次是玩家唯一的角色，玩家有多个角色时要求指定
+func (g *GroupState) AttackerFor(ownerID int64, name string) (*Character, error) {
+	g.Mutex.Lock()
+	defer g.Mutex.Unlock()
+	defer g.save()
+
+	if name != "" {
+		char := g.Characters[strings.ToLower(name)]
+		switch {
+		case char == nil:
+			return nil, fmt.Errorf("找不到角色: %s", name)
+		case char.IsAI:
+			return nil, fmt.Errorf("%s 是 NPC，由 DM 操控", char.Name)
+		case char.OwnerID != 0 && char.OwnerID != ownerID:
+			return nil, fmt.Errorf("%s 不是你的角色", char.Name)
+		}
+		char.OwnerID = ownerID
+		return char, nil
+	}
+
+	if current := g.Encounter.Current(); current != nil {
+		if char := g.Characters[strings.ToLower(current.Name)]; char != nil && !char.IsAI && char.OwnerID == ownerID {
+			return char, nil
+		}
+	}
+	var owned []*Character
+	for _, char := range g.Characters {
+		if !char.IsAI && char.OwnerID == ownerID {
+			owned = append(owned, char)
+		}
+	}
+	switch len(owned) {
+	case 0:
+		return nil, fmt.Errorf("你还没有角色，请先使用 .st 创建角色 (旧角色可以用 by=角色名 认领)")
+	case 1:
+		return owned[0], nil
+	}
+	names := make([]string, len(owned))
+	for i, c := range owned {
+		names[i] = c.Name
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("你有多个角色 (%s)，请用 by=角色名 指定攻击者", strings.Join(names, "/"))
+}
+
+// FindCharacterByOwner 查找玩家(QQ)控制的角色
+// 战斗中优先返回当前行动者，否则按名称顺序返回第一个
+func (g *GroupState) FindCharacterByOwner(ownerID int64) *Character {
+	g.Mutex.RLock()
+	defer g.Mutex.RUnlock()
+
+	if current := g.Encounter.Current(); current != nil {
+		if char := g.Characters[strings.ToLower(current.Name)]; char != nil && !char.IsAI && char.OwnerID == ownerID {
+			return char
+		}
+	}
+
+	var found *Character
+	for _, char := range g.Characters {
+		if char.IsAI || char.OwnerID != ownerID {
+			continue
+		}
+		if found == nil || char.Name < found.Name {
+			found = char
+		}
+	}
+	return found
+}
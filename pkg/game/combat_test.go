@@ -0,0 +1,131 @@
+package game
+
+import "testing"
+
+func TestApplyHPChange_NPCDiesAndLeavesEncounter(t *testing.T) {
+	g := newTestGroup(&Character{Name: "亚瑟", HP: 10, MaxHP: 10}, &Character{Name: "Goblin", HP: 5, MaxHP: 5, IsAI: true})
+	g.StartEncounter()
+
+	change, err := g.ApplyHPChange("goblin", -7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !change.Removed || change.OldHP != 5 {
+		t.Errorf("expected Goblin removed from 5 HP, got %+v", change)
+	}
+	if g.GetCharacter("Goblin") != nil {
+		t.Error("dead NPC should be removed")
+	}
+	if len(g.GetEncounter().Order) != 1 {
+		t.Error("dead NPC should leave the initiative order")
+	}
+}
+
+func TestApplyHPChange_PCDownedAndClamped(t *testing.T) {
+	g := newTestGroup(&Character{Name: "亚瑟", HP: 4, MaxHP: 10})
+
+	if change, _ := g.ApplyHPChange("亚瑟", 20); change.NewHP != 10 {
+		t.Errorf("healing should clamp to MaxHP, got %d", change.NewHP)
+	}
+	change, _ := g.ApplyHPChange("亚瑟", -15)
+	if !change.Downed || change.NewHP != 0 {
+		t.Errorf("expected PC downed at 0 HP, got %+v", change)
+	}
+	if g.GetCharacter("亚瑟").Status != "昏迷" {
+		t.Error("downed PC should be marked 昏迷")
+	}
+}
+
+func TestRevertHPChange(t *testing.T) {
+	g := newTestGroup(&Character{Name: "亚瑟", HP: 6, MaxHP: 10, Status: "正常"}, &Character{Name: "Goblin", HP: 5, MaxHP: 5, IsAI: true})
+	g.StartEncounter()
+	before := g.GetEncounter()
+
+	killed, _ := g.ApplyHPChange("Goblin", -7)
+	downed, _ := g.ApplyHPChange("亚瑟", -8)
+	// 期间的治疗与撤销无关，应当保留
+	g.ApplyHPChange("亚瑟", 3)
+
+	g.RevertHPChange(downed)
+	if c := g.GetCharacter("亚瑟"); c.HP != 9 || c.Status != "正常" {
+		t.Errorf("expected 亚瑟 back to 9 HP (6 + 3) and 正常, got %d %s", c.HP, c.Status)
+	}
+	g.RevertHPChange(killed)
+	if c := g.GetCharacter("Goblin"); c == nil || c.HP != 5 {
+		t.Fatalf("killed NPC should come back with 5 HP, got %+v", c)
+	}
+	enc := g.GetEncounter()
+	if len(enc.Order) != 2 || enc.Order[enc.indexOf("Goblin")].Total != before.Order[before.indexOf("Goblin")].Total {
+		t.Errorf("NPC should rejoin with its original initiative: %+v", enc.Order)
+	}
+}
+
+func TestResolveAttack(t *testing.T) {
+	GlobalRules = &Ruleset{Proficiency: 2, Weapons: []*WeaponDef{{Name: "长剑", Damage: "1d8", Ability: "STR"}}}
+	defer func() { GlobalRules = &Ruleset{Proficiency: 2} }()
+
+	for i := 0; i < 50; i++ {
+		g := newTestGroup(
+			&Character{Name: "亚瑟", STR: 16, Weapon: "长剑", HP: 10, MaxHP: 10},
+			&Character{Name: "Dummy", HP: 100, MaxHP: 100, AC: 12},
+		)
+		res, err := g.ResolveAttack(AttackRequest{Attacker: "亚瑟", Target: "Dummy"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Bonus != 5 || res.Weapon != "长剑" {
+			t.Fatalf("expected 长剑 with +5 to hit, got %s %+d", res.Weapon, res.Bonus)
+		}
+		if res.Roll == 1 && res.Hit {
+			t.Error("natural 1 must miss")
+		}
+		if res.Roll == 20 && (!res.Hit || res.DamageExpr != "2d8+3") {
+			t.Errorf("natural 20 must crit with doubled dice, got %s", res.DamageExpr)
+		}
+		if res.Hit {
+			if res.HP == nil || res.HP.NewHP != 100-res.Damage {
+				t.Errorf("damage %d not applied: %+v", res.Damage, res.HP)
+			}
+		} else if g.GetCharacter("Dummy").HP != 100 {
+			t.Error("a miss must not change HP")
+		}
+	}
+}
+
+func TestResolveAttack_DownedAttacker(t *testing.T) {
+	g := newTestGroup(
+		&Character{Name: "亚瑟", STR: 16, HP: 0, MaxHP: 10},
+		&Character{Name: "Dummy", HP: 100, MaxHP: 100, AC: 12},
+	)
+	if _, err := g.ResolveAttack(AttackRequest{Attacker: "亚瑟", Target: "Dummy"}); err == nil {
+		t.Error("an unconscious attacker must not attack")
+	}
+}
+
+func TestAttackerFor(t *testing.T) {
+	g := newTestGroup(
+		&Character{Name: "亚瑟", HP: 10, OwnerID: 1},
+		&Character{Name: "梅林", HP: 10, OwnerID: 1},
+		&Character{Name: "老兵", HP: 10}, // 旧版本创建，没有记录创建者
+		&Character{Name: "Goblin", HP: 5, IsAI: true},
+	)
+
+	if _, err := g.AttackerFor(1, ""); err == nil {
+		t.Error("owner of several characters must name the attacker")
+	}
+	if c, err := g.AttackerFor(1, "梅林"); err != nil || c.Name != "梅林" {
+		t.Errorf("expected 梅林, got %v %v", c, err)
+	}
+	if _, err := g.AttackerFor(2, "亚瑟"); err == nil {
+		t.Error("must not attack with another player's character")
+	}
+	if _, err := g.AttackerFor(2, "Goblin"); err == nil {
+		t.Error("must not attack with an NPC")
+	}
+	if c, err := g.AttackerFor(2, "老兵"); err != nil || c.OwnerID != 2 {
+		t.Fatalf("legacy character should be claimed: %v %v", c, err)
+	}
+	if c, err := g.AttackerFor(2, ""); err != nil || c.Name != "老兵" {
+		t.Errorf("claimed character should be found by owner: %v %v", c, err)
+	}
+}
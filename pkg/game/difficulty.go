@@ -0,0 +1,228 @@
+package game
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 难度等级
+const (
+	DifficultyTrivial = "轻松(trivial)"
+	DifficultyEasy    = "简单(easy)"
+	DifficultyMedium  = "中等(medium)"
+	DifficultyHard    = "困难(hard)"
+	DifficultyDeadly  = "致命(deadly)"
+)
+
+// xpThresholds DMG 每级角色的遭遇经验阈值: 简单/中等/困难/致命
+var xpThresholds = [21][4]int{
+	{},
+	{25, 50, 75, 100},
+	{50, 100, 150, 200},
+	{75, 150, 225, 400},
+	{125, 250, 375, 500},
+	{250, 500, 750, 1100},
+	{300, 600, 900, 1400},
+	{350, 750, 1100, 1700},
+	{450, 900, 1400, 2100},
+	{550, 1100, 1600, 2400},
+	{600, 1200, 1900, 2800},
+	{800, 1600, 2400, 3600},
+	{1000, 2000, 3000, 4500},
+	{1100, 2200, 3400, 5100},
+	{1250, 2500, 3800, 5700},
+	{1400, 2800, 4300, 6400},
+	{1600, 3200, 4800, 7200},
+	{2000, 3900, 5900, 8800},
+	{2100, 4200, 6300, 9500},
+	{2400, 4900, 7300, 10900},
+	{2800, 5700, 8500, 12700},
+}
+
+// crXP 挑战等级对应的经验值
+var crXP = map[string]int{
+	"0": 10, "1/8": 25, "1/4": 50, "1/2": 100,
+	"1": 200, "2": 450, "3": 700, "4": 1100, "5": 1800,
+	"6": 2300, "7": 2900, "8": 3900, "9": 5000, "10": 5900,
+	"11": 7200, "12": 8400, "13": 10000, "14": 11500, "15": 13000,
+	"16": 15000, "17": 18000, "18": 20000, "19": 22000, "20": 25000,
+}
+
+// encounterMultipliers 怪物数量对应的经验倍率，按数量档位排列
+var encounterMultipliers = []float64{0.5, 1, 1.5, 2, 2.5, 3, 4, 5}
+
+// CRToXP 返回挑战等级的经验值，未知等级返回 false
+func CRToXP(cr string) (int, bool) {
+	xp, ok := crXP[strings.TrimSpace(cr)]
+	return xp, ok
+}
+
+// Thresholds 队伍经验阈值
+type Thresholds struct {
+	Easy, Medium, Hard, Deadly int
+}
+
+// PartyThresholds 累加每名角色的等级阈值
+func PartyThresholds(levels []int) Thresholds {
+	var t Thresholds
+	for _, lvl := range levels {
+		if lvl < 1 {
+			lvl = 1
+		}
+		if lvl > 20 {
+			lvl = 20
+		}
+		row := xpThresholds[lvl]
+		t.Easy += row[0]
+		t.Medium += row[1]
+		t.Hard += row[2]
+		t.Deadly += row[3]
+	}
+	return t
+}
+
+// multiplierIndex 怪物数量对应的倍率档位
+func multiplierIndex(count int) int {
+	switch {
+	case count <= 1:
+		return 1
+	case count == 2:
+		return 2
+	case count <= 6:
+		return 3
+	case count <= 10:
+		return 4
+	case count <= 14:
+		return 5
+	default:
+		return 6
+	}
+}
+
+// EncounterRating 一组怪物对当前队伍的难度评估
+type EncounterRating struct {
+	PartySize  int
+	Thresholds Thresholds
+	Monsters   int
+	Unrated    []string // 没有 CR 的怪物，不计入经验
+	BaseXP     int
+	Multiplier float64
+	AdjustedXP int
+	Difficulty string
+}
+
+// RateEncounter 按 DMG 规则评估遭遇难度
+// 队伍少于 3 人时倍率上调一档，6 人及以上下调一档
+func RateEncounter(levels []int, crs []string) *EncounterRating {
+	r := &EncounterRating{PartySize: len(levels), Thresholds: PartyThresholds(levels)}
+
+	for _, cr := range crs {
+		xp, ok := CRToXP(cr)
+		if !ok {
+			r.Unrated = append(r.Unrated, cr)
+			continue
+		}
+		r.BaseXP += xp
+		r.Monsters++
+	}
+
+	idx := multiplierIndex(r.Monsters)
+	if r.PartySize < 3 {
+		idx++
+	} else if r.PartySize >= 6 {
+		idx--
+	}
+	if r.Monsters == 0 {
+		idx = 1
+	}
+	r.Multiplier = encounterMultipliers[idx]
+	r.AdjustedXP = int(float64(r.BaseXP) * r.Multiplier)
+
+	t := r.Thresholds
+	switch {
+	case r.AdjustedXP >= t.Deadly:
+		r.Difficulty = DifficultyDeadly
+	case r.AdjustedXP >= t.Hard:
+		r.Difficulty = DifficultyHard
+	case r.AdjustedXP >= t.Medium:
+		r.Difficulty = DifficultyMedium
+	case r.AdjustedXP >= t.Easy:
+		r.Difficulty = DifficultyEasy
+	default:
+		r.Difficulty = DifficultyTrivial
+	}
+	return r
+}
+
+// String 评估结果文本
+func (r *EncounterRating) String() string {
+	t := r.Thresholds
+	s := fmt.Sprintf("队伍 %d 人，经验阈值: 简单 %d / 中等 %d / 困难 %d / 致命 %d\n",
+		r.PartySize, t.Easy, t.Medium, t.Hard, t.Deadly)
+	s += fmt.Sprintf("怪物 %d 个，基础 XP %d × %.1f = %d → %s", r.Monsters, r.BaseXP, r.Multiplier, r.AdjustedXP, r.Difficulty)
+	if len(r.Unrated) > 0 {
+		s += fmt.Sprintf("\n(未计入: %s 没有 CR)", strings.Join(r.Unrated, ", "))
+	}
+	return s
+}
+
+// EffectiveLevel 角色等级，未设置时视为 1
+func (c *Character) EffectiveLevel() int {
+	if c.Level < 1 {
+		return 1
+	}
+	return c.Level
+}
+
+// PartyLevels 当前所有玩家角色的等级
+func (g *GroupState) PartyLevels() []int {
+	g.Mutex.RLock()
+	defer g.Mutex.RUnlock()
+
+	var levels []int
+	for _, char := range g.Characters {
+		if !char.IsAI {
+			levels = append(levels, char.EffectiveLevel())
+		}
+	}
+	return levels
+}
+
+// RateCurrentEnemies 评估当前场上所有 NPC 的难度
+func (g *GroupState) RateCurrentEnemies() *EncounterRating {
+	levels := g.PartyLevels()
+
+	g.Mutex.RLock()
+	var crs []string
+	for _, char := range g.Characters {
+		if !char.IsAI {
+			continue
+		}
+		if char.CR == "" {
+			crs = append(crs, char.Name)
+		} else {
+			crs = append(crs, char.CR)
+		}
+	}
+	g.Mutex.RUnlock()
+
+	return RateEncounter(levels, crs)
+}
+
+// GetEncounterBudgetSummary 生成遭遇预算摘要，用于注入 Prompt
+func (g *GroupState) GetEncounterBudgetSummary() string {
+	levels := g.PartyLevels()
+	if len(levels) == 0 {
+		return ""
+	}
+
+	r := g.RateCurrentEnemies()
+	t := r.Thresholds
+	summary := fmt.Sprintf("【遭遇预算】队伍 %d 人，XP 阈值: 简单 %d / 中等 %d / 困难 %d / 致命 %d。",
+		r.PartySize, t.Easy, t.Medium, t.Hard, t.Deadly)
+	if r.Monsters > 0 {
+		summary += fmt.Sprintf("当前敌人调整后 XP %d (%s)。", r.AdjustedXP, r.Difficulty)
+	}
+	summary += "生成怪物时，调整后 XP 不应超过“困难”阈值，除非剧情明确需要 Boss 战。\n"
+	return summary
+}
@@ -0,0 +1,40 @@
+package game
+
+import "testing"
+
+func TestPartyThresholds(t *testing.T) {
+	got := PartyThresholds([]int{1, 1, 1, 1})
+	want := Thresholds{Easy: 100, Medium: 200, Hard: 300, Deadly: 400}
+	if got != want {
+		t.Errorf("PartyThresholds(4x L1) = %+v, want %+v", got, want)
+	}
+}
+
+func TestRateEncounter(t *testing.T) {
+	party := []int{1, 1, 1, 1}
+
+	// 3 只 CR 1/4: 150 XP × 2 = 300 → 困难
+	r := RateEncounter(party, []string{"1/4", "1/4", "1/4"})
+	if r.BaseXP != 150 || r.Multiplier != 2 || r.AdjustedXP != 300 {
+		t.Errorf("unexpected rating %+v", r)
+	}
+	if r.Difficulty != DifficultyHard {
+		t.Errorf("expected hard, got %s", r.Difficulty)
+	}
+
+	// 单只 CR 3 对 4 名 1 级角色是致命的
+	if r := RateEncounter(party, []string{"3"}); r.Difficulty != DifficultyDeadly {
+		t.Errorf("expected deadly, got %s", r.Difficulty)
+	}
+}
+
+func TestRateEncounter_SmallPartyAndUnrated(t *testing.T) {
+	// 两人队伍单个怪物倍率上调为 1.5
+	r := RateEncounter([]int{1, 1}, []string{"1/4", "哥布林"})
+	if r.Multiplier != 1.5 {
+		t.Errorf("expected multiplier 1.5 for small party, got %.1f", r.Multiplier)
+	}
+	if len(r.Unrated) != 1 || r.Monsters != 1 {
+		t.Errorf("expected one unrated monster, got %+v", r)
+	}
+}
@@ -0,0 +1,336 @@
+package game
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"dndbot/pkg/dice"
+)
+
+// InitiativeEntry 先攻列表中的一项
+type InitiativeEntry struct {
+	Name     string `json:"name"`
+	Roll     int    `json:"roll"`
+	Modifier int    `json:"modifier"`
+	Total    int    `json:"total"`
+	IsAI     bool   `json:"is_ai"`
+}
+
+// Encounter 一场战斗的先攻顺序与回合指针
+type Encounter struct {
+	Round  int                `json:"round"`
+	Turn   int                `json:"turn"` // 当前行动者在 Order 中的下标
+	Order  []*InitiativeEntry `json:"order"`
+	Strict bool               `json:"strict"` // 严格回合: 只接受当前行动者的发言，NPC 回合由 AI 自动结算
+}
+
+// DexModifier 敏捷修正，未设置敏捷时视为 10
+func (c *Character) DexModifier() int {
+	if c.DEX == 0 {
+		return 0
+	}
+	return AbilityModifier(c.DEX)
+}
+
+func rollInitiative(char *Character) *InitiativeEntry {
+	res, _ := dice.Roll("1d20")
+	mod := char.DexModifier()
+	return &InitiativeEntry{
+		Name:     char.Name,
+		Roll:     res.Total,
+		Modifier: mod,
+		Total:    res.Total + mod,
+		IsAI:     char.IsAI,
+	}
+}
+
+// sortOrder 按先攻总值降序，同值时敏捷修正高者优先
+func (e *Encounter) sortOrder() {
+	sort.SliceStable(e.Order, func(i, j int) bool {
+		if e.Order[i].Total != e.Order[j].Total {
+			return e.Order[i].Total > e.Order[j].Total
+		}
+		return e.Order[i].Modifier > e.Order[j].Modifier
+	})
+}
+
+// Current 返回当前行动者
+func (e *Encounter) Current() *InitiativeEntry {
+	if e == nil || len(e.Order) == 0 {
+		return nil
+	}
+	return e.Order[e.Turn]
+}
+
+func (e *Encounter) clone() *Encounter {
+	if e == nil {
+		return nil
+	}
+	c := &Encounter{Round: e.Round, Turn: e.Turn, Strict: e.Strict, Order: make([]*InitiativeEntry, len(e.Order))}
+	for i, entry := range e.Order {
+		eVal := *entry
+		c.Order[i] = &eVal
+	}
+	return c
+}
+
+func (e *Encounter) indexOf(name string) int {
+	for i, entry := range e.Order {
+		if strings.EqualFold(entry.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// remove 将角色移出先攻列表，保持回合指针指向同一行动者
+// 移除的是本轮最后一位且正轮到他时，与 advance 一样进入新一轮
+func (e *Encounter) remove(name string) {
+	idx := e.indexOf(name)
+	if idx < 0 {
+		return
+	}
+	e.Order = append(e.Order[:idx], e.Order[idx+1:]...)
+	if idx < e.Turn {
+		e.Turn--
+	}
+	if e.Turn >= len(e.Order) {
+		e.Turn = 0
+		if len(e.Order) > 0 {
+			e.Round++
+		}
+	}
+}
+
+// insert 为中途加入战斗的角色投先攻并插入列表
+func (e *Encounter) insert(char *Character) {
+	e.insertEntry(rollInitiative(char))
+}
+
+// insertEntry 按先攻插入一项并替换同名的旧项，保持回合指针指向同一行动者
+func (e *Encounter) insertEntry(entry *InitiativeEntry) {
+	current := e.Current()
+	if idx := e.indexOf(entry.Name); idx >= 0 {
+		e.Order = append(e.Order[:idx], e.Order[idx+1:]...)
+	}
+	e.Order = append(e.Order, entry)
+	e.sortOrder()
+	if current != nil {
+		if idx := e.indexOf(current.Name); idx >= 0 {
+			e.Turn = idx
+		}
+	}
+}
+
+// advance 回合指针前进一位，越过列表末尾时进入新一轮
+func (e *Encounter) advance() bool {
+	e.Turn++
+	if e.Turn >= len(e.Order) {
+		e.Turn = 0
+		e.Round++
+		return true
+	}
+	return false
+}
+
+// npcRun 从 start 开始连续的 NPC 行动者名单，遇到 PC 即停止
+func (e *Encounter) npcRun(start int) []string {
+	var names []string
+	for i := 0; i < len(e.Order); i++ {
+		entry := e.Order[(start+i)%len(e.Order)]
+		if !entry.IsAI {
+			break
+		}
+		names = append(names, entry.Name)
+	}
+	return names
+}
+
+// String 先攻顺序文本
+func (e *Encounter) String() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("【先攻顺序】第 %d 轮\n", e.Round))
+	for i, entry := range e.Order {
+		marker := "  "
+		if i == e.Turn {
+			marker = "▶ "
+		}
+		roleType := "PC"
+		if entry.IsAI {
+			roleType = "NPC"
+		}
+		sb.WriteString(fmt.Sprintf("%s%d. [%s] %s: %d (%d%+d)\n",
+			marker, i+1, roleType, entry.Name, entry.Total, entry.Roll, entry.Modifier))
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+// StartEncounter 为群内所有角色投先攻并开始战斗
+func (g *GroupState) StartEncounter() *Encounter {
+	g.Mutex.Lock()
+	defer g.Mutex.Unlock()
+	defer g.save()
+
+	enc := &Encounter{Round: 1}
+	for _, char := range g.Characters {
+		enc.Order = append(enc.Order, rollInitiative(char))
+	}
+	enc.sortOrder()
+	g.Encounter = enc
+	return enc.clone()
+}
+
+// EndEncounter 结束当前战斗
+func (g *GroupState) EndEncounter() bool {
+	g.Mutex.Lock()
+	defer g.Mutex.Unlock()
+	defer g.save()
+	active := g.Encounter != nil
+	g.Encounter = nil
+	return active
+}
+
+// GetEncounter 返回当前战斗的副本，无战斗时返回 nil
+func (g *GroupState) GetEncounter() *Encounter {
+	g.Mutex.RLock()
+	defer g.Mutex.RUnlock()
+	return g.Encounter.clone()
+}
+
+// NextTurn 推进到下一位行动者，返回新的当前行动者与是否进入新一轮
+func (g *GroupState) NextTurn() (*InitiativeEntry, bool, error) {
+	g.Mutex.Lock()
+	defer g.Mutex.Unlock()
+	defer g.save()
+
+	enc := g.Encounter
+	if enc == nil || len(enc.Order) == 0 {
+		return nil, false, fmt.Errorf("当前没有进行中的战斗，请先使用 .init")
+	}
+
+	newRound := enc.advance()
+	current := *enc.Current()
+	return &current, newRound, nil
+}
+
+// SetStrictTurns 开关严格回合模式
+func (g *GroupState) SetStrictTurns(strict bool) error {
+	g.Mutex.Lock()
+	defer g.Mutex.Unlock()
+	defer g.save()
+
+	if g.Encounter == nil {
+		return fmt.Errorf("当前没有进行中的战斗，请先使用 .init")
+	}
+	g.Encounter.Strict = strict
+	return nil
+}
+
+// CheckTurn 严格回合模式下判断玩家(QQ)能否发言
+// 返回 false 时附带提示文本。非严格模式、NPC 回合或无主角色时都放行
+func (g *GroupState) CheckTurn(senderID int64) (bool, string) {
+	g.Mutex.RLock()
+	defer g.Mutex.RUnlock()
+
+	enc := g.Encounter
+	if enc == nil || !enc.Strict || len(enc.Order) == 0 {
+		return true, ""
+	}
+	current := enc.Current()
+	if current.IsAI {
+		return true, ""
+	}
+	char := g.Characters[strings.ToLower(current.Name)]
+	if char == nil || char.OwnerID == 0 || char.OwnerID == senderID {
+		return true, ""
+	}
+	return false, fmt.Sprintf("⏳ 现在是 %s 的回合，请等待轮到你的角色再行动。", current.Name)
+}
+
+// AdvanceStrictTurn 在严格回合模式下结算完一次行动后推进回合:
+// 越过当前 PC 以及紧随其后已由 AI 结算的 NPC，停在下一位 PC。
+// 返回新的行动者与被跳过的 NPC 名单；非严格模式返回 nil
+func (g *GroupState) AdvanceStrictTurn() (*InitiativeEntry, []string) {
+	g.Mutex.Lock()
+	defer g.Mutex.Unlock()
+	defer g.save()
+
+	enc := g.Encounter
+	if enc == nil || !enc.Strict || len(enc.Order) == 0 {
+		return nil, nil
+	}
+
+	if !enc.Current().IsAI {
+		enc.advance()
+	}
+	skipped := enc.npcRun(enc.Turn)
+	for range skipped {
+		enc.advance()
+	}
+	current := *enc.Current()
+	return &current, skipped
+}
+
+// TurnMark 战斗中的回合位置，用于撤销回合推进
+type TurnMark struct {
+	Round int
+	Actor string
+}
+
+// MarkTurn 记录当前的回合位置，没有战斗时返回 nil
+func (g *GroupState) MarkTurn() *TurnMark {
+	g.Mutex.RLock()
+	defer g.Mutex.RUnlock()
+
+	current := g.Encounter.Current()
+	if current == nil {
+		return nil
+	}
+	return &TurnMark{Round: g.Encounter.Round, Actor: current.Name}
+}
+
+// RestoreTurn 回到 m 记录的回合位置，战斗已结束或该行动者已不在先攻列表中时不做修改
+func (g *GroupState) RestoreTurn(m *TurnMark) {
+	g.Mutex.Lock()
+	defer g.Mutex.Unlock()
+	defer g.save()
+
+	if g.Encounter == nil || m == nil {
+		return
+	}
+	if idx := g.Encounter.indexOf(m.Actor); idx >= 0 {
+		g.Encounter.Turn = idx
+		g.Encounter.Round = m.Round
+	}
+}
+
+// GetTurnOrderSummary 生成先攻顺序摘要，用于注入 Prompt
+func (g *GroupState) GetTurnOrderSummary() string {
+	g.Mutex.RLock()
+	defer g.Mutex.RUnlock()
+
+	if g.Encounter == nil || len(g.Encounter.Order) == 0 {
+		return ""
+	}
+	enc := g.Encounter
+	current := enc.Current()
+	summary := enc.String() + "\n" +
+		fmt.Sprintf("【战斗进行中】当前轮到 %s 行动。请严格按照先攻顺序叙述，不要让其他角色抢先行动。\n", current.Name)
+
+	if enc.Strict {
+		var npcs []string
+		if current.IsAI {
+			npcs = enc.npcRun(enc.Turn)
+		} else {
+			npcs = enc.npcRun(enc.Turn + 1)
+		}
+		if len(npcs) > 0 {
+			summary += fmt.Sprintf("【严格回合】只结算当前行动者的行动，随后由你按顺序自动结算 NPC %s 的回合(可使用 Action)，然后停下等待下一位玩家。\n",
+				strings.Join(npcs, "、"))
+		} else {
+			summary += "【严格回合】只结算当前行动者的行动，然后停下等待下一位玩家。\n"
+		}
+	}
+	return summary
+}
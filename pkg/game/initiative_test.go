@@ -0,0 +1,124 @@
+package game
+
+import "testing"
+
+func newTestGroup(chars ...*Character) *GroupState {
+	g := &GroupState{Characters: make(map[string]*Character)}
+	for _, c := range chars {
+		g.AddCharacter(c)
+	}
+	return g
+}
+
+func TestStartEncounter_SortedByTotal(t *testing.T) {
+	g := newTestGroup(
+		&Character{Name: "亚瑟", DEX: 14},
+		&Character{Name: "Goblin", DEX: 8, IsAI: true},
+		&Character{Name: "莉娜", DEX: 18},
+	)
+
+	enc := g.StartEncounter()
+	if len(enc.Order) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(enc.Order))
+	}
+	if enc.Round != 1 || enc.Turn != 0 {
+		t.Errorf("expected round 1 turn 0, got round %d turn %d", enc.Round, enc.Turn)
+	}
+	for i := 1; i < len(enc.Order); i++ {
+		if enc.Order[i-1].Total < enc.Order[i].Total {
+			t.Errorf("order not sorted: %v before %v", enc.Order[i-1], enc.Order[i])
+		}
+	}
+	for _, e := range enc.Order {
+		if e.Total != e.Roll+e.Modifier {
+			t.Errorf("%s total %d != roll %d + mod %d", e.Name, e.Total, e.Roll, e.Modifier)
+		}
+	}
+}
+
+func TestNextTurn_WrapsToNewRound(t *testing.T) {
+	g := newTestGroup(&Character{Name: "A"}, &Character{Name: "B"})
+	if _, _, err := g.NextTurn(); err == nil {
+		t.Fatal("expected error without encounter")
+	}
+
+	g.StartEncounter()
+	if _, newRound, _ := g.NextTurn(); newRound {
+		t.Error("second turn should not start a new round")
+	}
+	if _, newRound, _ := g.NextTurn(); !newRound {
+		t.Error("expected wrap into round 2")
+	}
+	if enc := g.GetEncounter(); enc.Round != 2 || enc.Turn != 0 {
+		t.Errorf("expected round 2 turn 0, got round %d turn %d", enc.Round, enc.Turn)
+	}
+}
+
+func TestRemoveCharacter_KeepsTurnPointer(t *testing.T) {
+	g := newTestGroup(&Character{Name: "A"}, &Character{Name: "B"}, &Character{Name: "C"})
+	enc := g.StartEncounter()
+	first := enc.Order[0].Name
+	g.NextTurn()
+	current := g.GetEncounter().Current().Name
+
+	g.RemoveCharacter(first)
+	enc = g.GetEncounter()
+	if len(enc.Order) != 2 {
+		t.Fatalf("expected 2 entries after removal, got %d", len(enc.Order))
+	}
+	if enc.Current().Name != current {
+		t.Errorf("turn moved from %s to %s after removing %s", current, enc.Current().Name, first)
+	}
+}
+
+func TestRemoveCharacter_LastOnTurnStartsNewRound(t *testing.T) {
+	g := newTestGroup(&Character{Name: "A"}, &Character{Name: "B"}, &Character{Name: "C"})
+	enc := g.StartEncounter()
+	g.NextTurn()
+	g.NextTurn()
+	last := enc.Order[2].Name
+
+	g.RemoveCharacter(last)
+	enc = g.GetEncounter()
+	if enc.Turn != 0 || enc.Round != 2 {
+		t.Errorf("expected round 2 turn 0 after removing the acting last entry, got round %d turn %d", enc.Round, enc.Turn)
+	}
+}
+
+func TestStrictTurns(t *testing.T) {
+	g := newTestGroup(
+		&Character{Name: "亚瑟", OwnerID: 1},
+		&Character{Name: "莉娜", OwnerID: 2},
+		&Character{Name: "Goblin", IsAI: true},
+	)
+	g.StartEncounter()
+	// 固定顺序: 亚瑟 -> Goblin -> 莉娜
+	g.Encounter.Order = []*InitiativeEntry{
+		{Name: "亚瑟", Total: 15},
+		{Name: "Goblin", Total: 12, IsAI: true},
+		{Name: "莉娜", Total: 8},
+	}
+	if err := g.SetStrictTurns(true); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, _ := g.CheckTurn(2); ok {
+		t.Error("莉娜's owner should not act on 亚瑟's turn")
+	}
+	if ok, _ := g.CheckTurn(1); !ok {
+		t.Error("亚瑟's owner should be allowed to act")
+	}
+
+	current, skipped := g.AdvanceStrictTurn()
+	if current.Name != "莉娜" {
+		t.Errorf("expected turn to move to 莉娜, got %s", current.Name)
+	}
+	if len(skipped) != 1 || skipped[0] != "Goblin" {
+		t.Errorf("expected Goblin to be auto-resolved, got %v", skipped)
+	}
+
+	current, _ = g.AdvanceStrictTurn()
+	if current.Name != "亚瑟" || g.GetEncounter().Round != 2 {
+		t.Errorf("expected round 2 with 亚瑟, got %s round %d", current.Name, g.GetEncounter().Round)
+	}
+}
@@ -0,0 +1,392 @@
+package game
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"dndbot/pkg/dice"
+)
+
+// 货币单位，均以铜币计
+const (
+	Copper = 1
+	Silver = 100 * Copper
+	Gold   = 100 * Silver
+)
+
+// Item 物品及数量
+type Item struct {
+	Name string `json:"name"`
+	Qty  int    `json:"qty"`
+}
+
+// Stash 队伍共享的战利品储物
+type Stash struct {
+	Coins int    `json:"coins"` // 铜币
+	Items []Item `json:"items,omitempty"`
+}
+
+// CoinRoll 宝藏中的一笔钱币
+type CoinRoll struct {
+	Dice string `json:"dice"` // 例如 2d6
+	Unit string `json:"unit"` // 铜/银/金
+}
+
+// ItemRoll 宝藏中的一次物品抽取
+type ItemRoll struct {
+	Table  string `json:"table"`  // loot.json 中的物品表或 background/*.md 中的随机表
+	Name   string `json:"name"`   // 固定物品，与 Table 二选一
+	Rolls  int    `json:"rolls"`  // 抽取次数，默认 1
+	Chance int    `json:"chance"` // 出现概率(%)，0 表示必定出现
+}
+
+// HoardTier 挑战等级不超过 MaxCR 时使用的宝藏配置
+type HoardTier struct {
+	MaxCR string     `json:"max_cr"`
+	Coins []CoinRoll `json:"coins"`
+	Items []ItemRoll `json:"items"`
+}
+
+// LootConfig 宝藏生成配置，从 background/loot.json 加载
+type LootConfig struct {
+	Hoards     []HoardTier         `json:"hoards"` // 按 MaxCR 升序排列
+	ItemTables map[string][]string `json:"item_tables"`
+}
+
+// Hoard 生成的一份宝藏
+type Hoard struct {
+	Coins int
+	Items []Item
+}
+
+var GlobalLoot = &LootConfig{}
+
+func (s Stash) clone() Stash {
+	s.Items = append([]Item(nil), s.Items...)
+	return s
+}
+
+// coinPattern 匹配 "30银"、"1d6 铜币"、"2金币" 形式的钱币
+var coinPattern = regexp.MustCompile(`^(\d*d\d+(?:[+-]\d+)?|\d+)\s*(铜|银|金)币?$`)
+
+// qtyPattern 匹配 "精灵银币 x2"、"治疗药水×3" 形式的数量后缀
+var qtyPattern = regexp.MustCompile(`^(.+?)\s*[xX×]\s*(\d+)$`)
+
+// LoadLoot 从 JSON 文件加载宝藏配置并设置为全局配置
+func LoadLoot(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var l LootConfig
+	if err := json.Unmarshal(data, &l); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	for _, h := range l.Hoards {
+		if _, ok := ParseCR(h.MaxCR); !ok {
+			return fmt.Errorf("%s: invalid max_cr %q", path, h.MaxCR)
+		}
+		for _, c := range h.Coins {
+			if _, _, _, err := dice.Parse(c.Dice); err != nil {
+				return fmt.Errorf("%s: invalid coin dice %q", path, c.Dice)
+			}
+			if unitValue(c.Unit) == 0 {
+				return fmt.Errorf("%s: unknown coin unit %q", path, c.Unit)
+			}
+		}
+	}
+
+	GlobalLoot = &l
+	return nil
+}
+
+// ParseCR 将 "1/4"、"2" 形式的挑战等级转为数值
+func ParseCR(cr string) (float64, bool) {
+	cr = strings.TrimSpace(cr)
+	if num, den, ok := strings.Cut(cr, "/"); ok {
+		n, err1 := strconv.Atoi(num)
+		d, err2 := strconv.Atoi(den)
+		if err1 != nil || err2 != nil || d == 0 {
+			return 0, false
+		}
+		return float64(n) / float64(d), true
+	}
+	v, err := strconv.ParseFloat(cr, 64)
+	return v, err == nil
+}
+
+func unitValue(unit string) int {
+	switch strings.TrimSuffix(unit, "币") {
+	case "铜":
+		return Copper
+	case "银":
+		return Silver
+	case "金":
+		return Gold
+	}
+	return 0
+}
+
+// ParseCoins 解析 "30银"、"2金" 形式的固定金额，返回铜币数
+func ParseCoins(s string) (int, bool) {
+	m := coinPattern.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(m[1])
+	if err != nil {
+		return 0, false
+	}
+	return n * unitValue(m[2]), true
+}
+
+// FormatCoins 将铜币数格式化为 "1金 20银 5铜"
+func FormatCoins(copper int) string {
+	if copper == 0 {
+		return "0铜"
+	}
+	var parts []string
+	if g := copper / Gold; g > 0 {
+		parts = append(parts, fmt.Sprintf("%d金", g))
+	}
+	if s := copper % Gold / Silver; s > 0 {
+		parts = append(parts, fmt.Sprintf("%d银", s))
+	}
+	if c := copper % Silver; c > 0 {
+		parts = append(parts, fmt.Sprintf("%d铜", c))
+	}
+	return strings.Join(parts, " ")
+}
+
+// addItem 将物品并入列表，同名物品累加数量
+func addItem(items []Item, name string, qty int) []Item {
+	for i := range items {
+		if items[i].Name == name {
+			items[i].Qty += qty
+			return items
+		}
+	}
+	return append(items, Item{Name: name, Qty: qty})
+}
+
+// removeItem 从列表中取出物品，数量不足时返回错误
+func removeItem(items []Item, name string, qty int) ([]Item, string, error) {
+	for i := range items {
+		if !strings.EqualFold(items[i].Name, name) {
+			continue
+		}
+		if items[i].Qty < qty {
+			return items, "", fmt.Errorf("%s 只有 %d 个", items[i].Name, items[i].Qty)
+		}
+		name = items[i].Name
+		items[i].Qty -= qty
+		if items[i].Qty == 0 {
+			items = append(items[:i], items[i+1:]...)
+		}
+		return items, name, nil
+	}
+	return items, "", fmt.Errorf("没有物品: %s", name)
+}
+
+// FormatItems 物品列表文本，例如 "治疗药水 x2, 精灵护符"
+func FormatItems(items []Item) string {
+	parts := make([]string, 0, len(items))
+	for _, it := range items {
+		if it.Qty > 1 {
+			parts = append(parts, fmt.Sprintf("%s x%d", it.Name, it.Qty))
+		} else {
+			parts = append(parts, it.Name)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// tierFor 选择不低于 cr 的最小宝藏档位，超出时使用最高档
+func (l *LootConfig) tierFor(cr float64) *HoardTier {
+	for i := range l.Hoards {
+		if max, _ := ParseCR(l.Hoards[i].MaxCR); cr <= max {
+			return &l.Hoards[i]
+		}
+	}
+	if len(l.Hoards) == 0 {
+		return nil
+	}
+	return &l.Hoards[len(l.Hoards)-1]
+}
+
+// addResult 将随机表结果并入宝藏: 钱币累加，"名称 x2" 拆出数量
+func (h *Hoard) addResult(result string) {
+	result = strings.TrimSpace(result)
+	if m := coinPattern.FindStringSubmatch(result); m != nil {
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			if res, err := dice.Roll(m[1]); err == nil {
+				n = res.Total
+			}
+		}
+		h.Coins += n * unitValue(m[2])
+		return
+	}
+	qty := 1
+	if m := qtyPattern.FindStringSubmatch(result); m != nil {
+		result = m[1]
+		qty, _ = strconv.Atoi(m[2])
+	}
+	h.Items = addItem(h.Items, result, qty)
+}
+
+// Roll 按挑战等级生成一份宝藏
+func (l *LootConfig) Roll(cr string) (*Hoard, error) {
+	value, ok := ParseCR(cr)
+	if !ok {
+		return nil, fmt.Errorf("无效的挑战等级: %s", cr)
+	}
+	tier := l.tierFor(value)
+	if tier == nil {
+		return nil, fmt.Errorf("没有加载宝藏配置")
+	}
+
+	h := &Hoard{}
+	for _, c := range tier.Coins {
+		res, err := dice.Roll(c.Dice)
+		if err != nil {
+			return nil, err
+		}
+		h.Coins += res.Total * unitValue(c.Unit)
+	}
+
+	for _, ir := range tier.Items {
+		if ir.Chance > 0 && rand.Intn(100) >= ir.Chance {
+			continue
+		}
+		rolls := ir.Rolls
+		if rolls < 1 {
+			rolls = 1
+		}
+		for i := 0; i < rolls; i++ {
+			switch {
+			case ir.Name != "":
+				h.addResult(ir.Name)
+			case len(l.ItemTables[ir.Table]) > 0:
+				list := l.ItemTables[ir.Table]
+				h.addResult(list[rand.Intn(len(list))])
+			default:
+				table := GlobalTables.Get(ir.Table)
+				if table == nil {
+					return nil, fmt.Errorf("未知的物品表: %s", ir.Table)
+				}
+				roll, err := table.Roll()
+				if err != nil {
+					return nil, err
+				}
+				h.addResult(roll.Result)
+			}
+		}
+	}
+	return h, nil
+}
+
+// String 宝藏内容文本
+func (h *Hoard) String() string {
+	s := FormatCoins(h.Coins)
+	if len(h.Items) > 0 {
+		s += ", " + FormatItems(h.Items)
+	}
+	return s
+}
+
+// DepositLoot 将宝藏放入队伍储物
+func (g *GroupState) DepositLoot(h *Hoard) {
+	g.Mutex.Lock()
+	defer g.Mutex.Unlock()
+	defer g.save()
+
+	g.Stash.Coins += h.Coins
+	for _, it := range h.Items {
+		g.Stash.Items = addItem(g.Stash.Items, it.Name, it.Qty)
+	}
+}
+
+// ClaimItem 角色从队伍储物中领取物品
+func (g *GroupState) ClaimItem(charName, item string, qty int) (string, error) {
+	g.Mutex.Lock()
+	defer g.Mutex.Unlock()
+	defer g.save()
+
+	char := g.Characters[strings.ToLower(charName)]
+	if char == nil {
+		return "", fmt.Errorf("unknown char '%s'", charName)
+	}
+	items, name, err := removeItem(g.Stash.Items, item, qty)
+	if err != nil {
+		return "", err
+	}
+	g.Stash.Items = items
+	char.Inventory = addItem(char.Inventory, name, qty)
+	return name, nil
+}
+
+// ClaimCoins 角色从队伍储物中领取钱币
+func (g *GroupState) ClaimCoins(charName string, copper int) error {
+	g.Mutex.Lock()
+	defer g.Mutex.Unlock()
+	defer g.save()
+
+	char := g.Characters[strings.ToLower(charName)]
+	if char == nil {
+		return fmt.Errorf("unknown char '%s'", charName)
+	}
+	if copper > g.Stash.Coins {
+		return fmt.Errorf("储物中只有 %s", FormatCoins(g.Stash.Coins))
+	}
+	g.Stash.Coins -= copper
+	char.Purse += copper
+	return nil
+}
+
+// SplitCoins 将储物中的钱币平分给所有玩家角色，余数留在储物中
+func (g *GroupState) SplitCoins() (int, []string, error) {
+	g.Mutex.Lock()
+	defer g.Mutex.Unlock()
+	defer g.save()
+
+	var pcs []*Character
+	for _, name := range sortedKeys(g.Characters) {
+		if char := g.Characters[name]; !char.IsAI {
+			pcs = append(pcs, char)
+		}
+	}
+	if len(pcs) == 0 {
+		return 0, nil, fmt.Errorf("没有玩家角色")
+	}
+
+	share := g.Stash.Coins / len(pcs)
+	names := make([]string, 0, len(pcs))
+	for _, char := range pcs {
+		char.Purse += share
+		names = append(names, char.Name)
+	}
+	g.Stash.Coins -= share * len(pcs)
+	return share, names, nil
+}
+
+// GetStashSummary 队伍储物文本，为空时返回空字符串
+func (g *GroupState) GetStashSummary() string {
+	g.Mutex.RLock()
+	defer g.Mutex.RUnlock()
+
+	if g.Stash.Coins == 0 && len(g.Stash.Items) == 0 {
+		return ""
+	}
+	s := "【队伍储物】钱币: " + FormatCoins(g.Stash.Coins)
+	if len(g.Stash.Items) > 0 {
+		s += "; 物品: " + FormatItems(g.Stash.Items)
+	}
+	return s + "\n"
+}
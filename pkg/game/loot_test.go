@@ -0,0 +1,63 @@
+package game
+
+import "testing"
+
+func TestLoadLoot_ShippedFile(t *testing.T) {
+	if err := LoadTables("../../background"); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadLoot("../../background/loot.json"); err != nil {
+		t.Fatalf("load loot: %v", err)
+	}
+	defer func() { GlobalLoot, GlobalTables = &LootConfig{}, &TableSet{} }()
+
+	for _, cr := range []string{"1/4", "2", "3", "10"} {
+		h, err := GlobalLoot.Roll(cr)
+		if err != nil {
+			t.Fatalf("roll CR %s: %v", cr, err)
+		}
+		if h.Coins <= 0 {
+			t.Errorf("CR %s hoard has no coins", cr)
+		}
+	}
+}
+
+func TestCoins_ParseAndFormat(t *testing.T) {
+	if c, ok := ParseCoins("30银"); !ok || c != 30*Silver {
+		t.Errorf("ParseCoins(30银) = %d, %v", c, ok)
+	}
+	if _, ok := ParseCoins("精灵银币"); ok {
+		t.Error("item name should not parse as coins")
+	}
+	if s := FormatCoins(Gold + 20*Silver + 5); s != "1金 20银 5铜" {
+		t.Errorf("FormatCoins = %q", s)
+	}
+}
+
+func TestStash_ClaimAndSplit(t *testing.T) {
+	g := newTestGroup(&Character{Name: "A"}, &Character{Name: "B"}, &Character{Name: "Goblin", IsAI: true})
+
+	h := &Hoard{}
+	h.addResult("5 银币")
+	h.addResult("治疗药水 x2")
+	h.addResult("治疗药水")
+	g.DepositLoot(h)
+
+	if _, err := g.ClaimItem("A", "治疗药水", 2); err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	if _, err := g.ClaimItem("B", "治疗药水", 2); err == nil {
+		t.Error("claiming more than stash holds should fail")
+	}
+	if inv := g.GetCharacter("A").Inventory; len(inv) != 1 || inv[0].Qty != 2 {
+		t.Errorf("unexpected inventory %+v", inv)
+	}
+
+	share, names, err := g.SplitCoins()
+	if err != nil || share != 250 || len(names) != 2 {
+		t.Errorf("split: share=%d names=%v err=%v", share, names, err)
+	}
+	if g.GetCharacter("B").Purse != 250 {
+		t.Errorf("B purse = %d", g.GetCharacter("B").Purse)
+	}
+}
@@ -0,0 +1,171 @@
+package game
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// NPCRecord 具名 NPC 的长期记忆: 描述、对各 PC 的态度、已知事实与生死
+type NPCRecord struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Location    string            `json:"location,omitempty"`
+	Attitudes   map[string]string `json:"attitudes,omitempty"` // Key: PC 名称
+	Facts       []string          `json:"facts,omitempty"`
+	Alive       bool              `json:"alive"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+}
+
+// NPCUpdate AI Action 对 NPC 的一次新增/更新，空字段表示不修改
+type NPCUpdate struct {
+	Name        string
+	Description string
+	Location    string
+	Attitudes   map[string]string
+	Fact        string
+	Alive       *bool
+}
+
+// maxRelevantNPCs 每轮最多注入 Prompt 的 NPC 数量
+const maxRelevantNPCs = 5
+
+func (n *NPCRecord) clone() *NPCRecord {
+	c := *n
+	if n.Attitudes != nil {
+		c.Attitudes = make(map[string]string, len(n.Attitudes))
+		for k, v := range n.Attitudes {
+			c.Attitudes[k] = v
+		}
+	}
+	c.Facts = append([]string(nil), n.Facts...)
+	return &c
+}
+
+func cloneNPCs(npcs map[string]*NPCRecord) map[string]*NPCRecord {
+	if npcs == nil {
+		return nil
+	}
+	c := make(map[string]*NPCRecord, len(npcs))
+	for k, v := range npcs {
+		c[k] = v.clone()
+	}
+	return c
+}
+
+// String NPC 档案文本
+func (n *NPCRecord) String() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("- %s", n.Name))
+	if !n.Alive {
+		sb.WriteString(" [已死亡]")
+	}
+	if n.Location != "" {
+		sb.WriteString(fmt.Sprintf(" @%s", n.Location))
+	}
+	if n.Description != "" {
+		sb.WriteString(": " + n.Description)
+	}
+	if len(n.Attitudes) > 0 {
+		pcs := make([]string, 0, len(n.Attitudes))
+		for pc := range n.Attitudes {
+			pcs = append(pcs, pc)
+		}
+		sort.Strings(pcs)
+		parts := make([]string, 0, len(pcs))
+		for _, pc := range pcs {
+			parts = append(parts, fmt.Sprintf("%s:%s", pc, n.Attitudes[pc]))
+		}
+		sb.WriteString(fmt.Sprintf("\n  态度: %s", strings.Join(parts, ", ")))
+	}
+	if len(n.Facts) > 0 {
+		sb.WriteString(fmt.Sprintf("\n  已知: %s", strings.Join(n.Facts, "; ")))
+	}
+	return sb.String()
+}
+
+// UpsertNPC 新增或更新 NPC 档案，返回更新后的副本与是否为新建
+func (g *GroupState) UpsertNPC(u NPCUpdate) (*NPCRecord, bool) {
+	g.Mutex.Lock()
+	defer g.Mutex.Unlock()
+	defer g.save()
+
+	if g.NPCs == nil {
+		g.NPCs = make(map[string]*NPCRecord)
+	}
+
+	key := strings.ToLower(u.Name)
+	npc, exists := g.NPCs[key]
+	if !exists {
+		npc = &NPCRecord{Name: u.Name, Alive: true}
+		g.NPCs[key] = npc
+	}
+
+	if u.Description != "" {
+		npc.Description = u.Description
+	}
+	if u.Location != "" {
+		npc.Location = u.Location
+	}
+	for pc, attitude := range u.Attitudes {
+		if npc.Attitudes == nil {
+			npc.Attitudes = make(map[string]string)
+		}
+		npc.Attitudes[pc] = attitude
+	}
+	if u.Fact != "" {
+		npc.Facts = append(npc.Facts, u.Fact)
+	}
+	if u.Alive != nil {
+		npc.Alive = *u.Alive
+	}
+	npc.UpdatedAt = time.Now()
+	return npc.clone(), !exists
+}
+
+// GetNPC 获取 NPC 档案副本
+func (g *GroupState) GetNPC(name string) *NPCRecord {
+	g.Mutex.RLock()
+	defer g.Mutex.RUnlock()
+	if npc := g.NPCs[strings.ToLower(name)]; npc != nil {
+		return npc.clone()
+	}
+	return nil
+}
+
+// ListNPCs 按最近更新时间列出所有 NPC
+func (g *GroupState) ListNPCs() []*NPCRecord {
+	g.Mutex.RLock()
+	defer g.Mutex.RUnlock()
+
+	list := make([]*NPCRecord, 0, len(g.NPCs))
+	for _, npc := range g.NPCs {
+		list = append(list, npc.clone())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].UpdatedAt.After(list[j].UpdatedAt) })
+	return list
+}
+
+// GetRelevantNPCSummary 只挑出在当前场景文本中被提及的 NPC，用于注入 Prompt
+func (g *GroupState) GetRelevantNPCSummary(scene string) string {
+	var relevant []*NPCRecord
+	for _, npc := range g.ListNPCs() {
+		if strings.Contains(scene, npc.Name) {
+			relevant = append(relevant, npc)
+		}
+		if len(relevant) >= maxRelevantNPCs {
+			break
+		}
+	}
+	if len(relevant) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteString("【相关 NPC 档案(保持其性格、态度与已知信息一致)】:\n")
+	for _, npc := range relevant {
+		sb.WriteString(npc.String() + "\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,44 @@
+package game
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUpsertNPC_MergesUpdates(t *testing.T) {
+	g := newTestGroup()
+
+	npc, created := g.UpsertNPC(NPCUpdate{Name: "老汤姆", Description: "酒馆老板"})
+	if !created || !npc.Alive {
+		t.Fatalf("expected new living NPC, got %+v created=%v", npc, created)
+	}
+
+	dead := false
+	npc, created = g.UpsertNPC(NPCUpdate{Name: "老汤姆", Attitudes: map[string]string{"Aragorn": "感激"}, Fact: "曾是王家卫兵", Alive: &dead})
+	if created {
+		t.Error("second upsert should update, not create")
+	}
+	if npc.Description != "酒馆老板" || npc.Attitudes["Aragorn"] != "感激" || len(npc.Facts) != 1 || npc.Alive {
+		t.Errorf("update not merged: %+v", npc)
+	}
+
+	// 返回副本，修改不影响内部状态
+	npc.Facts[0] = "changed"
+	if g.GetNPC("老汤姆").Facts[0] != "曾是王家卫兵" {
+		t.Error("GetNPC should not share slices with caller")
+	}
+}
+
+func TestGetRelevantNPCSummary_OnlyMentioned(t *testing.T) {
+	g := newTestGroup()
+	g.UpsertNPC(NPCUpdate{Name: "老汤姆", Description: "酒馆老板"})
+	g.UpsertNPC(NPCUpdate{Name: "艾琳", Description: "精灵游侠"})
+
+	summary := g.GetRelevantNPCSummary("你们推开酒馆的门，老汤姆抬起头。")
+	if !strings.Contains(summary, "老汤姆") || strings.Contains(summary, "艾琳") {
+		t.Errorf("expected only 老汤姆 in summary, got %q", summary)
+	}
+	if g.GetRelevantNPCSummary("荒野中空无一人") != "" {
+		t.Error("expected empty summary when no NPC is mentioned")
+	}
+}
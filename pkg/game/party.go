@@ -0,0 +1,177 @@
+package game
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Party 属于整个队伍而非某个角色的东西: 公用物资、行进队列、守夜轮班
+type Party struct {
+	Supplies      []Item     `json:"supplies,omitempty"`       // 口粮、火把、马车等公用物资
+	MarchingOrder []string   `json:"marching_order,omitempty"` // 从前到后
+	Watches       [][]string `json:"watches,omitempty"`        // 每一班守夜的成员
+}
+
+func (p Party) clone() Party {
+	p.Supplies = append([]Item(nil), p.Supplies...)
+	p.MarchingOrder = append([]string(nil), p.MarchingOrder...)
+	watches := make([][]string, len(p.Watches))
+	for i, w := range p.Watches {
+		watches[i] = append([]string(nil), w...)
+	}
+	if p.Watches == nil {
+		watches = nil
+	}
+	p.Watches = watches
+	return p
+}
+
+// removeMember 角色离开后从队列与守夜表中移除，空班次一并删除
+func (p *Party) removeMember(name string) {
+	var order []string
+	for _, n := range p.MarchingOrder {
+		if !strings.EqualFold(n, name) {
+			order = append(order, n)
+		}
+	}
+	p.MarchingOrder = order
+
+	var watches [][]string
+	for _, shift := range p.Watches {
+		var kept []string
+		for _, n := range shift {
+			if !strings.EqualFold(n, name) {
+				kept = append(kept, n)
+			}
+		}
+		if len(kept) > 0 {
+			watches = append(watches, kept)
+		}
+	}
+	p.Watches = watches
+}
+
+// canonicalNames 将名字转换为已有角色的标准名称，调用方需持有锁
+func (g *GroupState) canonicalNames(names []string) ([]string, error) {
+	result := make([]string, 0, len(names))
+	seen := make(map[string]bool)
+	for _, n := range names {
+		char := g.Characters[strings.ToLower(n)]
+		if char == nil {
+			return nil, fmt.Errorf("找不到角色: %s", n)
+		}
+		if seen[char.Name] {
+			return nil, fmt.Errorf("%s 重复出现", char.Name)
+		}
+		seen[char.Name] = true
+		result = append(result, char.Name)
+	}
+	return result, nil
+}
+
+// SetMarchingOrder 设置行进队列，空列表表示清除
+func (g *GroupState) SetMarchingOrder(names []string) error {
+	g.Mutex.Lock()
+	defer g.Mutex.Unlock()
+	defer g.save()
+
+	order, err := g.canonicalNames(names)
+	if err != nil {
+		return err
+	}
+	g.Party.MarchingOrder = order
+	return nil
+}
+
+// SetWatches 设置守夜轮班，每个班次可以有多名角色，同一角色只能守一班
+func (g *GroupState) SetWatches(shifts [][]string) error {
+	g.Mutex.Lock()
+	defer g.Mutex.Unlock()
+	defer g.save()
+
+	var all []string
+	for _, shift := range shifts {
+		all = append(all, shift...)
+	}
+	if _, err := g.canonicalNames(all); err != nil {
+		return err
+	}
+
+	watches := make([][]string, 0, len(shifts))
+	for _, shift := range shifts {
+		names, _ := g.canonicalNames(shift)
+		watches = append(watches, names)
+	}
+	if len(watches) == 0 {
+		watches = nil
+	}
+	g.Party.Watches = watches
+	return nil
+}
+
+// AdjustSupply 增减公用物资，返回调整后的数量
+func (g *GroupState) AdjustSupply(name string, delta int) (int, error) {
+	g.Mutex.Lock()
+	defer g.Mutex.Unlock()
+	defer g.save()
+
+	if delta >= 0 {
+		g.Party.Supplies = addItem(g.Party.Supplies, name, delta)
+	} else {
+		items, canonical, err := removeItem(g.Party.Supplies, name, -delta)
+		if err != nil {
+			return 0, err
+		}
+		g.Party.Supplies, name = items, canonical
+	}
+	for _, it := range g.Party.Supplies {
+		if it.Name == name {
+			return it.Qty, nil
+		}
+	}
+	return 0, nil
+}
+
+// GetParty 队伍信息副本
+func (g *GroupState) GetParty() Party {
+	g.Mutex.RLock()
+	defer g.Mutex.RUnlock()
+	return g.Party.clone()
+}
+
+// formatWatches 守夜表文本，例如 "第1班 亚瑟, 第2班 派蒙+梅林"
+func formatWatches(watches [][]string, shiftMinutes int) string {
+	parts := make([]string, 0, len(watches))
+	for i, shift := range watches {
+		part := fmt.Sprintf("第%d班 %s", i+1, strings.Join(shift, "+"))
+		if shiftMinutes > 0 {
+			part += fmt.Sprintf(" (%s)", FormatMinutes(shiftMinutes))
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// String 队伍信息文本，restMinutes 为长休时长，用于计算每班时长
+func (p Party) String(restMinutes int) string {
+	var lines []string
+	if len(p.MarchingOrder) > 0 {
+		lines = append(lines, "行进队列: "+strings.Join(p.MarchingOrder, " → "))
+	}
+	if len(p.Watches) > 0 {
+		lines = append(lines, "守夜: "+formatWatches(p.Watches, restMinutes/len(p.Watches)))
+	}
+	if len(p.Supplies) > 0 {
+		lines = append(lines, "公用物资: "+FormatItems(p.Supplies))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// GetPartySummary 队伍信息，用于注入 Prompt
+func (g *GroupState) GetPartySummary(c *Calendar) string {
+	block := g.GetParty().String(c.LongRestHours * 60)
+	if block == "" {
+		return ""
+	}
+	return "【队伍】(行进时队首最先遭遇危险，夜间事件由当班守夜者进行察觉检定，赶路与扎营时用 party_supply 消耗物资)\n" + block + "\n"
+}
@@ -0,0 +1,46 @@
+package game
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParty_MarchWatchAndSupplies(t *testing.T) {
+	g := newTestGroup(&Character{Name: "Arthur"}, &Character{Name: "Paimon"}, &Character{Name: "Merlin"})
+
+	if err := g.SetMarchingOrder([]string{"arthur", "Merlin", "Paimon"}); err != nil {
+		t.Fatalf("march: %v", err)
+	}
+	if err := g.SetMarchingOrder([]string{"Arthur", "Nobody"}); err == nil {
+		t.Error("unknown character should be rejected")
+	}
+	if err := g.SetWatches([][]string{{"Arthur"}, {"Paimon", "arthur"}}); err == nil {
+		t.Error("a character standing two watches should be rejected")
+	}
+	if err := g.SetWatches([][]string{{"Arthur"}, {"Paimon", "Merlin"}}); err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+
+	if _, err := g.AdjustSupply("口粮", 8); err != nil {
+		t.Fatal(err)
+	}
+	if left, err := g.AdjustSupply("口粮", -3); err != nil || left != 5 {
+		t.Errorf("use rations: left=%d err=%v", left, err)
+	}
+	if _, err := g.AdjustSupply("口粮", -6); err == nil {
+		t.Error("using more supplies than held should fail")
+	}
+
+	s := g.GetParty().String(8 * 60)
+	for _, want := range []string{"Arthur → Merlin → Paimon", "第2班 Paimon+Merlin (4小时)", "口粮 x5"} {
+		if !strings.Contains(s, want) {
+			t.Errorf("party block missing %q:\n%s", want, s)
+		}
+	}
+
+	g.RemoveCharacter("Arthur")
+	p := g.GetParty()
+	if len(p.MarchingOrder) != 2 || len(p.Watches) != 1 {
+		t.Errorf("removed character still listed: %+v", p)
+	}
+}
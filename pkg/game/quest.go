@@ -0,0 +1,161 @@
+package game
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 任务状态
+const (
+	QuestActive    = "进行中"
+	QuestCompleted = "已完成"
+	QuestFailed    = "已失败"
+)
+
+// Objective 任务目标
+type Objective struct {
+	Text string `json:"text"`
+	Done bool   `json:"done"`
+}
+
+// Quest 任务日志中的一条任务
+type Quest struct {
+	Title      string      `json:"title"`
+	Status     string      `json:"status"`
+	Objectives []Objective `json:"objectives,omitempty"`
+	Rewards    string      `json:"rewards,omitempty"`
+}
+
+// QuestUpdate AI Action 对任务的一次新增/更新，空字段表示不修改
+type QuestUpdate struct {
+	Title      string
+	Status     string   // active/completed/failed 或对应中文
+	Objectives []string // 追加的新目标
+	Done       []string // 标记完成的目标，按文本模糊匹配
+	Rewards    string
+}
+
+// ParseQuestStatus 将 AI 给出的状态统一为中文常量，无法识别返回空字符串
+func ParseQuestStatus(s string) string {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "active", "进行中":
+		return QuestActive
+	case "completed", "complete", "done", "已完成", "完成":
+		return QuestCompleted
+	case "failed", "fail", "已失败", "失败":
+		return QuestFailed
+	}
+	return ""
+}
+
+func (q *Quest) clone() *Quest {
+	c := *q
+	c.Objectives = append([]Objective(nil), q.Objectives...)
+	return &c
+}
+
+func cloneQuests(quests []*Quest) []*Quest {
+	if quests == nil {
+		return nil
+	}
+	c := make([]*Quest, len(quests))
+	for i, q := range quests {
+		c[i] = q.clone()
+	}
+	return c
+}
+
+// String 任务文本，目标以复选框展示
+func (q *Quest) String() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("📜 %s [%s]", q.Title, q.Status))
+	for _, o := range q.Objectives {
+		box := "☐"
+		if o.Done {
+			box = "☑"
+		}
+		sb.WriteString(fmt.Sprintf("\n  %s %s", box, o.Text))
+	}
+	if q.Rewards != "" {
+		sb.WriteString("\n  奖励: " + q.Rewards)
+	}
+	return sb.String()
+}
+
+// findQuest 按标题查找任务，调用方需持有锁
+func (g *GroupState) findQuest(title string) *Quest {
+	for _, q := range g.Quests {
+		if strings.EqualFold(q.Title, title) {
+			return q
+		}
+	}
+	return nil
+}
+
+// UpsertQuest 新增或更新任务，返回更新后的副本与是否为新建
+func (g *GroupState) UpsertQuest(u QuestUpdate) (*Quest, bool) {
+	g.Mutex.Lock()
+	defer g.Mutex.Unlock()
+	defer g.save()
+
+	q := g.findQuest(u.Title)
+	created := q == nil
+	if created {
+		q = &Quest{Title: u.Title, Status: QuestActive}
+		g.Quests = append(g.Quests, q)
+	}
+
+	for _, text := range u.Objectives {
+		if text = strings.TrimSpace(text); text != "" {
+			q.Objectives = append(q.Objectives, Objective{Text: text})
+		}
+	}
+	for _, text := range u.Done {
+		if text = strings.TrimSpace(text); text == "" {
+			continue
+		}
+		for i := range q.Objectives {
+			if !q.Objectives[i].Done && strings.Contains(q.Objectives[i].Text, text) {
+				q.Objectives[i].Done = true
+				break
+			}
+		}
+	}
+	if status := ParseQuestStatus(u.Status); status != "" {
+		q.Status = status
+	}
+	if u.Rewards != "" {
+		q.Rewards = u.Rewards
+	}
+	return q.clone(), created
+}
+
+// ListQuests 列出任务，activeOnly 为 true 时只返回进行中的任务
+func (g *GroupState) ListQuests(activeOnly bool) []*Quest {
+	g.Mutex.RLock()
+	defer g.Mutex.RUnlock()
+
+	var list []*Quest
+	for _, q := range g.Quests {
+		if activeOnly && q.Status != QuestActive {
+			continue
+		}
+		list = append(list, q.clone())
+	}
+	return list
+}
+
+// GetActiveQuestSummary 生成进行中任务摘要，用于注入 Prompt
+func (g *GroupState) GetActiveQuestSummary() string {
+	quests := g.ListQuests(true)
+	if len(quests) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteString("【进行中的任务(剧情主线，不要遗忘)】:\n")
+	for _, q := range quests {
+		sb.WriteString(q.String() + "\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,50 @@
+package game
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUpsertQuest_ObjectivesAndStatus(t *testing.T) {
+	g := newTestGroup()
+
+	q, created := g.UpsertQuest(QuestUpdate{Title: "失踪的商队", Objectives: []string{"找到商队营地", "查明袭击者"}, Rewards: "50 金币"})
+	if !created || q.Status != QuestActive || len(q.Objectives) != 2 {
+		t.Fatalf("unexpected new quest: %+v", q)
+	}
+
+	q, created = g.UpsertQuest(QuestUpdate{Title: "失踪的商队", Done: []string{"营地"}})
+	if created || !q.Objectives[0].Done || q.Objectives[1].Done {
+		t.Errorf("expected only first objective done: %+v", q.Objectives)
+	}
+	if !strings.Contains(q.String(), "☑ 找到商队营地") {
+		t.Errorf("expected checked box in %q", q.String())
+	}
+
+	g.UpsertQuest(QuestUpdate{Title: "失踪的商队", Status: "completed"})
+	if len(g.ListQuests(true)) != 0 || g.GetActiveQuestSummary() != "" {
+		t.Error("completed quest should not be listed as active")
+	}
+	if all := g.ListQuests(false); len(all) != 1 || all[0].Status != QuestCompleted {
+		t.Errorf("expected completed quest in full log, got %+v", all)
+	}
+}
+
+func TestUpsertQuest_BlankDoneIgnored(t *testing.T) {
+	g := newTestGroup()
+	g.UpsertQuest(QuestUpdate{Title: "失踪的商队", Objectives: []string{"找到商队营地"}})
+
+	q, _ := g.UpsertQuest(QuestUpdate{Title: "失踪的商队", Done: []string{"", "  "}})
+	if q.Objectives[0].Done {
+		t.Error("a blank done entry must not complete an objective")
+	}
+}
+
+func TestParseQuestStatus(t *testing.T) {
+	cases := map[string]string{"active": QuestActive, "Failed": QuestFailed, "完成": QuestCompleted, "weird": ""}
+	for in, want := range cases {
+		if got := ParseQuestStatus(in); got != want {
+			t.Errorf("ParseQuestStatus(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package game
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ClassAbility 职业能力
+type ClassAbility struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Uses        int    `json:"uses"`     // 每次恢复前可用次数，0 表示不限
+	Recharge    string `json:"recharge"` // 恢复时机: "长休息", "短休息", "每场战斗"...
+}
+
+// ClassDef 职业定义
+type ClassDef struct {
+	Name           string          `json:"name"`
+	Role           string          `json:"role"`
+	HitPoints      int             `json:"hit_points"` // 基础生命值，实际生命 = 基础 + 体质修正
+	HitDie         int             `json:"hit_die"`    // 生命骰面数，每升一级增加其平均值；为 0 时按基础生命的一半估算
+	PrimaryAbility string          `json:"primary_ability"`
+	SavingThrows   []string        `json:"saving_throws"`
+	Resources      map[string]int  `json:"resources"` // 起始资源: 魔力、每日次数等
+	Abilities      []*ClassAbility `json:"abilities"`
+	Equipment      []string        `json:"equipment"`
+}
+
+// RaceDef 种族定义
+type RaceDef struct {
+	Name         string         `json:"name"`
+	Description  string         `json:"description"`
+	AbilityBonus map[string]int `json:"ability_bonus"` // 属性加值，例如 {"STR": 2}
+}
+
+// WeaponDef 武器定义
+type WeaponDef struct {
+	Name    string `json:"name"`
+	Damage  string `json:"damage"`  // 伤害骰，例如 1d8
+	Ability string `json:"ability"` // 攻击与伤害使用的属性: STR 或 DEX
+	Ranged  bool   `json:"ranged"`
+	Notes   string `json:"notes"`
+}
+
+// ArmorDef 防具定义
+type ArmorDef struct {
+	Name    string `json:"name"`
+	ACBonus int    `json:"ac_bonus"` // AC = 10 + 敏捷修正 + 防具加值
+	Notes   string `json:"notes"`
+}
+
+// Ruleset 规则注册表，从 background/ 下的数据文件加载
+type Ruleset struct {
+	MaxAbilityScore int          `json:"max_ability_score"`
+	Proficiency     int          `json:"proficiency"` // 攻击熟练加值
+	Classes         []*ClassDef  `json:"classes"`
+	Races           []*RaceDef   `json:"races"`
+	Weapons         []*WeaponDef `json:"weapons"`
+	Armor           []*ArmorDef  `json:"armor"`
+	StartingCoins   string       `json:"starting_coins"` // 新角色的起始资金，例如 "50银"
+}
+
+var GlobalRules = &Ruleset{Proficiency: 2}
+
+// LoadRules 从 JSON 文件加载规则并设置为全局规则
+func LoadRules(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var rules Ruleset
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	if rules.MaxAbilityScore == 0 {
+		rules.MaxAbilityScore = 20
+	}
+	if rules.Proficiency == 0 {
+		rules.Proficiency = 2
+	}
+
+	GlobalRules = &rules
+	return nil
+}
+
+// AbilityModifier 计算属性修正值: (属性值-10)/2 向下取整
+func AbilityModifier(score int) int {
+	if score >= 10 {
+		return (score - 10) / 2
+	}
+	return (score - 11) / 2
+}
+
+// GetClass 按名称查找职业
+func (r *Ruleset) GetClass(name string) *ClassDef {
+	for _, c := range r.Classes {
+		if strings.EqualFold(c.Name, name) {
+			return c
+		}
+	}
+	return nil
+}
+
+// GetRace 按名称查找种族
+func (r *Ruleset) GetRace(name string) *RaceDef {
+	for _, race := range r.Races {
+		if strings.EqualFold(race.Name, name) {
+			return race
+		}
+	}
+	return nil
+}
+
+// GetWeapon 按名称查找武器
+func (r *Ruleset) GetWeapon(name string) *WeaponDef {
+	for _, w := range r.Weapons {
+		if strings.EqualFold(w.Name, name) {
+			return w
+		}
+	}
+	return nil
+}
+
+// GetArmor 按名称查找防具
+func (r *Ruleset) GetArmor(name string) *ArmorDef {
+	for _, a := range r.Armor {
+		if strings.EqualFold(a.Name, name) {
+			return a
+		}
+	}
+	return nil
+}
+
+// ClassNames 返回所有职业名称
+func (r *Ruleset) ClassNames() []string {
+	names := make([]string, 0, len(r.Classes))
+	for _, c := range r.Classes {
+		names = append(names, c.Name)
+	}
+	return names
+}
+
+// ValidateCharacter 校验玩家角色是否符合职业/种族规则
+// 未加载任何职业时不做校验，保持自由建卡
+func (r *Ruleset) ValidateCharacter(char *Character) error {
+	if len(r.Classes) == 0 {
+		return nil
+	}
+
+	class := r.GetClass(char.Class)
+	if class == nil {
+		return fmt.Errorf("未知职业: %s (可选: %s)", char.Class, strings.Join(r.ClassNames(), "/"))
+	}
+
+	bonus := map[string]int{}
+	if char.Race != "" {
+		if len(r.Races) == 0 {
+			return fmt.Errorf("规则中没有定义种族，不能指定 race=")
+		}
+		race := r.GetRace(char.Race)
+		if race == nil {
+			names := make([]string, 0, len(r.Races))
+			for _, rc := range r.Races {
+				names = append(names, rc.Name)
+			}
+			return fmt.Errorf("未知种族: %s (可选: %s)", char.Race, strings.Join(names, "/"))
+		}
+		bonus = race.AbilityBonus
+	}
+
+	// 校验的是加上种族加值 (由 ApplyClassDefaults 加上) 之后的属性值
+	checkAbility := func(label, key string, score int) error {
+		if final := score + bonus[key]; score < 1 || final < 1 || final > r.MaxAbilityScore {
+			if bonus[key] != 0 {
+				return fmt.Errorf("%s必须在 1-%d 之间 (已计入种族加值 %+d)", label, r.MaxAbilityScore, bonus[key])
+			}
+			return fmt.Errorf("%s必须在 1-%d 之间", label, r.MaxAbilityScore)
+		}
+		return nil
+	}
+	if err := checkAbility("力量", "STR", char.STR); err != nil {
+		return err
+	}
+	if err := checkAbility("敏捷", "DEX", char.DEX); err != nil {
+		return err
+	}
+	if char.CHA != 0 {
+		if err := checkAbility("魅力", "CHA", char.CHA); err != nil {
+			return err
+		}
+	}
+
+	maxHP := class.MaxHP(char.EffectiveLevel(), AbilityModifier(r.MaxAbilityScore))
+	if char.MaxHP < 1 || char.MaxHP > maxHP {
+		return fmt.Errorf("%d 级%s 的生命值必须在 1-%d 之间 (基础 %d + 每级生命骰平均值 + 体质修正)",
+			char.EffectiveLevel(), class.Name, maxHP, class.HitPoints)
+	}
+	return nil
+}
+
+// MaxHP 指定等级下的生命上限: 1 级为基础生命，之后每级增加生命骰平均值，每级都加上体质修正
+func (c *ClassDef) MaxHP(level, conMod int) int {
+	perLevel := c.HitPoints/2 + 1
+	if c.HitDie > 0 {
+		perLevel = c.HitDie/2 + 1
+	}
+	return c.HitPoints + conMod + (level-1)*(perLevel+conMod)
+}
+
+// ApplyClassDefaults 为新角色加上种族属性加值，填充起始资金、职业起始资源、武器、AC 与标准名称
+func (r *Ruleset) ApplyClassDefaults(char *Character) {
+	if coins, ok := ParseCoins(r.StartingCoins); ok && char.Purse == 0 {
+		char.Purse = coins
+	}
+	if race := r.GetRace(char.Race); race != nil {
+		char.Race = race.Name
+		char.STR += race.AbilityBonus["STR"]
+		char.DEX += race.AbilityBonus["DEX"]
+		if char.CHA != 0 {
+			char.CHA += race.AbilityBonus["CHA"]
+		}
+	}
+
+	class := r.GetClass(char.Class)
+	if class == nil {
+		return
+	}
+	char.Class = class.Name
+	if len(class.Resources) > 0 {
+		char.Resources = make(map[string]int, len(class.Resources))
+		for k, v := range class.Resources {
+			char.Resources[k] = v
+		}
+	}
+
+	acBonus := 0
+	for _, item := range class.Equipment {
+		if w := r.GetWeapon(item); w != nil && char.Weapon == "" {
+			char.Weapon = w.Name
+		}
+		if a := r.GetArmor(item); a != nil {
+			acBonus += a.ACBonus
+		}
+	}
+	if char.AC == 0 {
+		char.AC = 10 + char.DexModifier() + acBonus
+	}
+}
+
+// FeatureSummary 生成职业特性说明，用于注入 Prompt
+func (c *ClassDef) FeatureSummary() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("- %s", c.Name))
+	if c.Role != "" {
+		sb.WriteString(fmt.Sprintf(" (%s)", c.Role))
+	}
+	sb.WriteString(fmt.Sprintf(": 基础生命 %d", c.HitPoints))
+	if len(c.SavingThrows) > 0 {
+		sb.WriteString(fmt.Sprintf(", 豁免熟练 %s", strings.Join(c.SavingThrows, "/")))
+	}
+	sb.WriteString("\n")
+	for _, a := range c.Abilities {
+		limit := ""
+		if a.Uses > 0 {
+			limit = fmt.Sprintf(" [%d次/%s]", a.Uses, a.Recharge)
+		}
+		sb.WriteString(fmt.Sprintf("  * %s%s: %s\n", a.Name, limit, a.Description))
+	}
+	return sb.String()
+}
@@ -0,0 +1,119 @@
+package game
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAbilityModifier(t *testing.T) {
+	cases := map[int]int{1: -5, 8: -1, 9: -1, 10: 0, 11: 0, 12: 1, 18: 4, 20: 5}
+	for score, want := range cases {
+		if got := AbilityModifier(score); got != want {
+			t.Errorf("AbilityModifier(%d) = %d, want %d", score, got, want)
+		}
+	}
+}
+
+func TestLoadRules_ShippedFile(t *testing.T) {
+	if err := LoadRules("../../background/rules.json"); err != nil {
+		t.Fatalf("load rules: %v", err)
+	}
+	defer func() { GlobalRules = &Ruleset{} }()
+
+	for _, name := range []string{"守卫者", "追踪者", "启迪者"} {
+		if GlobalRules.GetClass(name) == nil {
+			t.Errorf("class %s missing from rules.json", name)
+		}
+	}
+	if len(GlobalRules.Races) == 0 {
+		t.Error("rules.json should define races")
+	}
+}
+
+func TestValidateCharacter(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "rules.json")
+	data := `{"classes":[{"name":"守卫者","hit_points":14,"resources":{"战吼":2}}]}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadRules(path); err != nil {
+		t.Fatalf("load rules: %v", err)
+	}
+	defer func() { GlobalRules = &Ruleset{} }()
+
+	ok := &Character{Name: "亚瑟", Class: "守卫者", HP: 16, MaxHP: 16, STR: 16, DEX: 12}
+	if err := GlobalRules.ValidateCharacter(ok); err != nil {
+		t.Errorf("expected valid character, got %v", err)
+	}
+
+	badClass := &Character{Name: "亚瑟", Class: "圣骑士", HP: 16, MaxHP: 16, STR: 16}
+	if err := GlobalRules.ValidateCharacter(badClass); err == nil {
+		t.Error("expected error for unknown class")
+	}
+
+	badHP := &Character{Name: "亚瑟", Class: "守卫者", HP: 120, MaxHP: 120, STR: 18}
+	if err := GlobalRules.ValidateCharacter(badHP); err == nil {
+		t.Error("expected error for HP above class maximum")
+	}
+
+	// 1 级上限 14+5=19，5 级再加 4×(8+5)=52 → 71
+	veteran := &Character{Name: "亚瑟", Class: "守卫者", Level: 5, HP: 60, MaxHP: 60, STR: 16, DEX: 12}
+	if err := GlobalRules.ValidateCharacter(veteran); err != nil {
+		t.Errorf("expected valid level-5 character, got %v", err)
+	}
+	veteran.Level = 1
+	if err := GlobalRules.ValidateCharacter(veteran); err == nil {
+		t.Error("expected error for level-1 HP above class maximum")
+	}
+
+	GlobalRules.ApplyClassDefaults(ok)
+	if ok.Resources["战吼"] != 2 {
+		t.Errorf("expected starting resource 战吼=2, got %v", ok.Resources)
+	}
+}
+
+func TestValidateCharacter_Race(t *testing.T) {
+	rules := &Ruleset{
+		MaxAbilityScore: 20,
+		Classes:         []*ClassDef{{Name: "追踪者", HitPoints: 12}},
+	}
+	elf := &Character{Name: "莉莉", Class: "追踪者", Race: "精灵", HP: 12, MaxHP: 12, STR: 10, DEX: 16}
+	if err := rules.ValidateCharacter(elf); err == nil {
+		t.Error("race= should be rejected when no races are defined")
+	}
+
+	rules.Races = []*RaceDef{{Name: "精灵", AbilityBonus: map[string]int{"DEX": 2}}}
+	if err := rules.ValidateCharacter(elf); err != nil {
+		t.Fatalf("expected valid elf, got %v", err)
+	}
+	rules.ApplyClassDefaults(elf)
+	if elf.DEX != 18 {
+		t.Errorf("expected racial DEX bonus to apply, got %d", elf.DEX)
+	}
+
+	// 种族加值之后不能超过属性上限
+	strong := &Character{Name: "索林", Class: "追踪者", Race: "矮人", HP: 12, MaxHP: 12, STR: 20, DEX: 10}
+	rules.Races = append(rules.Races, &RaceDef{Name: "矮人", AbilityBonus: map[string]int{"STR": 2}})
+	if err := rules.ValidateCharacter(strong); err == nil {
+		t.Error("expected error when the racial bonus pushes STR above the maximum")
+	}
+	strong.STR = 18
+	if err := rules.ValidateCharacter(strong); err != nil {
+		t.Errorf("expected STR 18 + 2 to be valid, got %v", err)
+	}
+
+	orc := &Character{Name: "格鲁", Class: "追踪者", Race: "兽人", HP: 12, MaxHP: 12, STR: 10, DEX: 10}
+	if err := rules.ValidateCharacter(orc); err == nil {
+		t.Error("expected error for unknown race")
+	}
+}
+
+func TestValidateCharacter_NoRulesLoaded(t *testing.T) {
+	rules := &Ruleset{}
+	char := &Character{Name: "派蒙", Class: "应急食品", HP: 10, MaxHP: 10, STR: 5}
+	if err := rules.ValidateCharacter(char); err != nil {
+		t.Errorf("expected free-form creation without rules, got %v", err)
+	}
+}
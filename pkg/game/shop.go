@@ -0,0 +1,326 @@
+package game
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"dndbot/pkg/dice"
+)
+
+// ShopItem 商人的一件商品或收购品
+type ShopItem struct {
+	Name  string `json:"name"`
+	Price string `json:"price"` // 例如 "10银"
+	Qty   int    `json:"qty"`   // 库存，0 表示不限量
+	Cost  int    `json:"-"`     // 解析后的价格 (铜币)
+}
+
+// Merchant 某地点的商人
+type Merchant struct {
+	Name        string     `json:"name"`
+	Location    string     `json:"location"` // 对应地图上的地点
+	Description string     `json:"description"`
+	HaggleDC    int        `json:"haggle_dc"` // 讲价的魅力检定难度，默认 12
+	Stock       []ShopItem `json:"stock"`
+	Wants       []ShopItem `json:"wants"` // 以标价全额收购的物品
+}
+
+// ShopConfig 商店配置，从 background/shops.json 加载
+type ShopConfig struct {
+	SellRate  int         `json:"sell_rate"` // 出售给商人时按标价的百分比回收，默认 50
+	Merchants []*Merchant `json:"merchants"`
+}
+
+// Trade 一次买卖的结果
+type Trade struct {
+	Character string
+	Merchant  string
+	Item      string
+	Qty       int
+	Total     int // 铜币
+	Purse     int // 交易后的钱包
+}
+
+// HaggleResult 一次讲价的结果
+type HaggleResult struct {
+	Character string
+	Merchant  string
+	Roll      *dice.RollResult
+	Modifier  int
+	DC        int
+	Percent   int // 价格调整百分比，负数为折扣
+}
+
+var GlobalShops = &ShopConfig{SellRate: 50}
+
+func cloneCounts(m map[string]int) map[string]int {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]int, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// LoadShops 从 JSON 文件加载商店配置并设置为全局配置
+func LoadShops(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var s ShopConfig
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	if s.SellRate == 0 {
+		s.SellRate = 50
+	}
+	for _, m := range s.Merchants {
+		if m.HaggleDC == 0 {
+			m.HaggleDC = 12
+		}
+		for _, list := range [][]ShopItem{m.Stock, m.Wants} {
+			for i := range list {
+				cost, ok := ParseCoins(list[i].Price)
+				if !ok {
+					return fmt.Errorf("%s: %s 的 %s 价格无效: %q", path, m.Name, list[i].Name, list[i].Price)
+				}
+				list[i].Cost = cost
+			}
+		}
+	}
+
+	GlobalShops = &s
+	return nil
+}
+
+// ChaModifier 魅力修正，未设置魅力时为 0
+func (c *Character) ChaModifier() int {
+	if c.CHA == 0 {
+		return 0
+	}
+	return AbilityModifier(c.CHA)
+}
+
+// MerchantsAt 指定地点的商人，location 为空 (未加载地图) 时返回全部
+func (s *ShopConfig) MerchantsAt(location string) []*Merchant {
+	var list []*Merchant
+	for _, m := range s.Merchants {
+		if location == "" || m.Location == location {
+			list = append(list, m)
+		}
+	}
+	return list
+}
+
+func findShopItem(list []ShopItem, name string) *ShopItem {
+	for i := range list {
+		if strings.EqualFold(list[i].Name, name) {
+			return &list[i]
+		}
+	}
+	return nil
+}
+
+// Sells 商人是否出售该物品
+func (m *Merchant) Sells(item string) bool {
+	return findShopItem(m.Stock, item) != nil
+}
+
+// WantsItem 商人是否以标价全额收购该物品
+func (m *Merchant) WantsItem(item string) bool {
+	return findShopItem(m.Wants, item) != nil
+}
+
+// listPrice 任一商人对该物品的标价，用于出售没有直接收购的物品
+func (s *ShopConfig) listPrice(name string) (int, bool) {
+	for _, m := range s.Merchants {
+		if it := findShopItem(m.Stock, name); it != nil {
+			return it.Cost, true
+		}
+	}
+	return 0, false
+}
+
+// adjust 按讲价结果调整价格
+func adjust(price, percent int) int {
+	return price * (100 + percent) / 100
+}
+
+func tradeKey(a, b string) string {
+	return strings.ToLower(a) + "/" + strings.ToLower(b)
+}
+
+// remaining 商品剩余库存，-1 表示不限量，调用方需持有锁
+func (g *GroupState) remaining(m *Merchant, it *ShopItem) int {
+	if it.Qty == 0 {
+		return -1
+	}
+	return it.Qty - g.ShopSold[tradeKey(m.Name, it.Name)]
+}
+
+// ShopListing 商人的商品列表，价格已按该角色的讲价结果调整
+func (g *GroupState) ShopListing(m *Merchant, charName string) string {
+	g.Mutex.RLock()
+	defer g.Mutex.RUnlock()
+
+	percent := g.Haggles[tradeKey(charName, m.Name)]
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("🏪 %s", m.Name))
+	if m.Description != "" {
+		sb.WriteString(" — " + m.Description)
+	}
+	if percent != 0 {
+		sb.WriteString(fmt.Sprintf(" (你的价格 %+d%%)", percent))
+	}
+	for i := range m.Stock {
+		it := &m.Stock[i]
+		line := fmt.Sprintf("\n  %s: %s", it.Name, FormatCoins(adjust(it.Cost, percent)))
+		switch left := g.remaining(m, it); {
+		case left == 0:
+			line += " (售罄)"
+		case left > 0:
+			line += fmt.Sprintf(" (剩 %d)", left)
+		}
+		sb.WriteString(line)
+	}
+	for _, it := range m.Wants {
+		sb.WriteString(fmt.Sprintf("\n  收购 %s: %s", it.Name, FormatCoins(it.Cost)))
+	}
+	return sb.String()
+}
+
+// Buy 角色向商人购买物品，扣除钱包并放入背包
+func (g *GroupState) Buy(m *Merchant, charName, item string, qty int) (*Trade, error) {
+	it := findShopItem(m.Stock, item)
+	if it == nil {
+		return nil, fmt.Errorf("%s 不卖 %s", m.Name, item)
+	}
+
+	g.Mutex.Lock()
+	defer g.Mutex.Unlock()
+	defer g.save()
+
+	char := g.Characters[strings.ToLower(charName)]
+	if char == nil {
+		return nil, fmt.Errorf("unknown char '%s'", charName)
+	}
+	if left := g.remaining(m, it); left >= 0 && left < qty {
+		return nil, fmt.Errorf("%s 只剩 %d 个 %s", m.Name, left, it.Name)
+	}
+	total := adjust(it.Cost, g.Haggles[tradeKey(char.Name, m.Name)]) * qty
+	if char.Purse < total {
+		return nil, fmt.Errorf("需要 %s，但 %s 只有 %s", FormatCoins(total), char.Name, FormatCoins(char.Purse))
+	}
+
+	char.Purse -= total
+	char.Inventory = addItem(char.Inventory, it.Name, qty)
+	if it.Qty > 0 {
+		if g.ShopSold == nil {
+			g.ShopSold = make(map[string]int)
+		}
+		g.ShopSold[tradeKey(m.Name, it.Name)] += qty
+	}
+	return &Trade{Character: char.Name, Merchant: m.Name, Item: it.Name, Qty: qty, Total: total, Purse: char.Purse}, nil
+}
+
+// Sell 角色把背包中的物品卖给商人
+// 商人收购清单中的物品按标价全额收购，其余有标价的物品按回收比例收购
+func (g *GroupState) Sell(s *ShopConfig, m *Merchant, charName, item string, qty int) (*Trade, error) {
+	g.Mutex.Lock()
+	defer g.Mutex.Unlock()
+	defer g.save()
+
+	char := g.Characters[strings.ToLower(charName)]
+	if char == nil {
+		return nil, fmt.Errorf("unknown char '%s'", charName)
+	}
+
+	var unit int
+	if want := findShopItem(m.Wants, item); want != nil {
+		unit = want.Cost
+	} else if price, ok := s.listPrice(item); ok {
+		unit = price * s.SellRate / 100
+	} else {
+		return nil, fmt.Errorf("%s 对 %s 没有兴趣", m.Name, item)
+	}
+	unit = adjust(unit, -g.Haggles[tradeKey(char.Name, m.Name)])
+
+	inv, name, err := removeItem(char.Inventory, item, qty)
+	if err != nil {
+		return nil, err
+	}
+	char.Inventory = inv
+	total := unit * qty
+	char.Purse += total
+	return &Trade{Character: char.Name, Merchant: m.Name, Item: name, Qty: qty, Total: total, Purse: char.Purse}, nil
+}
+
+// Haggle 角色与商人讲价: d20 + 魅力修正对抗商人 DC
+// 超过 DC 5 点或天然 20 打八折，成功打九折，失败 5 点以上商人加价一成
+// 每名角色对每个商人在一次长休前只能讲价一次
+func (g *GroupState) Haggle(m *Merchant, charName string) (*HaggleResult, error) {
+	g.Mutex.Lock()
+	defer g.Mutex.Unlock()
+	defer g.save()
+
+	char := g.Characters[strings.ToLower(charName)]
+	if char == nil {
+		return nil, fmt.Errorf("unknown char '%s'", charName)
+	}
+	key := tradeKey(char.Name, m.Name)
+	if _, done := g.Haggles[key]; done {
+		return nil, fmt.Errorf("%s 今天已经和 %s 讲过价了", char.Name, m.Name)
+	}
+
+	roll, _ := dice.Roll("1d20")
+	r := &HaggleResult{Character: char.Name, Merchant: m.Name, Roll: roll, Modifier: char.ChaModifier(), DC: m.HaggleDC}
+	total := roll.Total + r.Modifier
+	switch {
+	case roll.Total == 20 || total >= m.HaggleDC+5:
+		r.Percent = -20
+	case total >= m.HaggleDC:
+		r.Percent = -10
+	case roll.Total == 1 || total < m.HaggleDC-5:
+		r.Percent = 10
+	}
+
+	if g.Haggles == nil {
+		g.Haggles = make(map[string]int)
+	}
+	g.Haggles[key] = r.Percent
+	return r, nil
+}
+
+// String 讲价结果文本
+func (r *HaggleResult) String() string {
+	s := fmt.Sprintf("🗣️ %s 与 %s 讲价: 魅力检定 d20 [%d] %+d = %d vs DC %d → ",
+		r.Character, r.Merchant, r.Roll.Total, r.Modifier, r.Roll.Total+r.Modifier, r.DC)
+	switch {
+	case r.Percent < 0:
+		s += fmt.Sprintf("成功，价格 %d%%", 100+r.Percent)
+	case r.Percent > 0:
+		s += "惹恼了商人，价格上涨 10%"
+	default:
+		s += "商人不为所动，维持原价"
+	}
+	return s
+}
+
+// Summary 当前地点的商人，用于注入 Prompt
+func (s *ShopConfig) Summary(location string) string {
+	merchants := s.MerchantsAt(location)
+	if len(merchants) == 0 || location == "" {
+		return ""
+	}
+	names := make([]string, 0, len(merchants))
+	for _, m := range merchants {
+		names = append(names, m.Name)
+	}
+	return "【本地商人】" + strings.Join(names, ", ") + " (价格以系统为准，玩家通过 .shop/.buy/.sell/.haggle 交易，不要自行编造价格)\n"
+}
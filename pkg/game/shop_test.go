@@ -0,0 +1,67 @@
+package game
+
+import "testing"
+
+func TestLoadShops_ShippedFile(t *testing.T) {
+	if err := LoadShops("../../background/shops.json"); err != nil {
+		t.Fatalf("load shops: %v", err)
+	}
+	defer func() { GlobalShops = &ShopConfig{SellRate: 50} }()
+
+	if len(GlobalShops.MerchantsAt("杂货铺")) != 1 {
+		t.Error("expected one merchant at 杂货铺")
+	}
+}
+
+func testMerchant() *Merchant {
+	return &Merchant{
+		Name:     "Trader",
+		HaggleDC: 12,
+		Stock:    []ShopItem{{Name: "Potion", Cost: 10 * Silver, Qty: 2}, {Name: "Rope", Cost: 3 * Silver}},
+		Wants:    []ShopItem{{Name: "Venom", Cost: 3 * Silver}},
+	}
+}
+
+func TestBuySell_PurseAndStock(t *testing.T) {
+	m := testMerchant()
+	shops := &ShopConfig{SellRate: 50, Merchants: []*Merchant{m}}
+	g := newTestGroup(&Character{Name: "Hero", Purse: 25 * Silver})
+
+	if _, err := g.Buy(m, "Hero", "Potion", 3); err == nil {
+		t.Error("buying beyond stock should fail")
+	}
+	trade, err := g.Buy(m, "Hero", "Potion", 2)
+	if err != nil || trade.Purse != 5*Silver {
+		t.Fatalf("buy: %+v, %v", trade, err)
+	}
+	if _, err := g.Buy(m, "Hero", "Rope", 2); err == nil {
+		t.Error("buying without enough coins should fail")
+	}
+
+	trade, err = g.Sell(shops, m, "Hero", "Potion", 1)
+	if err != nil || trade.Total != 5*Silver {
+		t.Errorf("sell at 50%%: %+v, %v", trade, err)
+	}
+
+	g.DepositLoot(&Hoard{Items: []Item{{Name: "Venom", Qty: 2}}})
+	g.ClaimItem("Hero", "Venom", 2)
+	if trade, err = g.Sell(shops, m, "Hero", "Venom", 2); err != nil || trade.Total != 6*Silver {
+		t.Errorf("wanted item should sell at full price: %+v, %v", trade, err)
+	}
+}
+
+func TestHaggle_OncePerMerchant(t *testing.T) {
+	m := testMerchant()
+	g := newTestGroup(&Character{Name: "Hero", CHA: 16})
+
+	r, err := g.Haggle(m, "Hero")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Modifier != 3 || r.Percent < -20 || r.Percent > 10 {
+		t.Errorf("unexpected haggle result %+v", r)
+	}
+	if _, err := g.Haggle(m, "Hero"); err == nil {
+		t.Error("second haggle with same merchant should fail")
+	}
+}
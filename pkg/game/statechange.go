@@ -0,0 +1,241 @@
+package game
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// StateMark Action 执行前群组状态中各部分的值，用于计算 Action 造成的变化
+type StateMark struct {
+	parts  map[string][]byte
+	counts map[string]int
+}
+
+// StateChange 一组 Action 对群组状态的修改，用于撤销回合
+// 覆盖时间、定时事件、休息冷却、讲价、地点、队伍储物与物资、NPC、任务以及角色的状态与职业资源；
+// 血量变化与生成的角色由 HPChange 与回合日志单独记录
+type StateChange struct {
+	before, after map[string][]byte // 发生变化的部分修改前后的值 (JSON)，不存在时为 nil
+	delta         map[string]int    // 数量类部分 (时间、钱币、物品数量) 的变化量
+}
+
+// statusPart 角色的限时状态
+type statusPart struct {
+	Status string
+	Until  int
+}
+
+// MarkState 记录当前各部分的值，之后用 ChangeSince 得到期间的变化
+func (g *GroupState) MarkState() *StateMark {
+	g.Mutex.RLock()
+	defer g.Mutex.RUnlock()
+
+	parts, counts := g.parts()
+	return &StateMark{parts: parts, counts: counts}
+}
+
+// ChangeSince 自 m 以来状态的变化，没有变化时返回 nil
+func (g *GroupState) ChangeSince(m *StateMark) *StateChange {
+	g.Mutex.RLock()
+	defer g.Mutex.RUnlock()
+
+	parts, counts := g.parts()
+	c := &StateChange{before: make(map[string][]byte), after: make(map[string][]byte), delta: make(map[string]int)}
+	for key, v := range m.parts {
+		if !bytes.Equal(v, parts[key]) {
+			c.before[key], c.after[key] = v, parts[key]
+		}
+	}
+	for key, v := range parts {
+		if _, ok := m.parts[key]; !ok {
+			c.before[key], c.after[key] = nil, v
+		}
+	}
+	for key, n := range counts {
+		if d := n - m.counts[key]; d != 0 {
+			c.delta[key] = d
+		}
+	}
+	for key, n := range m.counts {
+		if _, ok := counts[key]; !ok {
+			c.delta[key] = -n
+		}
+	}
+	if len(c.after) == 0 && len(c.delta) == 0 {
+		return nil
+	}
+	return c
+}
+
+// RevertStateChange 撤销 c (用于撤销回合)
+// 数量类部分按变化量反向修改 (不低于 0)，其余部分只在之后没有再被修改时恢复，期间其他操作的修改保留
+func (g *GroupState) RevertStateChange(c *StateChange) {
+	g.Mutex.Lock()
+	defer g.Mutex.Unlock()
+	defer g.save()
+
+	parts, counts := g.parts()
+	for _, key := range sortedPartKeys(c.after) {
+		if bytes.Equal(parts[key], c.after[key]) {
+			g.setPart(key, c.before[key])
+		}
+	}
+	for key, d := range c.delta {
+		n := counts[key] - d
+		if n < 0 {
+			n = 0
+		}
+		g.setCount(key, n)
+	}
+}
+
+// parts 拆分出可以单独比较与恢复的各部分，调用方需持有锁
+func (g *GroupState) parts() (map[string][]byte, map[string]int) {
+	parts := make(map[string][]byte)
+	put := func(key string, v any) {
+		if data, err := json.Marshal(v); err == nil {
+			parts[key] = data
+		}
+	}
+	put("next_long_rest", g.NextLongRest)
+	put("events", g.Events)
+	put("haggles", g.Haggles)
+	put("location", g.Location)
+	put("discovered", g.Discovered)
+	for key, npc := range g.NPCs {
+		put("npc/"+key, npc)
+	}
+	for _, q := range g.Quests {
+		put("quest/"+q.Title, q)
+	}
+	for key, char := range g.Characters {
+		put("status/"+key, statusPart{Status: char.Status, Until: char.StatusUntil})
+		put("resources/"+key, char.Resources)
+	}
+
+	counts := map[string]int{"clock": g.Clock, "coins": g.Stash.Coins}
+	for _, it := range g.Stash.Items {
+		counts["stash/"+it.Name] = it.Qty
+	}
+	for _, it := range g.Party.Supplies {
+		counts["supply/"+it.Name] = it.Qty
+	}
+	return parts, counts
+}
+
+// setPart 将一部分恢复为 data，data 为 nil 表示该部分原本不存在，调用方需持有锁
+func (g *GroupState) setPart(key string, data []byte) {
+	kind, name, _ := strings.Cut(key, "/")
+	switch kind {
+	case "next_long_rest":
+		g.NextLongRest = 0
+		json.Unmarshal(data, &g.NextLongRest)
+	case "events":
+		g.Events = nil
+		json.Unmarshal(data, &g.Events)
+	case "haggles":
+		g.Haggles = nil
+		json.Unmarshal(data, &g.Haggles)
+	case "location":
+		g.Location = ""
+		json.Unmarshal(data, &g.Location)
+	case "discovered":
+		g.Discovered = nil
+		json.Unmarshal(data, &g.Discovered)
+	case "npc":
+		if data == nil {
+			delete(g.NPCs, name)
+			return
+		}
+		npc := &NPCRecord{}
+		if json.Unmarshal(data, npc) != nil {
+			return
+		}
+		if g.NPCs == nil {
+			g.NPCs = make(map[string]*NPCRecord)
+		}
+		g.NPCs[name] = npc
+	case "quest":
+		idx := -1
+		for i, q := range g.Quests {
+			if q.Title == name {
+				idx = i
+				break
+			}
+		}
+		if data == nil {
+			if idx >= 0 {
+				g.Quests = append(g.Quests[:idx], g.Quests[idx+1:]...)
+			}
+			return
+		}
+		q := &Quest{}
+		if json.Unmarshal(data, q) != nil {
+			return
+		}
+		if idx >= 0 {
+			g.Quests[idx] = q
+		} else {
+			g.Quests = append(g.Quests, q)
+		}
+	case "status", "resources":
+		char := g.Characters[name]
+		if char == nil || data == nil {
+			return // 角色已被移除，或者是期间新生成的角色
+		}
+		if kind == "status" {
+			var s statusPart
+			if json.Unmarshal(data, &s) == nil {
+				char.Status, char.StatusUntil = s.Status, s.Until
+			}
+		} else {
+			char.Resources = nil
+			json.Unmarshal(data, &char.Resources)
+		}
+	}
+}
+
+// setCount 修改数量类部分，物品数量为 0 时从列表中移除，调用方需持有锁
+func (g *GroupState) setCount(key string, n int) {
+	kind, name, _ := strings.Cut(key, "/")
+	switch kind {
+	case "clock":
+		g.Clock = n
+	case "coins":
+		g.Stash.Coins = n
+	case "stash":
+		g.Stash.Items = setItemQty(g.Stash.Items, name, n)
+	case "supply":
+		g.Party.Supplies = setItemQty(g.Party.Supplies, name, n)
+	}
+}
+
+// setItemQty 将物品数量设为 qty，qty 为 0 时移除
+func setItemQty(items []Item, name string, qty int) []Item {
+	for i := range items {
+		if items[i].Name != name {
+			continue
+		}
+		if qty <= 0 {
+			return append(items[:i], items[i+1:]...)
+		}
+		items[i].Qty = qty
+		return items
+	}
+	if qty <= 0 {
+		return items
+	}
+	return append(items, Item{Name: name, Qty: qty})
+}
+
+// sortedPartKeys 按名称排序的部分名，使恢复顺序固定
+func sortedPartKeys(parts map[string][]byte) []string {
+	keys := make([]string, 0, len(parts))
+	for k := range parts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
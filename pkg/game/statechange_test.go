@@ -0,0 +1,70 @@
+package game
+
+import "testing"
+
+func TestRevertStateChange_UndoesActionsAndKeepsLaterChanges(t *testing.T) {
+	c := DefaultCalendar()
+	g := newTestGroup(&Character{Name: "Hero", HP: 5, MaxHP: 12})
+	g.DepositLoot(&Hoard{Items: []Item{{Name: "火把", Qty: 2}}})
+	g.ScheduleEvent(30, "钟声响起")
+	g.UpsertNPC(NPCUpdate{Name: "老板", Description: "酒馆老板"})
+
+	mark := g.MarkState()
+	g.DepositLoot(&Hoard{Coins: 50, Items: []Item{{Name: "治疗药水", Qty: 1}, {Name: "火把", Qty: 1}}})
+	g.UpsertQuest(QuestUpdate{Title: "寻找失踪的商队"})
+	g.UpsertNPC(NPCUpdate{Name: "老板", Fact: "欠了盗贼公会的钱"})
+	g.ScheduleEvent(600, "强盗夜袭")
+	g.SetCondition("Hero", "中毒", 60)
+	adv := g.AdvanceTime(c, 45)
+	if len(adv.Events) != 1 {
+		t.Fatalf("expected the bell to ring, got %v", adv.Events)
+	}
+	change := g.ChangeSince(mark)
+	if change == nil {
+		t.Fatal("expected a change")
+	}
+
+	// 回合之后其他操作领走了一个火把
+	if _, err := g.AdjustSupply("口粮", 3); err != nil {
+		t.Fatal(err)
+	}
+	g.Stash.Items = setItemQty(g.Stash.Items, "火把", 2)
+
+	g.RevertStateChange(change)
+	if g.Clock != 0 || len(g.Events) != 1 || g.Events[0].Text != "钟声响起" {
+		t.Errorf("time and events not restored: clock %d events %+v", g.Clock, g.Events)
+	}
+	if g.Stash.Coins != 0 || len(g.Stash.Items) != 1 || g.Stash.Items[0] != (Item{Name: "火把", Qty: 1}) {
+		t.Errorf("loot not withdrawn by its own amount: %+v", g.Stash)
+	}
+	if len(g.Quests) != 0 {
+		t.Errorf("quest added by the action should be gone: %+v", g.Quests)
+	}
+	if npc := g.GetNPC("老板"); npc == nil || len(npc.Facts) != 0 {
+		t.Errorf("NPC not restored: %+v", npc)
+	}
+	if hero := g.GetCharacter("Hero"); hero.Status != "" || hero.StatusUntil != 0 {
+		t.Errorf("status not restored: %+v", hero)
+	}
+	if p := g.GetParty(); len(p.Supplies) != 1 || p.Supplies[0].Qty != 3 {
+		t.Errorf("later change should be kept: %+v", p.Supplies)
+	}
+
+	if g.ChangeSince(g.MarkState()) != nil {
+		t.Error("no change should be nil")
+	}
+}
+
+func TestRevertStateChange_SkipsPartsChangedLater(t *testing.T) {
+	g := newTestGroup(&Character{Name: "Hero", HP: 5, MaxHP: 12})
+
+	mark := g.MarkState()
+	g.SetCondition("Hero", "中毒", 0)
+	change := g.ChangeSince(mark)
+	g.SetCondition("Hero", "祝福", 0)
+
+	g.RevertStateChange(change)
+	if hero := g.GetCharacter("Hero"); hero.Status != "祝福" {
+		t.Errorf("status changed after the action should be kept, got %q", hero.Status)
+	}
+}
@@ -0,0 +1,61 @@
+package game
+
+import "github.com/sirupsen/logrus"
+
+// Store 群组状态的持久化存储，每次修改状态 (血量、物品、任务……) 后立即写入
+type Store interface {
+	// SaveGroupState 整体覆盖写入一个群组的状态
+	SaveGroupState(data *GroupStateData) error
+	// LoadGroupStates 读取所有群组的状态
+	LoadGroupStates() (map[int64]*GroupStateData, error)
+}
+
+// SetStore 为管理器及已有的群组设置存储，之后的修改都会写入 st
+func (m *StateManager) SetStore(st Store) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.store = st
+	for _, gs := range m.groups {
+		gs.Mutex.Lock()
+		gs.store = st
+		gs.Mutex.Unlock()
+	}
+}
+
+// Load 从存储读取所有群组状态并替换内存中的同名群组，不会回写
+func (m *StateManager) Load(st Store) error {
+	data, err := st.LoadGroupStates()
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for id, gData := range data {
+		gs := GroupStateFromData(gData)
+		gs.store = m.store
+		m.groups[id] = gs
+	}
+	return nil
+}
+
+// save 将群组状态整体写入存储，调用方需持有锁
+func (g *GroupState) save() {
+	if g.store == nil {
+		return
+	}
+	if err := g.store.SaveGroupState(g.data()); err != nil {
+		logrus.Errorf("Failed to save game state %d: %v", g.GroupID, err)
+	}
+}
+
+// attach 设置群组的存储并整体写入一次，传入 nil 时解除
+func (g *GroupState) attach(st Store) {
+	g.Mutex.Lock()
+	defer g.Mutex.Unlock()
+
+	g.store = st
+	g.save()
+}
@@ -0,0 +1,230 @@
+package game
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"dndbot/pkg/dice"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TableEntry 随机表中的一行，掷骰结果落在 [Min, Max] 时命中
+type TableEntry struct {
+	Min    int
+	Max    int
+	Result string
+}
+
+// RandomTable 从 Markdown 表格解析出的随机表
+type RandomTable struct {
+	Name    string
+	Dice    string
+	Source  string // 来源文件名
+	Entries []TableEntry
+}
+
+// TableRoll 一次随机表掷骰的结果
+type TableRoll struct {
+	Table  string
+	Roll   *dice.RollResult
+	Result string
+}
+
+// TableSet 所有已加载的随机表
+type TableSet struct {
+	Tables []*RandomTable
+}
+
+var GlobalTables = &TableSet{}
+
+// rangePattern 匹配 "3"、"1-2"、"5–6"、"9~10" 形式的点数范围
+var rangePattern = regexp.MustCompile(`^(\d+)\s*(?:[-–~～]\s*(\d+))?$`)
+
+// LoadTables 扫描目录下所有 .md 文件中的随机表并设置为全局表
+// 只有首列表头为骰子表达式 (如 1d8、d100) 的表格会被识别，重名时保留先加载的表
+// 无法读取的文件与含有无法解析的行的表会被跳过并记录警告，不影响其他表
+func LoadTables(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.md"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	set := &TableSet{}
+	seen := make(map[string]bool)
+	for _, file := range files {
+		tables, err := ParseMarkdownTables(file)
+		if err != nil {
+			logrus.Warnf("Skipping random tables in %s: %v", file, err)
+			continue
+		}
+		for _, t := range tables {
+			if key := strings.ToLower(t.Name); !seen[key] {
+				seen[key] = true
+				set.Tables = append(set.Tables, t)
+			}
+		}
+	}
+
+	GlobalTables = set
+	return nil
+}
+
+// ParseMarkdownTables 解析单个 Markdown 文件中的随机表，表名取自最近的标题
+// 含有无法解析的行的表会被整个丢弃并记录警告
+func ParseMarkdownTables(path string) ([]*RandomTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		tables  []*RandomTable
+		heading string
+		current *RandomTable
+		header  bool // 刚读到表头，下一行是分隔线
+	)
+	source := filepath.Base(path)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if !strings.HasPrefix(line, "|") {
+			if current != nil && len(current.Entries) > 0 {
+				tables = append(tables, current)
+			}
+			current = nil
+			if strings.HasPrefix(line, "#") {
+				heading = tableName(line)
+			}
+			continue
+		}
+
+		cells := splitRow(line)
+		switch {
+		case current == nil:
+			// 表头: 首列必须是骰子表达式
+			if _, _, _, err := dice.Parse(cells[0]); err != nil || heading == "" {
+				current = &RandomTable{} // 非随机表，跳过到表格结束
+				header = false
+				continue
+			}
+			current = &RandomTable{Name: heading, Dice: strings.ToLower(cells[0]), Source: source}
+			header = true
+		case header:
+			header = false // 分隔线 |---|---|
+		case current.Name == "":
+			// 普通表格的数据行
+		default:
+			m := rangePattern.FindStringSubmatch(cells[0])
+			if m == nil || len(cells) < 2 {
+				logrus.Warnf("%s: 表 %s 中无法解析的行: %s，已跳过该表", source, current.Name, line)
+				current = &RandomTable{} // 丢弃这张表，跳过到表格结束
+				continue
+			}
+			lo, _ := strconv.Atoi(m[1])
+			hi := lo
+			if m[2] != "" {
+				hi, _ = strconv.Atoi(m[2])
+			}
+			var result []string
+			for _, c := range cells[1:] {
+				if c != "" {
+					result = append(result, c)
+				}
+			}
+			current.Entries = append(current.Entries, TableEntry{Min: lo, Max: hi, Result: strings.Join(result, " | ")})
+		}
+	}
+	if current != nil && len(current.Entries) > 0 {
+		tables = append(tables, current)
+	}
+	return tables, scanner.Err()
+}
+
+// splitRow 拆分 Markdown 表格行并去掉首尾空白与粗体标记
+func splitRow(line string) []string {
+	line = strings.Trim(line, "|")
+	parts := strings.Split(line, "|")
+	cells := make([]string, len(parts))
+	for i, p := range parts {
+		cells[i] = strings.Trim(strings.TrimSpace(p), "*")
+	}
+	return cells
+}
+
+// tableName 从标题行提取表名，去掉 #、emoji 与末尾的 "(1d8)"
+func tableName(heading string) string {
+	name := strings.TrimLeft(heading, "# ")
+	name = strings.TrimLeftFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	if idx := strings.IndexAny(name, "(（"); idx > 0 {
+		name = name[:idx]
+	}
+	return strings.TrimSpace(name)
+}
+
+// Get 按名称查找随机表，找不到完全匹配时接受唯一的部分匹配
+func (s *TableSet) Get(name string) *RandomTable {
+	name = strings.TrimSpace(name)
+	var partial []*RandomTable
+	for _, t := range s.Tables {
+		if strings.EqualFold(t.Name, name) {
+			return t
+		}
+		if name != "" && strings.Contains(t.Name, name) {
+			partial = append(partial, t)
+		}
+	}
+	if len(partial) == 1 {
+		return partial[0]
+	}
+	return nil
+}
+
+// Names 所有随机表名称
+func (s *TableSet) Names() []string {
+	names := make([]string, 0, len(s.Tables))
+	for _, t := range s.Tables {
+		names = append(names, t.Name)
+	}
+	return names
+}
+
+// Summary 可用随机表一览，用于注入 Prompt
+func (s *TableSet) Summary() string {
+	if len(s.Tables) == 0 {
+		return ""
+	}
+	return "【随机表】可用: " + strings.Join(s.Names(), ", ") + "\n"
+}
+
+// Roll 在随机表上掷骰
+func (t *RandomTable) Roll() (*TableRoll, error) {
+	res, err := dice.Roll(t.Dice)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range t.Entries {
+		if res.Total >= e.Min && res.Total <= e.Max {
+			return &TableRoll{Table: t.Name, Roll: res, Result: e.Result}, nil
+		}
+	}
+	return nil, fmt.Errorf("表 %s 没有覆盖点数 %d", t.Name, res.Total)
+}
+
+// String 掷表结果文本
+func (r *TableRoll) String() string {
+	return fmt.Sprintf("🎲 %s (%s = %d): %s", r.Table, r.Roll.Expression, r.Roll.Total, r.Result)
+}
@@ -0,0 +1,64 @@
+package game
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadTables_ShippedFiles(t *testing.T) {
+	if err := LoadTables("../../background"); err != nil {
+		t.Fatalf("load tables: %v", err)
+	}
+	defer func() { GlobalTables = &TableSet{} }()
+
+	table := GlobalTables.Get("密林随机遭遇")
+	if table == nil || table.Dice != "1d8" {
+		t.Fatalf("expected 密林随机遭遇 (1d8), got %+v", table)
+	}
+	// bg.md 中的状态表等普通表格不应被识别为随机表
+	if GlobalTables.Get("状态效果系统") != nil {
+		t.Error("plain table parsed as random table")
+	}
+	for i := 0; i < 20; i++ {
+		if _, err := table.Roll(); err != nil {
+			t.Fatalf("roll: %v", err)
+		}
+	}
+}
+
+func TestParseMarkdownTables_Ranges(t *testing.T) {
+	md := "## 🎲 Weather (d4)\n| d4 | Result | Note |\n|---|---|---|\n| 1-2 | **Rain** | wet |\n| 3–4 | Sun | |\n\n| Name | Value |\n|---|---|\n| a | b |\n"
+	path := filepath.Join(t.TempDir(), "w.md")
+	if err := os.WriteFile(path, []byte(md), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tables, err := ParseMarkdownTables(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tables) != 1 || tables[0].Name != "Weather" {
+		t.Fatalf("expected single Weather table, got %+v", tables)
+	}
+	e := tables[0].Entries
+	if len(e) != 2 || e[0].Min != 1 || e[0].Max != 2 || e[0].Result != "Rain | wet" || e[1].Min != 3 || e[1].Max != 4 || e[1].Result != "Sun" {
+		t.Errorf("unexpected entries %+v", e)
+	}
+}
+
+func TestParseMarkdownTables_SkipsMalformedTable(t *testing.T) {
+	md := "## Broken (d4)\n| d4 | Result |\n|---|---|\n| 1 | ok |\n| x | bad |\n| 3 | later |\n\n## Good (d2)\n| d2 | Result |\n|---|---|\n| 1 | a |\n| 2 | b |\n"
+	path := filepath.Join(t.TempDir(), "b.md")
+	if err := os.WriteFile(path, []byte(md), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tables, err := ParseMarkdownTables(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tables) != 1 || tables[0].Name != "Good" || len(tables[0].Entries) != 2 {
+		t.Fatalf("expected only the Good table, got %+v", tables)
+	}
+}
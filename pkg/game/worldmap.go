@@ -0,0 +1,328 @@
+package game
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Location 地图上的一个地点
+type Location struct {
+	Name        string `json:"name"`
+	Region      string `json:"region"`
+	Description string `json:"description"` // 场景描述，队伍在此时注入 Prompt
+	Discovered  bool   `json:"discovered"`  // 开局即已知的地点
+}
+
+// Connection 两个地点之间的双向道路
+type Connection struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Minutes int    `json:"minutes"` // 步行所需时间
+}
+
+// WorldMap 世界地图模板，与背景文件配套 (bg.md -> bg.map.json)
+type WorldMap struct {
+	Start       string       `json:"start"`
+	Locations   []*Location  `json:"locations"`
+	Connections []Connection `json:"connections"`
+}
+
+// Travel 一次移动的结果
+type Travel struct {
+	From    string
+	To      string
+	Path    []string // 途经地点，包含起点与终点
+	Minutes int
+}
+
+// Exit 当前地点可直达的相邻地点
+type Exit struct {
+	Location   *Location
+	Minutes    int
+	Discovered bool
+}
+
+var GlobalWorldMap = &WorldMap{}
+
+// worldMaps 按背景文件缓存的地图，Key: 地图文件路径
+var (
+	worldMaps   = make(map[string]*WorldMap)
+	worldMapsMu sync.Mutex
+)
+
+// MapPathFor 背景文件对应的地图文件路径，例如 background/bg.md -> background/bg.map.json
+func MapPathFor(bgPath string) string {
+	return strings.TrimSuffix(bgPath, ".md") + ".map.json"
+}
+
+// LoadWorldMap 从 JSON 文件加载地图并设置为全局地图
+func LoadWorldMap(path string) error {
+	m, err := ReadWorldMap(path)
+	if err != nil {
+		return err
+	}
+	GlobalWorldMap = m
+	return nil
+}
+
+// ReadWorldMap 从 JSON 文件读取并校验地图
+func ReadWorldMap(path string) (*WorldMap, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var m WorldMap
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if m.Get(m.Start) == nil {
+		return nil, fmt.Errorf("start location %q not defined", m.Start)
+	}
+	for _, c := range m.Connections {
+		if m.Get(c.From) == nil || m.Get(c.To) == nil {
+			return nil, fmt.Errorf("connection %s -> %s references unknown location", c.From, c.To)
+		}
+	}
+	return &m, nil
+}
+
+// WorldMapFor 背景文件配套的地图，首次使用时加载并缓存
+// 没有配套地图时返回空地图 (不追踪位置)，地图文件有误时同时返回错误
+func WorldMapFor(bgPath string) (*WorldMap, error) {
+	path := MapPathFor(bgPath)
+
+	worldMapsMu.Lock()
+	defer worldMapsMu.Unlock()
+
+	if m, ok := worldMaps[path]; ok {
+		return m, nil
+	}
+	m, err := ReadWorldMap(path)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return &WorldMap{}, err
+		}
+		m = &WorldMap{}
+	}
+	worldMaps[path] = m
+	return m, nil
+}
+
+// Loaded 是否加载了地图
+func (m *WorldMap) Loaded() bool {
+	return len(m.Locations) > 0
+}
+
+// Get 按名称查找地点
+func (m *WorldMap) Get(name string) *Location {
+	for _, l := range m.Locations {
+		if strings.EqualFold(l.Name, name) {
+			return l
+		}
+	}
+	return nil
+}
+
+// neighbors 与地点直接相连的道路
+func (m *WorldMap) neighbors(name string) map[string]int {
+	n := make(map[string]int)
+	for _, c := range m.Connections {
+		switch {
+		case strings.EqualFold(c.From, name):
+			n[c.To] = c.Minutes
+		case strings.EqualFold(c.To, name):
+			n[c.From] = c.Minutes
+		}
+	}
+	return n
+}
+
+// Route 求两地之间耗时最短的路线 (Dijkstra)，不可达返回 nil
+func (m *WorldMap) Route(from, to string) ([]string, int) {
+	dist := map[string]int{from: 0}
+	prev := make(map[string]string)
+	visited := make(map[string]bool)
+
+	for {
+		cur, best := "", -1
+		for name, d := range dist {
+			if !visited[name] && (best < 0 || d < best) {
+				cur, best = name, d
+			}
+		}
+		if best < 0 {
+			return nil, 0
+		}
+		if cur == to {
+			break
+		}
+		visited[cur] = true
+		for next, minutes := range m.neighbors(cur) {
+			if d, ok := dist[next]; !ok || best+minutes < d {
+				dist[next] = best + minutes
+				prev[next] = cur
+			}
+		}
+	}
+
+	path := []string{to}
+	for cur := to; cur != from; {
+		cur = prev[cur]
+		path = append([]string{cur}, path...)
+	}
+	return path, dist[to]
+}
+
+// FormatMinutes 将分钟数格式化为 "1小时30分钟"
+func FormatMinutes(minutes int) string {
+	h, m := minutes/60, minutes%60
+	switch {
+	case h == 0:
+		return fmt.Sprintf("%d分钟", m)
+	case m == 0:
+		return fmt.Sprintf("%d小时", h)
+	default:
+		return fmt.Sprintf("%d小时%d分钟", h, m)
+	}
+}
+
+func cloneDiscovered(d map[string]bool) map[string]bool {
+	if d == nil {
+		return nil
+	}
+	c := make(map[string]bool, len(d))
+	for k, v := range d {
+		c[k] = v
+	}
+	return c
+}
+
+// currentLocation 队伍所在地点，未设置或已失效时返回起点，调用方需持有锁
+func (g *GroupState) currentLocation(m *WorldMap) *Location {
+	if l := m.Get(g.Location); l != nil {
+		return l
+	}
+	return m.Get(m.Start)
+}
+
+// isDiscovered 地点是否已被队伍发现，调用方需持有锁
+func (g *GroupState) isDiscovered(l *Location) bool {
+	return l.Discovered || g.Discovered[l.Name]
+}
+
+// CurrentLocation 队伍所在地点，未加载地图时返回 nil
+func (g *GroupState) CurrentLocation(m *WorldMap) *Location {
+	if !m.Loaded() {
+		return nil
+	}
+	g.Mutex.RLock()
+	defer g.Mutex.RUnlock()
+	return g.currentLocation(m)
+}
+
+// Exits 当前地点的相邻地点
+func (g *GroupState) Exits(m *WorldMap) []Exit {
+	g.Mutex.RLock()
+	defer g.Mutex.RUnlock()
+
+	here := g.currentLocation(m)
+	if here == nil {
+		return nil
+	}
+	var exits []Exit
+	for _, c := range m.Connections {
+		var other string
+		switch {
+		case c.From == here.Name:
+			other = c.To
+		case c.To == here.Name:
+			other = c.From
+		default:
+			continue
+		}
+		l := m.Get(other)
+		exits = append(exits, Exit{Location: l, Minutes: c.Minutes, Discovered: g.isDiscovered(l)})
+	}
+	return exits
+}
+
+// MoveParty 将队伍移动到目的地，沿最短路线计算耗时并标记沿途地点为已发现
+func (g *GroupState) MoveParty(m *WorldMap, dest string) (*Travel, error) {
+	if !m.Loaded() {
+		return nil, fmt.Errorf("没有加载地图")
+	}
+	to := m.Get(dest)
+	if to == nil {
+		return nil, fmt.Errorf("未知地点: %s", dest)
+	}
+
+	g.Mutex.Lock()
+	defer g.Mutex.Unlock()
+	defer g.save()
+
+	from := g.currentLocation(m)
+	path, minutes := m.Route(from.Name, to.Name)
+	if path == nil {
+		return nil, fmt.Errorf("%s 与 %s 之间没有道路", from.Name, to.Name)
+	}
+
+	if g.Discovered == nil {
+		g.Discovered = make(map[string]bool)
+	}
+	for _, name := range path {
+		g.Discovered[name] = true
+	}
+	g.Location = to.Name
+	return &Travel{From: from.Name, To: to.Name, Path: path, Minutes: minutes}, nil
+}
+
+// GetLocationSummary 当前地点的场景描述与出口，用于注入 Prompt
+// 未发现的出口会标注出来，DM 不应在玩家发现前直接说出其名称
+func (g *GroupState) GetLocationSummary(m *WorldMap) string {
+	here := g.CurrentLocation(m)
+	if here == nil {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("【当前位置】%s (%s)\n%s\n", here.Name, here.Region, here.Description))
+	exits := g.Exits(m)
+	if len(exits) > 0 {
+		sb.WriteString("可前往: ")
+		parts := make([]string, 0, len(exits))
+		for _, e := range exits {
+			part := fmt.Sprintf("%s(%s)", e.Location.Name, FormatMinutes(e.Minutes))
+			if !e.Discovered {
+				part += "[玩家尚未发现]"
+			}
+			parts = append(parts, part)
+		}
+		sb.WriteString(strings.Join(parts, ", ") + "\n")
+	}
+	return sb.String()
+}
+
+// GetWhereSummary 玩家视角的位置信息，未发现的出口只显示为 ???
+func (g *GroupState) GetWhereSummary(m *WorldMap) string {
+	here := g.CurrentLocation(m)
+	if here == nil {
+		return "没有加载地图。"
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("📍 %s · %s", here.Region, here.Name))
+	for _, e := range g.Exits(m) {
+		if e.Discovered {
+			sb.WriteString(fmt.Sprintf("\n  → %s (%s)", e.Location.Name, FormatMinutes(e.Minutes)))
+		} else {
+			sb.WriteString("\n  → ??? (未探索的小径)")
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,86 @@
+package game
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadWorldMap_ShippedFile(t *testing.T) {
+	if err := LoadWorldMap(MapPathFor("../../background/bg.md")); err != nil {
+		t.Fatalf("load map: %v", err)
+	}
+	defer func() { GlobalWorldMap = &WorldMap{} }()
+
+	if GlobalWorldMap.Get("老橡树酒馆") == nil {
+		t.Error("expected start location in map")
+	}
+}
+
+func testMap() *WorldMap {
+	return &WorldMap{
+		Start: "Tavern",
+		Locations: []*Location{
+			{Name: "Tavern", Region: "Town", Discovered: true},
+			{Name: "Square", Region: "Town", Discovered: true},
+			{Name: "Forest", Region: "Wild"},
+			{Name: "Cave", Region: "Wild"},
+		},
+		Connections: []Connection{
+			{From: "Tavern", To: "Square", Minutes: 5},
+			{From: "Square", To: "Forest", Minutes: 60},
+			{From: "Tavern", To: "Forest", Minutes: 90},
+			{From: "Forest", To: "Cave", Minutes: 30},
+		},
+	}
+}
+
+func TestMoveParty_ShortestRouteAndDiscovery(t *testing.T) {
+	m := testMap()
+	g := newTestGroup()
+
+	if strings.Contains(g.GetWhereSummary(m), "Forest") {
+		t.Error("undiscovered exit should be hidden from players")
+	}
+
+	travel, err := g.MoveParty(m, "Cave")
+	if err != nil {
+		t.Fatalf("move: %v", err)
+	}
+	if travel.Minutes != 95 || strings.Join(travel.Path, ",") != "Tavern,Square,Forest,Cave" {
+		t.Errorf("expected shortest route via Square (95 min), got %v %d", travel.Path, travel.Minutes)
+	}
+	if here := g.CurrentLocation(m); here.Name != "Cave" {
+		t.Errorf("expected party at Cave, got %s", here.Name)
+	}
+	if !strings.Contains(g.GetWhereSummary(m), "Forest") {
+		t.Error("Forest should be discovered after passing through")
+	}
+
+	if _, err := g.MoveParty(m, "Nowhere"); err == nil {
+		t.Error("expected error for unknown location")
+	}
+}
+
+func TestWorldMapFor_PairsWithBackground(t *testing.T) {
+	if m, err := WorldMapFor("../../background/bg.md"); err != nil || m.Get("老橡树酒馆") == nil {
+		t.Errorf("bg.md should pair with bg.map.json: %v", err)
+	}
+	if m, err := WorldMapFor("../../background/tables.md"); err != nil || m.Loaded() {
+		t.Errorf("background without map should get an empty map: %v", err)
+	}
+}
+
+func TestSetBackground_NewFileResetsLocation(t *testing.T) {
+	g := newTestGroup()
+	g.SetBackground("bg.md", "世界观")
+	g.Location = "Forest"
+
+	g.SetBackground("bg.md", "下起了雨")
+	if g.Location != "Forest" {
+		t.Error("scene update should keep party location")
+	}
+	g.SetBackground("tomb.md", "古墓")
+	if file, bg := g.GetBackground(); file != "tomb.md" || bg != "古墓" || g.Location != "" {
+		t.Errorf("unexpected state after loading new file: %q %q %q", file, bg, g.Location)
+	}
+}
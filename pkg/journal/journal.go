@@ -0,0 +1,319 @@
+package journal
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"dndbot/pkg/game"
+	"dndbot/pkg/session"
+)
+
+// MaxEntries 每个群组最多可以连续撤销的回合数
+const MaxEntries = 10
+
+// Entry 一个回合: 玩家发言、DM 回复以及回合内 Action 造成的状态变化
+// 只记录回合自己写入的消息与可以反向执行的操作 (血量变化、生成的角色、回合推进以及其余 Action 的修改)，
+// 撤销时逐个反向执行，回合期间的其他操作 (.buy、.st、修改摘要……) 不受影响
+type Entry struct {
+	Label   string // 例如 "Player(QQ:123): 我推开门"
+	OwnerID int64  // 发起回合的玩家，CLI 为 0
+	At      time.Time
+
+	groupID  int64
+	sess     *session.Session // 回合开始时的会话与群组状态，整个回合都作用于它们
+	state    *game.GroupState
+	messages []int // 回合写入的消息在会话中的序号
+	ops      []op  // 回合造成的状态变化，按发生顺序排列
+
+	// DM 回复开始时 messages 与 ops 的长度，用于 .reroll-dm 只丢弃回复部分
+	replied      bool
+	replyMessage int
+	replyOp      int
+	votes        map[int64]bool // 要求重新生成当前回复的玩家
+}
+
+// op 回合内一次可以反向执行的状态变化
+type op struct {
+	hp       *game.HPChange
+	spawned  *game.Character // 生成的角色
+	replaced *game.Character // 生成时被覆盖的同名角色
+	turn     *game.TurnMark  // 回合推进前的位置
+	state    *game.StateChange
+}
+
+// revert 反向执行 o
+func (o op) revert(state *game.GroupState) {
+	switch {
+	case o.hp != nil:
+		state.RevertHPChange(o.hp)
+	case o.spawned != nil:
+		if state.GetCharacter(o.spawned.Name) != o.spawned {
+			return // 已被移除或替换
+		}
+		if o.replaced != nil {
+			state.AddCharacter(o.replaced)
+		} else {
+			state.RemoveCharacter(o.spawned.Name)
+		}
+	case o.turn != nil:
+		state.RestoreTurn(o.turn)
+	case o.state != nil:
+		state.RevertStateChange(o.state)
+	}
+}
+
+// Session 回合所在的会话
+func (e *Entry) Session() *session.Session {
+	return e.sess
+}
+
+// State 回合所在的群组状态
+func (e *Entry) State() *game.GroupState {
+	return e.state
+}
+
+// AddMessage 向回合所在的会话写入一条消息，撤销回合时按序号删除这一条
+func (e *Entry) AddMessage(role, content string) {
+	e.messages = append(e.messages, e.sess.AddMessage(role, content))
+}
+
+// RecordHP 记录回合内的一次生命值变化，c 为 nil 时忽略
+func (e *Entry) RecordHP(c *game.HPChange) {
+	if c != nil {
+		e.ops = append(e.ops, op{hp: c})
+	}
+}
+
+// RecordSpawn 记录回合内生成的角色，replaced 为被同名覆盖的角色 (没有时为 nil)
+func (e *Entry) RecordSpawn(char, replaced *game.Character) {
+	e.ops = append(e.ops, op{spawned: char, replaced: replaced})
+}
+
+// RecordTurn 记录回合推进前的位置，m 为 nil 时忽略
+func (e *Entry) RecordTurn(m *game.TurnMark) {
+	if m != nil {
+		e.ops = append(e.ops, op{turn: m})
+	}
+}
+
+// RecordState 记录 Action 对时间、事件、地点、储物、NPC、任务等的修改，c 为 nil 时忽略
+func (e *Entry) RecordState(c *game.StateChange) {
+	if c != nil {
+		e.ops = append(e.ops, op{state: c})
+	}
+}
+
+// revertFrom 反向执行第 ops 个操作之后的变化，并删除第 messages 条之后写入的消息
+func (e *Entry) revertFrom(messages, ops int) {
+	for i := len(e.ops) - 1; i >= ops; i-- {
+		e.ops[i].revert(e.state)
+	}
+	e.sess.RemoveMessages(e.messages[messages:])
+	e.ops = e.ops[:ops]
+	e.messages = e.messages[:messages]
+}
+
+// Journal 各群组的回合日志
+// 同一群组的回合串行处理: Begin 等待上一回合结束，End 之前撤销与重新生成都会被拒绝
+type Journal struct {
+	groups map[int64][]*Entry
+	turns  map[int64]*sync.Mutex // 各群组进行中的回合
+	mutex  sync.Mutex
+}
+
+var GlobalJournal = New()
+
+// New 创建空的回合日志
+func New() *Journal {
+	return &Journal{
+		groups: make(map[int64][]*Entry),
+		turns:  make(map[int64]*sync.Mutex),
+	}
+}
+
+// turn 群组的回合锁
+func (j *Journal) turn(groupID int64) *sync.Mutex {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	mu := j.turns[groupID]
+	if mu == nil {
+		mu = &sync.Mutex{}
+		j.turns[groupID] = mu
+	}
+	return mu
+}
+
+// Begin 开始一个回合，等待群组上一回合结束
+// 回合结束后必须调用 End
+func (j *Journal) Begin(groupID, ownerID int64, label string) *Entry {
+	j.turn(groupID).Lock()
+
+	e := &Entry{
+		Label:   label,
+		OwnerID: ownerID,
+		At:      time.Now(),
+		groupID: groupID,
+		sess:    session.GlobalManager.GetSession(groupID),
+		state:   game.GlobalGameState.GetGroupState(groupID),
+	}
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.groups[groupID] = append(j.groups[groupID], e)
+	return e
+}
+
+// End 结束回合，允许下一回合开始
+// 没有写入任何内容的回合不会留在日志中，超出 MaxEntries 时丢弃最早的记录
+func (j *Journal) End(e *Entry) {
+	j.mutex.Lock()
+	entries := j.groups[e.groupID]
+	if n := len(entries); n > 0 && entries[n-1] == e && len(e.messages) == 0 && len(e.ops) == 0 {
+		entries = entries[:n-1]
+	}
+	if over := len(entries) - MaxEntries; over > 0 {
+		entries = entries[over:]
+	}
+	j.groups[e.groupID] = entries
+	j.mutex.Unlock()
+
+	j.turn(e.groupID).Unlock()
+}
+
+// Last 最近一个可撤销的回合，没有时返回 nil
+func (j *Journal) Last(groupID int64) *Entry {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	entries := j.groups[groupID]
+	if len(entries) == 0 {
+		return nil
+	}
+	return entries[len(entries)-1]
+}
+
+// Undo 撤销最近一个回合: 删除回合写入的消息并反向执行它造成的状态变化
+// 有回合正在等待 DM 回复时拒绝撤销
+func (j *Journal) Undo(groupID int64) (*Entry, error) {
+	mu := j.turn(groupID)
+	if !mu.TryLock() {
+		return nil, fmt.Errorf("DM 正在回复，请等回复完成后再撤销")
+	}
+	defer mu.Unlock()
+
+	j.mutex.Lock()
+	entries := j.groups[groupID]
+	if len(entries) == 0 {
+		j.mutex.Unlock()
+		return nil, fmt.Errorf("没有可以撤销的回合")
+	}
+	e := entries[len(entries)-1]
+	j.groups[groupID] = entries[:len(entries)-1]
+	j.mutex.Unlock()
+
+	e.revertFrom(0, 0)
+	return e, nil
+}
+
+// Clear 清空群组的回合日志，用于重置记忆或切换战役后
+func (j *Journal) Clear(groupID int64) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	delete(j.groups, groupID)
+}
+
+// Replace 在群组没有进行中的回合时执行 fn (切换战役)，成功后清空群组的回合日志
+// 有回合正在等待 DM 回复时拒绝执行，fn 返回错误时日志保留
+func (j *Journal) Replace(groupID int64, fn func() error) error {
+	mu := j.turn(groupID)
+	if !mu.TryLock() {
+		return fmt.Errorf("DM 正在回复，请等回复完成后再切换战役")
+	}
+	defer mu.Unlock()
+
+	if err := fn(); err != nil {
+		return err
+	}
+	j.Clear(groupID)
+	return nil
+}
+
+// Len 可以撤销的回合数
+func (j *Journal) Len(groupID int64) int {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return len(j.groups[groupID])
+}
+
+// MarkReply 在请求 DM 回复前记录回复的起点，重新生成回复时只丢弃之后的内容
+func (j *Journal) MarkReply(e *Entry) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	e.replied = true
+	e.replyMessage, e.replyOp = len(e.messages), len(e.ops)
+	e.votes = nil
+}
+
+// Vote 玩家投票要求重新生成上一条 DM 回复，返回当前票数
+// 只有在回合所在群组中拥有角色的玩家可以投票，之后不再拥有角色的玩家的票不计入
+func (j *Journal) Vote(groupID, voterID int64) (int, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	e, err := j.lastReply(groupID)
+	if err != nil {
+		return 0, err
+	}
+	owners := e.state.PlayerOwners()
+	if !owners[voterID] {
+		return 0, fmt.Errorf("只有拥有角色的玩家可以投票")
+	}
+	if e.votes == nil {
+		e.votes = make(map[int64]bool)
+	}
+	e.votes[voterID] = true
+
+	votes := 0
+	for id := range e.votes {
+		if owners[id] {
+			votes++
+		}
+	}
+	return votes, nil
+}
+
+// Reroll 丢弃上一条 DM 回复及其 Action 造成的状态变化，玩家发言与回复之前的结算保留
+// 成功时回合重新进入进行中状态，调用方生成新的回复后必须调用 End
+// 回合记录仍然保留，之后依旧可以 .undo 整个回合或再次重新生成
+func (j *Journal) Reroll(groupID int64) (*Entry, error) {
+	mu := j.turn(groupID)
+	if !mu.TryLock() {
+		return nil, fmt.Errorf("DM 正在回复，请等回复完成后再重新生成")
+	}
+
+	j.mutex.Lock()
+	e, err := j.lastReply(groupID)
+	if err == nil {
+		e.votes = nil
+	}
+	j.mutex.Unlock()
+	if err != nil {
+		mu.Unlock()
+		return nil, err
+	}
+
+	e.revertFrom(e.replyMessage, e.replyOp)
+	return e, nil
+}
+
+// lastReply 最近一个记录了 DM 回复起点的回合，调用方需持有锁
+func (j *Journal) lastReply(groupID int64) (*Entry, error) {
+	entries := j.groups[groupID]
+	if len(entries) == 0 || !entries[len(entries)-1].replied {
+		return nil, fmt.Errorf("没有可以重新生成的 DM 回复")
+	}
+	return entries[len(entries)-1], nil
+}
@@ -0,0 +1,248 @@
+package journal
+
+import (
+	"errors"
+	"testing"
+
+	"dndbot/pkg/game"
+	"dndbot/pkg/session"
+)
+
+func TestJournal_UndoRevertsOnlyTheTurn(t *testing.T) {
+	session.InitManager()
+	game.InitGameState()
+	j := New()
+	const gid = 7
+
+	session.GlobalManager.GetSession(gid).AddMessage("user", "第一回合")
+	gs := game.GlobalGameState.GetGroupState(gid)
+	gs.AddCharacter(&game.Character{Name: "Arthur", HP: 10, MaxHP: 10})
+
+	turn := j.Begin(gid, 1001, "Player(QQ:1001): 我推开门")
+	turn.AddMessage("user", "Player(QQ:1001): 我推开门")
+	// 回合进行中其他玩家的操作不属于这个回合
+	session.GlobalManager.GetSession(gid).AddMessage("user", "【系统提示】Bob 投出了 12")
+	turn.AddMessage("assistant", "一只哥布林扑了上来")
+	goblin := &game.Character{Name: "Goblin", HP: 7, MaxHP: 7, IsAI: true}
+	gs.AddCharacter(goblin)
+	turn.RecordSpawn(goblin, nil)
+	change, err := gs.ApplyHPChange("Arthur", -7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	turn.RecordHP(change)
+	mark := gs.MarkState()
+	gs.DepositLoot(&game.Hoard{Coins: 30})
+	gs.UpsertQuest(game.QuestUpdate{Title: "哥布林巢穴"})
+	turn.RecordState(gs.ChangeSince(mark))
+	j.End(turn)
+
+	if last := j.Last(gid); last == nil || last.OwnerID != 1001 {
+		t.Fatalf("unexpected last entry %+v", last)
+	}
+	e, err := j.Undo(gid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Label != "Player(QQ:1001): 我推开门" {
+		t.Errorf("unexpected label %q", e.Label)
+	}
+	h := session.GlobalManager.GetSession(gid).GetHistory()
+	if len(h) != 2 || h[0].Content != "第一回合" || h[1].Content != "【系统提示】Bob 投出了 12" {
+		t.Errorf("expected only the turn's messages removed: %+v", h)
+	}
+	if hp := gs.GetCharacter("Arthur").HP; hp != 10 {
+		t.Errorf("HP not restored: %d", hp)
+	}
+	if gs.GetCharacter("Goblin") != nil {
+		t.Error("character spawned during the turn should be gone")
+	}
+	if data := gs.Data(); data.Stash.Coins != 0 || len(data.Quests) != 0 {
+		t.Errorf("loot and quest from the turn not reverted: %+v %+v", data.Stash, data.Quests)
+	}
+	if _, err := j.Undo(gid); err == nil {
+		t.Error("undo with an empty journal should fail")
+	}
+}
+
+func TestJournal_RejectsUndoWhileTurnInFlight(t *testing.T) {
+	session.InitManager()
+	game.InitGameState()
+	j := New()
+
+	turn := j.Begin(1, 0, "turn")
+	turn.AddMessage("user", "hello")
+	j.MarkReply(turn)
+	if _, err := j.Undo(1); err == nil {
+		t.Error("undo should be rejected while the turn is in flight")
+	}
+	if _, err := j.Reroll(1); err == nil {
+		t.Error("reroll should be rejected while the turn is in flight")
+	}
+	called := false
+	if err := j.Replace(1, func() error { called = true; return nil }); err == nil || called {
+		t.Error("campaign switch should be rejected while the turn is in flight")
+	}
+	j.End(turn)
+
+	if err := j.Replace(1, func() error { return errors.New("unknown campaign") }); err == nil || j.Len(1) != 1 {
+		t.Error("failed switch should keep the journal")
+	}
+
+	if _, err := j.Undo(1); err != nil {
+		t.Errorf("undo after the turn ended: %v", err)
+	}
+
+	turn = j.Begin(1, 0, "turn")
+	turn.AddMessage("user", "hello")
+	j.End(turn)
+	if err := j.Replace(1, func() error { return nil }); err != nil || j.Len(1) != 0 {
+		t.Errorf("switch should clear the journal: %v", err)
+	}
+}
+
+func TestJournal_KeepsAtMostMaxEntries(t *testing.T) {
+	session.InitManager()
+	game.InitGameState()
+	j := New()
+
+	for i := 0; i < MaxEntries+3; i++ {
+		turn := j.Begin(1, 0, "turn")
+		turn.AddMessage("user", "hello")
+		j.End(turn)
+	}
+	if j.Len(1) != MaxEntries {
+		t.Errorf("expected %d entries, got %d", MaxEntries, j.Len(1))
+	}
+	// 没有写入任何内容的回合 (例如被 CheckTurn 拒绝) 不留在日志中
+	j.End(j.Begin(1, 0, "rejected"))
+	if j.Len(1) != MaxEntries || j.Last(1).Label != "turn" {
+		t.Error("empty turn should be dropped")
+	}
+	j.Clear(1)
+	if j.Last(1) != nil {
+		t.Error("journal should be empty after Clear")
+	}
+}
+
+func TestJournal_RerollKeepsPlayerInput(t *testing.T) {
+	session.InitManager()
+	game.InitGameState()
+	j := New()
+	const gid = 8
+
+	gs := game.GlobalGameState.GetGroupState(gid)
+	gs.AddCharacter(&game.Character{Name: "Arthur", HP: 10, MaxHP: 10, OwnerID: 1001})
+	gs.AddCharacter(&game.Character{Name: "Bob", HP: 10, MaxHP: 10, OwnerID: 1002})
+	gs.AddCharacter(&game.Character{Name: "Goblin", HP: 5, MaxHP: 5, IsAI: true})
+	if _, err := j.Reroll(gid); err == nil {
+		t.Error("reroll without a reply point should fail")
+	}
+
+	turn := j.Begin(gid, 1001, "Player(QQ:1001): 我推开门")
+	turn.AddMessage("user", "Player(QQ:1001): 我推开门")
+	j.MarkReply(turn)
+	turn.AddMessage("assistant", "一道闪电劈死了所有人")
+	for _, name := range []string{"Arthur", "Goblin"} {
+		change, err := gs.ApplyHPChange(name, -20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		turn.RecordHP(change)
+	}
+	j.End(turn)
+
+	if n, _ := j.Vote(gid, 1001); n != 1 {
+		t.Errorf("expected 1 vote, got %d", n)
+	}
+	if n, _ := j.Vote(gid, 1001); n != 1 {
+		t.Errorf("repeated vote should not count twice, got %d", n)
+	}
+	if _, err := j.Vote(gid, 2000); err == nil {
+		t.Error("players without a character should not be able to vote")
+	}
+
+	for i := 0; i < 2; i++ {
+		turn, err := j.Reroll(gid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := session.GlobalManager.GetSession(gid).GetHistory()
+		if len(h) != 1 || h[0].Content != "Player(QQ:1001): 我推开门" {
+			t.Errorf("reroll should keep only the player's message: %+v", h)
+		}
+		if c := gs.GetCharacter("Arthur"); c.HP != 10 || c.Status == "昏迷" {
+			t.Errorf("Arthur not restored: %+v", c)
+		}
+		if c := gs.GetCharacter("Goblin"); c == nil || c.HP != 5 {
+			t.Errorf("killed NPC not restored: %+v", c)
+		}
+		j.MarkReply(turn)
+		turn.AddMessage("assistant", "另一版回复")
+		j.End(turn)
+	}
+	if n, _ := j.Vote(gid, 1002); n != 1 {
+		t.Errorf("votes should reset after a reroll, got %d", n)
+	}
+	// 投票后删除了角色的玩家不再计票
+	j.Vote(gid, 1001)
+	gs.RemoveCharacter("Bob")
+	if n, _ := j.Vote(gid, 1001); n != 1 {
+		t.Errorf("vote of a player who left should not count, got %d", n)
+	}
+
+	// 撤销整个回合仍然回到玩家发言之前
+	if _, err := j.Undo(gid); err != nil {
+		t.Fatal(err)
+	}
+	if h := session.GlobalManager.GetSession(gid).GetHistory(); len(h) != 0 {
+		t.Errorf("undo after reroll should drop the whole turn: %+v", h)
+	}
+}
+
+func TestJournal_RerollRevertsAllReplyActions(t *testing.T) {
+	session.InitManager()
+	game.InitGameState()
+	j := New()
+	const gid = 9
+
+	gs := game.GlobalGameState.GetGroupState(gid)
+	gs.AddCharacter(&game.Character{Name: "Arthur", HP: 10, MaxHP: 10, OwnerID: 1001})
+	turn := j.Begin(gid, 1001, "Player(QQ:1001): 我们扎营休息")
+	turn.AddMessage("user", "Player(QQ:1001): 我们扎营休息")
+
+	// 每一版回复都发放同样的战利品、推进同样的时间，重新生成后不能叠加
+	for i := 0; i < 3; i++ {
+		if i > 0 {
+			var err error
+			if turn, err = j.Reroll(gid); err != nil {
+				t.Fatal(err)
+			}
+		}
+		j.MarkReply(turn)
+		turn.AddMessage("assistant", "你们在营地找到一袋金币")
+		mark := gs.MarkState()
+		gs.DepositLoot(&game.Hoard{Coins: 100, Items: []game.Item{{Name: "治疗药水", Qty: 1}}})
+		gs.AdvanceTime(game.DefaultCalendar(), 480)
+		gs.ScheduleEvent(60, "狼群靠近营地")
+		gs.SetCondition("Arthur", "疲惫", 0)
+		turn.RecordState(gs.ChangeSince(mark))
+		j.End(turn)
+	}
+
+	data := gs.Data()
+	if data.Stash.Coins != 100 || len(data.Stash.Items) != 1 || data.Stash.Items[0].Qty != 1 {
+		t.Errorf("loot applied more than once: %+v", data.Stash)
+	}
+	if data.Clock != 480 || len(data.Events) != 1 {
+		t.Errorf("time or events applied more than once: clock %d events %d", data.Clock, len(data.Events))
+	}
+
+	if _, err := j.Undo(gid); err != nil {
+		t.Fatal(err)
+	}
+	data = gs.Data()
+	if data.Stash.Coins != 0 || data.Clock != 0 || len(data.Events) != 0 || data.Characters["arthur"].Status != "" {
+		t.Errorf("undo should revert the reply's actions: %+v", data)
+	}
+}
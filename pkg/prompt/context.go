@@ -0,0 +1,218 @@
+package prompt
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// 预算相关的默认值
+const (
+	DefaultWindow   = 32000 // 未配置 CONTEXT_WINDOW_TOKENS 时的模型上下文窗口
+	MaxReplyReserve = 2048  // 为模型回复预留的 token，窗口较小时按 1/4 预留
+	MinHistory      = 6     // 至少保留的最近对话条数
+	HistoryPriority = 4     // 较早的对话记录在裁剪顺序中的优先级
+	messageOverhead = 4     // 每条消息的角色、分隔符等开销
+	minTruncated    = 64    // 截断后不足此数时整段丢弃
+)
+
+// Section 系统提示中的一段
+type Section struct {
+	Name     string
+	Text     string
+	Priority int  // 0 为必需，数值越大越先被裁剪
+	Truncate bool // 超出预算时保留开头部分，而不是整段丢弃
+}
+
+// Usage 一段内容的预算使用情况
+type Usage struct {
+	Name     string
+	Original int
+	Tokens   int
+}
+
+// Report 上下文预算明细
+type Report struct {
+	Window       int
+	Reserve      int
+	Sections     []Usage
+	HistoryTotal int // 会话中的对话条数
+	HistoryKept  int // 实际发送的对话条数
+	HistoryToken int
+	Total        int
+}
+
+// Builder 按 token 预算组装发送给模型的上下文
+type Builder struct {
+	Window   int
+	Sections []Section
+	History  []openai.ChatCompletionMessage
+}
+
+// EstimateTokens 粗略估算 token 数: 中文等非 ASCII 字符约 1 个 token，ASCII 约 4 个字符 1 个 token
+func EstimateTokens(s string) int {
+	return (quarterTokens(s) + 3) / 4
+}
+
+// quarterTokens 以 1/4 token 为单位的估算值
+func quarterTokens(s string) int {
+	n := 0
+	for _, r := range s {
+		if r < 128 {
+			n++
+		} else {
+			n += 4
+		}
+	}
+	return n
+}
+
+// TruncateTokens 截取不超过 maxTokens 的开头部分，优先在换行处截断
+func TruncateTokens(s string, maxTokens int) string {
+	if EstimateTokens(s) <= maxTokens {
+		return s
+	}
+	const marker = "\n…(为控制上下文长度，以下内容已省略)\n"
+	limit := (maxTokens - EstimateTokens(marker)) * 4
+	units, cut := 0, 0
+	for i, r := range s {
+		if r < 128 {
+			units++
+		} else {
+			units += 4
+		}
+		if units > limit {
+			break
+		}
+		cut = i + len(string(r))
+	}
+	head := s[:cut]
+	if idx := strings.LastIndex(head, "\n"); idx > len(head)/2 {
+		head = head[:idx]
+	}
+	return head + marker
+}
+
+// reserve 为回复预留的 token
+func (b *Builder) reserve() int {
+	if r := b.Window / 4; r < MaxReplyReserve {
+		return r
+	}
+	return MaxReplyReserve
+}
+
+// Build 组装上下文: 超出预算时按优先级从低到高裁剪，较早的对话记录视为优先级 HistoryPriority
+func (b *Builder) Build() ([]openai.ChatCompletionMessage, *Report) {
+	if b.Window <= 0 {
+		b.Window = DefaultWindow
+	}
+	report := &Report{Window: b.Window, Reserve: b.reserve(), HistoryTotal: len(b.History)}
+	budget := b.Window - report.Reserve
+
+	texts := make([]string, len(b.Sections))
+	tokens := make([]int, len(b.Sections))
+	for i, s := range b.Sections {
+		texts[i] = s.Text
+		tokens[i] = EstimateTokens(s.Text)
+	}
+	historyTokens := make([]int, len(b.History))
+	for i, m := range b.History {
+		historyTokens[i] = EstimateTokens(m.Content) + messageOverhead
+	}
+
+	start := 0 // 保留 History[start:]
+	total := func() int {
+		sum := messageOverhead
+		for _, t := range tokens {
+			sum += t
+		}
+		for _, t := range historyTokens[start:] {
+			sum += t
+		}
+		return sum
+	}
+
+	// 裁剪顺序: 优先级数值大的先裁，同优先级按出现顺序倒序
+	order := make([]int, 0, len(b.Sections)+1)
+	for i := range b.Sections {
+		if b.Sections[i].Priority > 0 {
+			order = append(order, i)
+		}
+	}
+	order = append(order, -1) // -1 表示较早的对话记录
+	priority := func(i int) int {
+		if i < 0 {
+			return HistoryPriority
+		}
+		return b.Sections[i].Priority
+	}
+	sort.SliceStable(order, func(x, y int) bool {
+		if priority(order[x]) != priority(order[y]) {
+			return priority(order[x]) > priority(order[y])
+		}
+		return order[x] > order[y]
+	})
+
+	for _, i := range order {
+		over := total() - budget
+		if over <= 0 {
+			break
+		}
+		if i < 0 {
+			for start < len(b.History)-MinHistory && over > 0 {
+				over -= historyTokens[start]
+				start++
+			}
+			continue
+		}
+		keep := tokens[i] - over
+		if b.Sections[i].Truncate && keep >= minTruncated {
+			texts[i] = TruncateTokens(texts[i], keep)
+		} else {
+			texts[i] = ""
+		}
+		tokens[i] = EstimateTokens(texts[i])
+	}
+
+	var system strings.Builder
+	for i, s := range b.Sections {
+		system.WriteString(texts[i])
+		report.Sections = append(report.Sections, Usage{Name: s.Name, Original: EstimateTokens(s.Text), Tokens: tokens[i]})
+	}
+
+	messages := []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleSystem, Content: system.String()}}
+	messages = append(messages, b.History[start:]...)
+
+	report.HistoryKept = len(b.History) - start
+	for _, t := range historyTokens[start:] {
+		report.HistoryToken += t
+	}
+	report.Total = total()
+	return messages, report
+}
+
+// String 预算明细文本
+func (r *Report) String() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("📐 上下文预算: 窗口 %d，预留回复 %d，可用 %d\n", r.Window, r.Reserve, r.Window-r.Reserve))
+	for _, u := range r.Sections {
+		switch {
+		case u.Original == 0:
+			continue
+		case u.Tokens == u.Original:
+			sb.WriteString(fmt.Sprintf("  %s: %d\n", u.Name, u.Tokens))
+		case u.Tokens == 0:
+			sb.WriteString(fmt.Sprintf("  %s: %d → 已丢弃\n", u.Name, u.Original))
+		default:
+			sb.WriteString(fmt.Sprintf("  %s: %d → %d (截断)\n", u.Name, u.Original, u.Tokens))
+		}
+	}
+	sb.WriteString(fmt.Sprintf("  对话记录: %d/%d 条，%d\n", r.HistoryKept, r.HistoryTotal, r.HistoryToken))
+	sb.WriteString(fmt.Sprintf("合计: %d / %d", r.Total, r.Window-r.Reserve))
+	if r.Total > r.Window-r.Reserve {
+		sb.WriteString(" ⚠️ 必需内容已超出预算")
+	}
+	return sb.String()
+}
@@ -0,0 +1,50 @@
+package prompt
+
+import (
+	"strings"
+	"testing"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+func TestEstimateTokens(t *testing.T) {
+	if n := EstimateTokens("你好世界"); n != 4 {
+		t.Errorf("CJK: got %d, want 4", n)
+	}
+	if n := EstimateTokens("abcdefgh"); n != 2 {
+		t.Errorf("ASCII: got %d, want 2", n)
+	}
+}
+
+func TestBuild_TrimsLowPriorityFirst(t *testing.T) {
+	history := make([]openai.ChatCompletionMessage, MinHistory+1)
+	for i := range history {
+		history[i] = openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: strings.Repeat("话", 100)}
+	}
+	b := &Builder{
+		Window:  4000, // 预留 1000，可用 3000
+		History: history,
+		Sections: []Section{
+			{Name: "规则", Text: strings.Repeat("规", 500)},
+			{Name: "场景", Text: strings.Repeat("景\n", 2000), Priority: 2, Truncate: true},
+			{Name: "图鉴", Text: strings.Repeat("怪", 300), Priority: 6},
+		},
+	}
+	msgs, report := b.Build()
+
+	if report.Total > 3000 {
+		t.Fatalf("context not trimmed to budget:\n%s", report)
+	}
+	if report.Sections[2].Tokens != 0 {
+		t.Error("lowest priority section should be dropped first")
+	}
+	if report.Sections[0].Tokens != 500 {
+		t.Error("required section must not be trimmed")
+	}
+	if report.HistoryKept < MinHistory || len(msgs) != report.HistoryKept+1 {
+		t.Errorf("kept %d history messages, %d total messages", report.HistoryKept, len(msgs))
+	}
+	if !strings.Contains(msgs[0].Content, "已省略") {
+		t.Error("scene should be truncated rather than dropped")
+	}
+}
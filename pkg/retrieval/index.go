@@ -0,0 +1,203 @@
+package retrieval
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// BM25 参数
+const (
+	k1 = 1.2
+	b  = 0.75
+)
+
+// Chunk 背景文件中一个标题下的内容
+type Chunk struct {
+	Heading string // 标题路径，例如 "完整怪物图鉴 > 常见怪物"
+	Text    string
+}
+
+// Result 一条检索结果
+type Result struct {
+	Chunk *Chunk
+	Score float64
+}
+
+// Index 基于 BM25 的本地关键词索引，中文按相邻两字切分
+type Index struct {
+	Chunks []*Chunk
+	terms  []map[string]int // 每个片段的词频
+	lens   []int
+	avgLen float64
+	df     map[string]int
+}
+
+// ChunkMarkdown 按 Markdown 标题切分文本，只有标题没有正文的片段会并入下级标题的路径
+func ChunkMarkdown(text string) []*Chunk {
+	var (
+		chunks []*Chunk
+		path   []string // 各级标题
+		body   []string
+	)
+	flush := func() {
+		content := strings.TrimSpace(strings.Join(body, "\n"))
+		body = body[:0]
+		if content == "" || strings.Trim(content, "-\n ") == "" {
+			return
+		}
+		chunks = append(chunks, &Chunk{Heading: strings.Join(nonEmpty(path), " > "), Text: content})
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		level := len(trimmed) - len(strings.TrimLeft(trimmed, "#"))
+		if level == 0 || level > 6 || !strings.HasPrefix(trimmed[level:], " ") {
+			body = append(body, line)
+			continue
+		}
+		flush()
+		for len(path) < level {
+			path = append(path, "")
+		}
+		path = append(path[:level-1], headingText(trimmed[level:]))
+	}
+	flush()
+	return chunks
+}
+
+func nonEmpty(list []string) []string {
+	var out []string
+	for _, s := range list {
+		if s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// headingText 去掉标题开头的 emoji 与空白，保留书名号等标点
+func headingText(h string) string {
+	return strings.TrimLeftFunc(h, func(r rune) bool {
+		return unicode.IsSymbol(r) || unicode.IsSpace(r) || unicode.IsMark(r)
+	})
+}
+
+// Tokenize 将文本切分为检索词: 英文与数字按单词，中文按相邻两字 (单独的汉字保留为一个词)
+func Tokenize(text string) []string {
+	var (
+		tokens []string
+		word   []rune
+		han    []rune
+	)
+	flushWord := func() {
+		if len(word) > 0 {
+			tokens = append(tokens, string(word))
+			word = word[:0]
+		}
+	}
+	flushHan := func() {
+		switch len(han) {
+		case 0:
+		case 1:
+			tokens = append(tokens, string(han))
+		default:
+			for i := 0; i+1 < len(han); i++ {
+				tokens = append(tokens, string(han[i:i+2]))
+			}
+		}
+		han = han[:0]
+	}
+
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			flushWord()
+			han = append(han, r)
+		case unicode.IsLetter(r) || unicode.IsNumber(r):
+			flushHan()
+			word = append(word, r)
+		default:
+			flushWord()
+			flushHan()
+		}
+	}
+	flushWord()
+	flushHan()
+	return tokens
+}
+
+// NewIndex 为片段建立索引，标题计入检索文本
+func NewIndex(chunks []*Chunk) *Index {
+	idx := &Index{Chunks: chunks, df: make(map[string]int)}
+	total := 0
+	for _, c := range chunks {
+		tf := make(map[string]int)
+		tokens := Tokenize(c.Heading + "\n" + c.Text)
+		for _, t := range tokens {
+			tf[t]++
+		}
+		for t := range tf {
+			idx.df[t]++
+		}
+		idx.terms = append(idx.terms, tf)
+		idx.lens = append(idx.lens, len(tokens))
+		total += len(tokens)
+	}
+	if len(chunks) > 0 {
+		idx.avgLen = float64(total) / float64(len(chunks))
+	}
+	return idx
+}
+
+// Search 返回与查询最相关的至多 k 个片段，得分为 0 的片段不返回
+func (idx *Index) Search(query string, k int) []Result {
+	queryTerms := make(map[string]bool)
+	for _, t := range Tokenize(query) {
+		queryTerms[t] = true
+	}
+
+	n := float64(len(idx.Chunks))
+	var results []Result
+	for i, tf := range idx.terms {
+		score := 0.0
+		for t := range queryTerms {
+			f := float64(tf[t])
+			if f == 0 {
+				continue
+			}
+			df := float64(idx.df[t])
+			idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+			score += idf * f * (k1 + 1) / (f + k1*(1-b+b*float64(idx.lens[i])/idx.avgLen))
+		}
+		if score > 0 {
+			results = append(results, Result{Chunk: idx.Chunks[i], Score: score})
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	if len(results) > k {
+		results = results[:k]
+	}
+	return results
+}
+
+// indexes 按文本内容缓存的索引，背景很少变化，切换背景时才会新建
+var (
+	indexes   = make(map[string]*Index)
+	indexesMu sync.Mutex
+)
+
+// IndexFor 获取或建立文本的索引
+func IndexFor(text string) *Index {
+	indexesMu.Lock()
+	defer indexesMu.Unlock()
+
+	if idx, ok := indexes[text]; ok {
+		return idx
+	}
+	idx := NewIndex(ChunkMarkdown(text))
+	indexes[text] = idx
+	return idx
+}
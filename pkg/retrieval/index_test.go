@@ -0,0 +1,41 @@
+package retrieval
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenize_CJKBigrams(t *testing.T) {
+	got := Tokenize("狼蛛巢穴 Goblin 2级")
+	want := []string{"狼蛛", "蛛巢", "巢穴", "goblin", "2", "级"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Tokenize = %v, want %v", got, want)
+	}
+}
+
+func TestChunkMarkdown_HeadingPath(t *testing.T) {
+	chunks := ChunkMarkdown("# 设定\n## 🧌 怪物\n### 常见怪物\n地精\n---\n## 道具\n药水\n")
+	if len(chunks) != 2 {
+		t.Fatalf("got %d chunks: %+v", len(chunks), chunks)
+	}
+	if chunks[0].Heading != "设定 > 怪物 > 常见怪物" || chunks[1].Heading != "设定 > 道具" {
+		t.Errorf("unexpected headings %q, %q", chunks[0].Heading, chunks[1].Heading)
+	}
+}
+
+func TestSearch_ShippedBackground(t *testing.T) {
+	data, err := os.ReadFile("../../background/bg.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx := IndexFor(string(data))
+	results := idx.Search("我们去找狼蛛巢穴", 3)
+	if len(results) == 0 || !strings.Contains(results[0].Chunk.Heading+results[0].Chunk.Text, "狼蛛") {
+		t.Fatalf("expected spider lair chunk first, got %+v", results)
+	}
+	if len(idx.Search("xyzzy", 3)) != 0 {
+		t.Error("unrelated query should return nothing")
+	}
+}
@@ -0,0 +1,89 @@
+package session
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"dndbot/pkg/retrieval"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// Chapter 一次自动总结所覆盖的那段剧情
+type Chapter struct {
+	Number    int
+	Summary   string
+	CreatedAt time.Time
+}
+
+// recallCues 玩家提到过去事件时常用的词
+var recallCues = []string{"之前", "上次", "以前", "还记得", "记不记得", "当初", "那次", "曾经", "先前", "回忆", "想起"}
+
+// Title 章节标题，例如 "第3章 (10-18 21:30)"
+func (c Chapter) Title() string {
+	return fmt.Sprintf("第%d章 (%s)", c.Number, c.CreatedAt.Format("01-02 15:04"))
+}
+
+// AddChapter 追加一章摘要
+func (s *Session) AddChapter(summary string) Chapter {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	c := Chapter{Number: len(s.Chapters) + 1, Summary: summary, CreatedAt: time.Now()}
+	s.Chapters = append(s.Chapters, c)
+	s.save()
+	return c
+}
+
+// GetChapters 获取所有章节的副本
+func (s *Session) GetChapters() []Chapter {
+	s.Mutex.RLock()
+	defer s.Mutex.RUnlock()
+	return append([]Chapter(nil), s.Chapters...)
+}
+
+// SearchChapters 在章节摘要中检索与 query 最相关的至多 k 章，按章节顺序返回
+func (s *Session) SearchChapters(query string, k int) []Chapter {
+	chapters := s.GetChapters()
+	if len(chapters) == 0 {
+		return nil
+	}
+
+	chunks := make([]*retrieval.Chunk, len(chapters))
+	byChunk := make(map[*retrieval.Chunk]Chapter, len(chapters))
+	for i, c := range chapters {
+		chunks[i] = &retrieval.Chunk{Heading: c.Title(), Text: c.Summary}
+		byChunk[chunks[i]] = c
+	}
+
+	var found []Chapter
+	for _, r := range retrieval.NewIndex(chunks).Search(query, k) {
+		found = append(found, byChunk[r.Chunk])
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].Number < found[j].Number })
+	return found
+}
+
+// RecallQuery 最近几条玩家发言中提到过去的事件时返回这些发言，否则返回空字符串
+func (s *Session) RecallQuery(messages int) string {
+	history := s.GetHistory()
+	var parts []string
+	recalled := false
+	for i := len(history) - 1; i >= 0 && len(parts) < messages; i-- {
+		if history[i].Role != openai.ChatMessageRoleUser {
+			continue
+		}
+		parts = append(parts, history[i].Content)
+		for _, cue := range recallCues {
+			if strings.Contains(history[i].Content, cue) {
+				recalled = true
+			}
+		}
+	}
+	if !recalled {
+		return ""
+	}
+	return strings.Join(parts, "\n")
+}
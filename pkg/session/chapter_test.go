@@ -0,0 +1,34 @@
+package session
+
+import (
+	"testing"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+func TestChapters_RecallAndSearch(t *testing.T) {
+	s := NewSession(1)
+	s.AddChapter("队伍在暮色镇的酒馆接下了寻找失踪商队的委托")
+	s.AddChapter("在幽光密林深处击败了狼蛛女王，获得了一枚精灵护符")
+	s.AddChapter("与古老树精交谈，得知地下遗迹的入口")
+
+	s.AddMessage(openai.ChatMessageRoleUser, "我们去遗迹吧")
+	if q := s.RecallQuery(3); q != "" {
+		t.Errorf("no recall cue, got query %q", q)
+	}
+
+	s.AddMessage(openai.ChatMessageRoleUser, "还记得之前打败狼蛛女王时拿到的护符吗？")
+	q := s.RecallQuery(3)
+	if q == "" {
+		t.Fatal("recall cue should produce a query")
+	}
+	found := s.SearchChapters(q, 1)
+	if len(found) != 1 || found[0].Number != 2 {
+		t.Errorf("expected chapter 2, got %+v", found)
+	}
+
+	data := s.Data()
+	if len(FromData(data).GetChapters()) != 3 {
+		t.Error("chapters should survive export/import")
+	}
+}
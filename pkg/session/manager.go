@@ -3,21 +3,37 @@ package session
 import (
 	"sync"
 
+	"github.com/sirupsen/logrus"
+
 	openai "github.com/sashabaranov/go-openai"
 )
 
+// defaultMaxLength 滑动窗口的默认长度
+// 增加到 50，给总结机制留出缓冲空间（通常每20条触发总结）
+const defaultMaxLength = 50
+
 // Session 管理单个群组的对话上下文
 type Session struct {
 	GroupID   int64
 	History   []openai.ChatCompletionMessage
-	Summary   string // 长期记忆/剧情摘要
+	Summary   string           // 长期记忆/剧情摘要
+	Versions  []SummaryVersion // 被替换掉的历史摘要，最新的在最后
+	Chapters  []Chapter        // 按时间顺序排列的章节摘要，不会被新摘要覆盖
 	MaxLength int
 	Mutex     sync.RWMutex
+
+	offset  int         // History[0] 的绝对序号 (此前被修剪掉的消息数)
+	seqs    []int       // History 中每条消息的序号，同一会话内不会重复，用于准确删除某条消息
+	nextSeq int         // 下一条消息的序号
+	epoch   int         // 每次 Clear、删除消息或手动修改摘要时加一，使进行中的总结作废
+	running *SummaryJob // 进行中的总结，同一会话同时只有一个
+	store   Store       // 为 nil 时只保存在内存中
 }
 
 // Manager 全局会话管理器
 type Manager struct {
 	sessions map[int64]*Session
+	store    Store
 	mutex    sync.RWMutex
 }
 
@@ -28,6 +44,8 @@ type SessionData struct {
 	GroupID   int64
 	History   []openai.ChatCompletionMessage
 	Summary   string
+	Versions  []SummaryVersion `json:",omitempty"`
+	Chapters  []Chapter        `json:",omitempty"`
 	MaxLength int
 }
 
@@ -46,17 +64,41 @@ func (m *Manager) GetSession(groupID int64) *Session {
 		return sess
 	}
 
-	newSess := &Session{
+	newSess := NewSession(groupID)
+	newSess.store = m.store
+	m.sessions[groupID] = newSess
+	return newSess
+}
+
+// NewSession 创建空白会话
+func NewSession(groupID int64) *Session {
+	return &Session{
 		GroupID:   groupID,
 		History:   make([]openai.ChatCompletionMessage, 0),
-		MaxLength: 50, // 增加到 50，给总结机制留出缓冲空间（通常每20条触发总结）
+		MaxLength: defaultMaxLength,
 	}
-	m.sessions[groupID] = newSess
-	return newSess
 }
 
-// AddMessage 添加消息并执行滑动窗口修剪
-func (s *Session) AddMessage(role string, content string) {
+// Swap 用 sess 替换群组当前的会话并返回被替换的会话 (不存在时为 nil)
+// 被替换的会话不再写入存储；新会话之后的修改会写入存储，但不会立即整体写入一次，
+// 由调用方负责 (切换战役时与战役列表在同一个事务中写入)
+func (m *Manager) Swap(groupID int64, sess *Session) *Session {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	old := m.sessions[groupID]
+	if old != nil {
+		old.attach(nil)
+	}
+	sess.Mutex.Lock()
+	sess.store = m.store
+	sess.Mutex.Unlock()
+	m.sessions[groupID] = sess
+	return old
+}
+
+// AddMessage 添加消息并执行滑动窗口修剪，返回消息的序号
+func (s *Session) AddMessage(role string, content string) int {
 	s.Mutex.Lock()
 	defer s.Mutex.Unlock()
 
@@ -64,34 +106,58 @@ func (s *Session) AddMessage(role string, content string) {
 		Role:    role,
 		Content: content,
 	}
+	seq := s.nextSeq
+	s.nextSeq++
 	s.History = append(s.History, msg)
+	s.seqs = append(s.seqs, seq)
 
 	// Sliding Window: 如果超出最大长度，移除最早的消息
 	// 仍然保留这个作为最后的防线，防止内存溢出
-	if len(s.History) > s.MaxLength {
-		s.History = s.History[len(s.History)-s.MaxLength:]
+	if over := len(s.History) - s.MaxLength; over > 0 {
+		s.History = s.History[over:]
+		s.seqs = s.seqs[over:]
+		s.offset += over
 	}
-}
 
-// GetSummary 获取当前摘要
-func (s *Session) GetSummary() string {
-	s.Mutex.RLock()
-	defer s.Mutex.RUnlock()
-	return s.Summary
+	if s.store != nil {
+		if err := s.store.AppendMessage(s.GroupID, msg, len(s.History)); err != nil {
+			logrus.Errorf("Failed to save message for session %d: %v", s.GroupID, err)
+		}
+	}
+	return seq
 }
 
-// UpdateSummary 更新摘要并修剪历史
-// keepCount: 保留最近的多少条消息作为上下文衔接
-func (s *Session) UpdateSummary(newSummary string, keepCount int) {
+// RemoveMessages 删除序号为 seqs 的消息 (AddMessage 的返回值)，用于撤销一个回合
+// 已被总结、修剪或清空的消息不再删除，返回实际删除的条数；删除后进行中的总结作废
+func (s *Session) RemoveMessages(seqs []int) int {
 	s.Mutex.Lock()
 	defer s.Mutex.Unlock()
 
-	s.Summary = newSummary
-
-	// Prune History
-	if len(s.History) > keepCount {
-		s.History = s.History[len(s.History)-keepCount:]
+	drop := make(map[int]bool, len(seqs))
+	for _, seq := range seqs {
+		drop[seq] = true
 	}
+	history, kept := s.History[:0], s.seqs[:0]
+	for i, msg := range s.History {
+		if !drop[s.seqs[i]] {
+			history = append(history, msg)
+			kept = append(kept, s.seqs[i])
+		}
+	}
+	removed := len(s.History) - len(history)
+	s.History, s.seqs = history, kept
+	if removed > 0 {
+		s.epoch++
+		s.save()
+	}
+	return removed
+}
+
+// GetSummary 获取当前摘要
+func (s *Session) GetSummary() string {
+	s.Mutex.RLock()
+	defer s.Mutex.RUnlock()
+	return s.Summary
 }
 
 // GetHistory 获取当前历史记录副本
@@ -112,36 +178,62 @@ func (m *Manager) ExportData() map[int64]*SessionData {
 
 	data := make(map[int64]*SessionData)
 	for id, sess := range m.sessions {
-		sess.Mutex.RLock()
-		historyCopy := make([]openai.ChatCompletionMessage, len(sess.History))
-		copy(historyCopy, sess.History)
-
-		data[id] = &SessionData{
-			GroupID:   sess.GroupID,
-			History:   historyCopy,
-			Summary:   sess.Summary,
-			MaxLength: sess.MaxLength,
-		}
-		sess.Mutex.RUnlock()
+		data[id] = sess.Data()
 	}
 	return data
 }
 
-// ImportData 导入会话数据
+// Data 导出单个会话的数据
+func (s *Session) Data() *SessionData {
+	s.Mutex.RLock()
+	defer s.Mutex.RUnlock()
+	return s.data()
+}
+
+// data 导出单个会话的数据，调用方需持有锁
+func (s *Session) data() *SessionData {
+	historyCopy := make([]openai.ChatCompletionMessage, len(s.History))
+	copy(historyCopy, s.History)
+	return &SessionData{
+		GroupID:   s.GroupID,
+		History:   historyCopy,
+		Summary:   s.Summary,
+		Versions:  append([]SummaryVersion(nil), s.Versions...),
+		Chapters:  append([]Chapter(nil), s.Chapters...),
+		MaxLength: s.MaxLength,
+	}
+}
+
+// FromData 从导出数据重建会话
+func FromData(sData *SessionData) *Session {
+	newSess := &Session{
+		GroupID:   sData.GroupID,
+		History:   make([]openai.ChatCompletionMessage, len(sData.History)),
+		Summary:   sData.Summary,
+		Versions:  append([]SummaryVersion(nil), sData.Versions...),
+		Chapters:  append([]Chapter(nil), sData.Chapters...),
+		MaxLength: sData.MaxLength,
+	}
+	copy(newSess.History, sData.History)
+	for i := range newSess.History {
+		newSess.seqs = append(newSess.seqs, i)
+	}
+	newSess.nextSeq = len(newSess.History)
+	if newSess.MaxLength <= 0 {
+		newSess.MaxLength = defaultMaxLength
+	}
+	return newSess
+}
+
+// ImportData 导入会话数据，设置了存储时同时写入存储
 func (m *Manager) ImportData(data map[int64]*SessionData) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
 	for id, sData := range data {
-		// Reconstruct Session object
-		newSess := &Session{
-			GroupID:   sData.GroupID,
-			History:   make([]openai.ChatCompletionMessage, len(sData.History)),
-			Summary:   sData.Summary,
-			MaxLength: sData.MaxLength,
-		}
-		copy(newSess.History, sData.History)
-		m.sessions[id] = newSess
+		sess := FromData(sData)
+		sess.attach(m.store)
+		m.sessions[id] = sess
 	}
 }
 
@@ -149,6 +241,12 @@ func (m *Manager) ImportData(data map[int64]*SessionData) {
 func (s *Session) Clear() {
 	s.Mutex.Lock()
 	defer s.Mutex.Unlock()
+	s.offset += len(s.History)
+	s.epoch++
 	s.History = make([]openai.ChatCompletionMessage, 0)
+	s.seqs = nil
 	s.Summary = ""
+	s.Versions = nil
+	s.Chapters = nil
+	s.save()
 }
@@ -0,0 +1,67 @@
+package session
+
+import (
+	"github.com/sirupsen/logrus"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// Store 会话的持久化存储，每条消息与每次摘要修改都会立即写入
+type Store interface {
+	// AppendMessage 追加一条消息，只保留最近 keep 条
+	AppendMessage(groupID int64, msg openai.ChatCompletionMessage, keep int) error
+	// SaveSession 整体覆盖写入一个会话 (摘要、历史摘要、章节与对话记录)
+	SaveSession(data *SessionData) error
+	// LoadSessions 读取所有会话
+	LoadSessions() (map[int64]*SessionData, error)
+}
+
+// SetStore 为管理器及已有的会话设置存储，之后的修改都会写入 st
+func (m *Manager) SetStore(st Store) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.store = st
+	for _, sess := range m.sessions {
+		sess.Mutex.Lock()
+		sess.store = st
+		sess.Mutex.Unlock()
+	}
+}
+
+// Load 从存储读取所有会话并替换内存中的同名会话，不会回写
+func (m *Manager) Load(st Store) error {
+	data, err := st.LoadSessions()
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for id, sData := range data {
+		sess := FromData(sData)
+		sess.store = m.store
+		m.sessions[id] = sess
+	}
+	return nil
+}
+
+// save 将会话整体写入存储，调用方需持有锁
+func (s *Session) save() {
+	if s.store == nil {
+		return
+	}
+	if err := s.store.SaveSession(s.data()); err != nil {
+		logrus.Errorf("Failed to save session %d: %v", s.GroupID, err)
+	}
+}
+
+// attach 设置会话的存储并整体写入一次，传入 nil 时解除
+func (s *Session) attach(st Store) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	s.store = st
+	s.save()
+}
@@ -0,0 +1,123 @@
+package session
+
+import (
+	"fmt"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// maxVersions 最多保留的历史摘要数
+const maxVersions = 20
+
+// SummaryVersion 一份被替换掉的摘要
+type SummaryVersion struct {
+	Summary    string
+	ReplacedAt time.Time
+	ReplacedBy string // 替换它的操作: 自动总结 / GM 编辑 / 回滚
+}
+
+// SummaryJob 一次进行中的总结
+// 总结期间新增的消息不在 History 中，完成时会被完整保留
+type SummaryJob struct {
+	History []openai.ChatCompletionMessage // 本次总结覆盖的消息
+	Summary string                         // 开始时的摘要
+
+	watermark int // 覆盖的最后一条消息之后的绝对序号
+	epoch     int
+}
+
+// BeginSummary 开始一次总结，已有总结在进行时返回 nil
+func (s *Session) BeginSummary() *SummaryJob {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	if s.running != nil {
+		return nil
+	}
+	job := &SummaryJob{
+		History:   append([]openai.ChatCompletionMessage(nil), s.History...),
+		Summary:   s.Summary,
+		watermark: s.offset + len(s.History),
+		epoch:     s.epoch,
+	}
+	s.running = job
+	return job
+}
+
+// FinishSummary 写入新摘要并修剪已总结的消息
+// keepCount: 已总结的消息中保留最近多少条作为上下文衔接，水位线之后的消息全部保留
+// 会话在总结期间被清空或摘要被手动修改时丢弃结果并返回 false，未总结的消息留待下次
+func (s *Session) FinishSummary(job *SummaryJob, newSummary string, keepCount int) bool {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	if s.running == job {
+		s.running = nil
+	}
+	if job.epoch != s.epoch {
+		return false
+	}
+
+	s.setSummary(newSummary, "自动总结")
+	if cut := job.watermark - keepCount - s.offset; cut > 0 {
+		s.History = s.History[cut:]
+		s.seqs = s.seqs[cut:]
+		s.offset += cut
+	}
+	s.save()
+	return true
+}
+
+// AbortSummary 放弃一次总结 (例如请求失败)，允许下次重新开始
+func (s *Session) AbortSummary(job *SummaryJob) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	if s.running == job {
+		s.running = nil
+	}
+}
+
+// setSummary 替换摘要并保留旧版本，调用方需持有锁
+func (s *Session) setSummary(summary, by string) {
+	if s.Summary != "" {
+		s.Versions = append(s.Versions, SummaryVersion{Summary: s.Summary, ReplacedAt: time.Now(), ReplacedBy: by})
+		if over := len(s.Versions) - maxVersions; over > 0 {
+			s.Versions = s.Versions[over:]
+		}
+	}
+	s.Summary = summary
+}
+
+// EditSummary GM 手动改写摘要，进行中的自动总结会被作废
+func (s *Session) EditSummary(summary string) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	s.setSummary(summary, "GM 编辑")
+	s.epoch++
+	s.save()
+}
+
+// GetVersions 历史摘要副本，最新的在最后
+func (s *Session) GetVersions() []SummaryVersion {
+	s.Mutex.RLock()
+	defer s.Mutex.RUnlock()
+	return append([]SummaryVersion(nil), s.Versions...)
+}
+
+// RevertSummary 恢复第 n 份历史摘要 (1 为最近被替换的一份)，当前摘要会存入历史
+func (s *Session) RevertSummary(n int) (string, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	if n < 1 || n > len(s.Versions) {
+		return "", fmt.Errorf("没有第 %d 份历史摘要 (共 %d 份)", n, len(s.Versions))
+	}
+	summary := s.Versions[len(s.Versions)-n].Summary
+	s.setSummary(summary, "回滚")
+	s.epoch++
+	s.save()
+	return summary, nil
+}
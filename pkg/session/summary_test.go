@@ -0,0 +1,104 @@
+package session
+
+import (
+	"fmt"
+	"testing"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+func TestSummaryJob_KeepsMessagesAddedMidFlight(t *testing.T) {
+	s := NewSession(1)
+	for i := 0; i < 20; i++ {
+		s.AddMessage(openai.ChatMessageRoleUser, fmt.Sprintf("m%d", i))
+	}
+
+	job := s.BeginSummary()
+	if job == nil || len(job.History) != 20 {
+		t.Fatalf("unexpected job %+v", job)
+	}
+	if s.BeginSummary() != nil {
+		t.Error("a second summary must not start while one is running")
+	}
+
+	// 总结期间新增的消息
+	s.AddMessage(openai.ChatMessageRoleUser, "late1")
+	s.AddMessage(openai.ChatMessageRoleAssistant, "late2")
+
+	if !s.FinishSummary(job, "摘要", 5) {
+		t.Fatal("finish should succeed")
+	}
+	h := s.GetHistory()
+	if len(h) != 7 || h[0].Content != "m15" || h[6].Content != "late2" {
+		t.Errorf("unexpected history after summary: %v", h)
+	}
+	if s.GetSummary() != "摘要" || s.BeginSummary() == nil {
+		t.Error("summary should be stored and a new job allowed")
+	}
+}
+
+func TestSummaryJob_DiscardedAfterClear(t *testing.T) {
+	s := NewSession(1)
+	s.AddMessage(openai.ChatMessageRoleUser, "hello")
+	job := s.BeginSummary()
+	s.Clear()
+	s.AddMessage(openai.ChatMessageRoleUser, "new game")
+
+	if s.FinishSummary(job, "旧摘要", 0) {
+		t.Error("summary of a cleared session should be discarded")
+	}
+	if s.GetSummary() != "" || len(s.GetHistory()) != 1 {
+		t.Error("cleared session must not be modified by a stale summary")
+	}
+}
+
+func TestRemoveMessages_KeepsOthersAndStopsSummary(t *testing.T) {
+	s := NewSession(1)
+	summarized := s.AddMessage(openai.ChatMessageRoleUser, "继续")
+	s.AddMessage(openai.ChatMessageRoleUser, "earlier")
+	s.FinishSummary(s.BeginSummary(), "摘要", 1)
+	// 回合的消息与之前完全相同的消息只删除回合自己写入的那一条
+	s.AddMessage(openai.ChatMessageRoleUser, "继续")
+	turn := s.AddMessage(openai.ChatMessageRoleUser, "继续")
+	s.AddMessage(openai.ChatMessageRoleUser, "other player")
+	reply := s.AddMessage(openai.ChatMessageRoleAssistant, "reply")
+	job := s.BeginSummary()
+
+	if n := s.RemoveMessages([]int{summarized, turn, reply}); n != 2 {
+		t.Errorf("expected 2 messages removed, got %d", n)
+	}
+	h := s.GetHistory()
+	if len(h) != 3 || h[0].Content != "earlier" || h[1].Content != "继续" || h[2].Content != "other player" {
+		t.Errorf("unexpected history %v", h)
+	}
+	if s.FinishSummary(job, "摘要", 0) {
+		t.Error("summary started before the removal should be discarded")
+	}
+	if n := s.RemoveMessages([]int{turn, reply}); n != 0 {
+		t.Errorf("removed messages should not be removed again, got %d", n)
+	}
+}
+
+func TestSummaryVersions_EditAndRevert(t *testing.T) {
+	s := NewSession(1)
+	s.AddMessage(openai.ChatMessageRoleUser, "hello")
+	job := s.BeginSummary()
+	s.FinishSummary(job, "v1: 商队被狼蛛袭击", 0)
+
+	running := s.BeginSummary()
+	s.EditSummary("v2: 商队被地精袭击")
+	if s.FinishSummary(running, "基于 v1 的自动摘要", 0) {
+		t.Error("auto summary started before a GM edit must be discarded")
+	}
+
+	if _, err := s.RevertSummary(2); err == nil {
+		t.Error("reverting a missing version should fail")
+	}
+	got, err := s.RevertSummary(1)
+	if err != nil || got != "v1: 商队被狼蛛袭击" || s.GetSummary() != got {
+		t.Errorf("revert: %q %v", got, err)
+	}
+	if v := s.GetVersions(); len(v) != 2 || v[1].Summary != "v2: 商队被地精袭击" || v[1].ReplacedBy != "回滚" {
+		t.Errorf("unexpected versions %+v", v)
+	}
+}
@@ -0,0 +1,134 @@
+package snapshot
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// autoSuffix 自动存档的文件名后缀，保留策略只清理自动存档，手动 .snapshot 的存档不会被删除
+const autoSuffix = "_auto.ss"
+
+// Retention 自动存档的保留策略，两项都为 0 时保留全部
+type Retention struct {
+	KeepLast  int // 保留最近的 N 份
+	KeepDaily int // 另外为最近 N 天各保留当天最后一份
+}
+
+// AutosaveConfig 自动存档配置
+type AutosaveConfig struct {
+	Interval  time.Duration // 定时存档间隔，0 表示关闭；期间没有处理过消息时跳过
+	Messages  int           // 每处理 N 条消息存档一次，0 表示关闭
+	Retention Retention
+}
+
+// Autosaver 后台自动存档
+type Autosaver struct {
+	cfg     AutosaveConfig
+	pending atomic.Int64 // 上次存档后处理的消息数
+	trigger chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// StartAutosave 启动后台自动存档
+func StartAutosave(cfg AutosaveConfig) *Autosaver {
+	a := &Autosaver{
+		cfg:     cfg,
+		trigger: make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go a.loop()
+	return a
+}
+
+// Notify 记录处理了一条消息，达到 Messages 条时触发存档
+func (a *Autosaver) Notify() {
+	n := a.pending.Add(1)
+	if a.cfg.Messages > 0 && n >= int64(a.cfg.Messages) {
+		select {
+		case a.trigger <- struct{}{}:
+		default: // 已经在等待存档
+		}
+	}
+}
+
+// Stop 停止后台存档，等待进行中的存档完成
+func (a *Autosaver) Stop() {
+	close(a.stop)
+	<-a.done
+}
+
+func (a *Autosaver) loop() {
+	defer close(a.done)
+
+	var tick <-chan time.Time
+	if a.cfg.Interval > 0 {
+		ticker := time.NewTicker(a.cfg.Interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-tick:
+			if a.pending.Load() == 0 {
+				continue
+			}
+		case <-a.trigger:
+		}
+		a.pending.Store(0)
+		if filename, err := SaveAutoSnapshot(a.cfg.Retention); err != nil {
+			logrus.Errorf("Autosave failed: %v", err)
+		} else {
+			logrus.Infof("Autosaved to %s", filename)
+		}
+	}
+}
+
+// SaveAutoSnapshot 保存一份自动存档，并按保留策略清理旧的自动存档
+func SaveAutoSnapshot(r Retention) (string, error) {
+	filename := filepath.Join(Dir, fmt.Sprintf("snapshot_%s%s", time.Now().Format("20060102_150405"), autoSuffix))
+	if err := writeSnapshot(filename); err != nil {
+		return "", err
+	}
+
+	files, err := listSnapshots(Dir, autoSuffix)
+	if err != nil {
+		return filename, err
+	}
+	for _, name := range expired(files, r) {
+		if err := os.Remove(name); err != nil {
+			logrus.Warnf("Failed to remove old snapshot %s: %v", name, err)
+		}
+	}
+	return filename, nil
+}
+
+// expired 按保留策略应当删除的快照，files 需按时间从新到旧排列
+func expired(files []snapshotFile, r Retention) []string {
+	if r.KeepLast <= 0 && r.KeepDaily <= 0 {
+		return nil
+	}
+
+	days := make(map[string]bool) // 已经保留了一份的日期
+	var names []string
+	for i, f := range files {
+		day := f.ModTime.Format("2006-01-02")
+		daily := !days[day] && len(days) < r.KeepDaily
+		if daily {
+			days[day] = true
+		}
+		if i >= r.KeepLast && !daily {
+			names = append(names, f.Name)
+		}
+	}
+	return names
+}
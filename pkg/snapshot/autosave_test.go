@@ -0,0 +1,32 @@
+package snapshot
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExpired_KeepLastAndDaily(t *testing.T) {
+	day := func(d, h int) time.Time { return time.Date(2026, 10, d, h, 0, 0, 0, time.Local) }
+	files := []snapshotFile{
+		{"a", day(18, 12)},
+		{"b", day(18, 11)},
+		{"c", day(18, 10)},
+		{"d", day(17, 23)},
+		{"e", day(17, 22)},
+		{"f", day(16, 8)},
+		{"g", day(15, 8)},
+	}
+
+	got := expired(files, Retention{KeepLast: 2, KeepDaily: 3})
+	// a,b 为最近两份；a、d、f 分别是 18、17、16 日的最后一份
+	if want := []string{"c", "e", "g"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expired = %v, want %v", got, want)
+	}
+	if got := expired(files, Retention{}); got != nil {
+		t.Errorf("empty policy should keep everything, got %v", got)
+	}
+	if got := expired(files, Retention{KeepLast: 10}); got != nil {
+		t.Errorf("KeepLast larger than the list should keep everything, got %v", got)
+	}
+}
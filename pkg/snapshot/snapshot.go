@@ -1,109 +1,175 @@
 package snapshot
 
 import (
+	"dndbot/pkg/campaign"
 	"dndbot/pkg/game"
 	"dndbot/pkg/session"
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
+// Dir 快照与自动存档所在的目录 (SNAPSHOT_DIR)，默认与数据库一起放在 data 下，Docker 部署时随 ./data 挂载保留
+var Dir = filepath.Join("data", "snapshots")
+
+// legacyDir 旧版本把快照写在工作目录中，Dir 下没有快照时仍从这里读取
+const legacyDir = "."
+
+// Snapshot 所有群组的进度，每个群组的背景保存在各自的 GameStates 中
 type Snapshot struct {
-	Timestamp         time.Time
-	CurrentBackground string
-	Sessions          map[int64]*session.SessionData
-	GameStates        map[int64]*game.GroupStateData
+	Timestamp  time.Time
+	Sessions   map[int64]*session.SessionData
+	GameStates map[int64]*game.GroupStateData
+	Campaigns  map[int64]*campaign.GroupData `json:",omitempty"` // 旧快照没有此字段，所有群组都在默认战役中
 }
 
 // SaveSnapshot saves the current state to a JSON file (with .ss extension)
-func SaveSnapshot(bg string) (string, error) {
+func SaveSnapshot() (string, error) {
 	// Generate filename with timestamp
-	filename := fmt.Sprintf("snapshot_%s.ss", time.Now().Format("20060102_150405"))
+	filename := filepath.Join(Dir, fmt.Sprintf("snapshot_%s.ss", time.Now().Format("20060102_150405")))
+	return filename, writeSnapshot(filename)
+}
 
+// writeSnapshot 将当前所有进度写入 filename
+// 先写入临时文件再改名，写到一半退出时不会留下损坏的快照
+func writeSnapshot(filename string) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		logrus.Errorf("Failed to create snapshot directory: %v", err)
+		return err
+	}
 	snap := Snapshot{
-		Timestamp:         time.Now(),
-		CurrentBackground: bg,
-		Sessions:          session.GlobalManager.ExportData(),
-		GameStates:        game.GlobalGameState.ExportData(),
+		Timestamp:  time.Now(),
+		Sessions:   session.GlobalManager.ExportData(),
+		GameStates: game.GlobalGameState.ExportData(),
+		Campaigns:  campaign.GlobalRegistry.ExportData(),
 	}
 
-	file, err := os.Create(filename)
+	tmp := filename + ".tmp"
+	file, err := os.Create(tmp)
 	if err != nil {
 		logrus.Errorf("Failed to create snapshot file: %v", err)
-		return "", err
+		return err
 	}
-	defer file.Close()
 
 	enc := json.NewEncoder(file)
 	enc.SetIndent("", "  ")
 	if err := enc.Encode(snap); err != nil {
 		logrus.Errorf("Failed to encode snapshot: %v", err)
-		return "", err
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return err
 	}
+	return os.Rename(tmp, filename)
+}
 
-	return filename, nil
+// snapshotFile 一个快照文件，Name 包含所在目录
+type snapshotFile struct {
+	Name    string
+	ModTime time.Time
 }
 
-// LoadLatestSnapshot finds the latest snapshot file and loads it
-func LoadLatestSnapshot() (*Snapshot, string, error) {
-	files, err := os.ReadDir(".")
+// listSnapshots 列出 dir 下以 suffix 结尾的快照文件，最新的在前；目录不存在时返回空列表
+func listSnapshots(dir, suffix string) ([]snapshotFile, error) {
+	files, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
 	if err != nil {
-		return nil, "", err
+		return nil, err
 	}
 
-	var latestFile string
-	var latestTime time.Time
-
+	var list []snapshotFile
 	for _, f := range files {
 		if f.IsDir() {
 			continue
 		}
 		// Basic check for snapshot_ prefix and .ss suffix
-		if len(f.Name()) > 12 && f.Name()[:9] == "snapshot_" && f.Name()[len(f.Name())-3:] == ".ss" {
+		if len(f.Name()) > 12 && strings.HasPrefix(f.Name(), "snapshot_") && strings.HasSuffix(f.Name(), suffix) {
 			info, err := f.Info()
 			if err != nil {
 				continue
 			}
-			if info.ModTime().After(latestTime) {
-				latestTime = info.ModTime()
-				latestFile = f.Name()
-			}
+			list = append(list, snapshotFile{Name: filepath.Join(dir, f.Name()), ModTime: info.ModTime()})
 		}
 	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].ModTime.After(list[j].ModTime) })
+	return list, nil
+}
 
-	if latestFile == "" {
+// LoadLatestSnapshot finds the latest snapshot file and loads it
+// 最新的快照无法读取或解析时依次尝试更早的，全部失败时返回最后一个错误
+// Dir 下没有快照时读取旧版本留在工作目录中的快照
+func LoadLatestSnapshot() (*Snapshot, string, error) {
+	files, err := listSnapshots(Dir, ".ss")
+	if err != nil {
+		return nil, "", err
+	}
+	if len(files) == 0 {
+		if files, err = listSnapshots(legacyDir, ".ss"); err != nil {
+			return nil, "", err
+		}
+	}
+	if len(files) == 0 {
 		return nil, "", nil // No snapshot found
 	}
 
-	logrus.Infof("Loading snapshot from %s...", latestFile)
-	data, err := os.ReadFile(latestFile)
-	if err != nil {
-		return nil, latestFile, err
+	var lastErr error
+	for _, f := range files {
+		logrus.Infof("Loading snapshot from %s...", f.Name)
+		snap, err := readSnapshot(f.Name)
+		if err != nil {
+			logrus.Warnf("Skipping unreadable snapshot %s: %v", f.Name, err)
+			lastErr = err
+			continue
+		}
+		return snap, f.Name, nil
 	}
+	return nil, "", lastErr
+}
 
+// readSnapshot 读取并解析一个快照文件
+func readSnapshot(filename string) (*Snapshot, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
 	var snap Snapshot
 	if err := json.Unmarshal(data, &snap); err != nil {
-		return nil, latestFile, err
+		return nil, err
 	}
-
-	return &snap, latestFile, nil
+	return &snap, nil
 }
 
-// DeleteLatestSnapshot deletes the most recent snapshot file
+// DeleteLatestSnapshot 删除最新的一份手动 (.snapshot) 存档
+// 自动存档由保留策略清理，不会被删除
 func DeleteLatestSnapshot() (string, error) {
-	_, filename, err := LoadLatestSnapshot()
+	files, err := listSnapshots(Dir, ".ss")
 	if err != nil {
 		return "", err
 	}
-	if filename == "" {
-		return "", fmt.Errorf("no snapshot found")
-	}
-
-	if err := os.Remove(filename); err != nil {
-		return filename, err
+	for _, f := range files {
+		if strings.HasSuffix(f.Name, autoSuffix) {
+			continue
+		}
+		if err := os.Remove(f.Name); err != nil {
+			return f.Name, err
+		}
+		return f.Name, nil
 	}
-	return filename, nil
+	return "", fmt.Errorf("no manual snapshot found")
 }
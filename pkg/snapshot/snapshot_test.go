@@ -0,0 +1,104 @@
+package snapshot
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"dndbot/pkg/game"
+	"dndbot/pkg/session"
+)
+
+// inTempDir 在临时目录中运行测试，快照读写的都是相对于当前目录的 Dir
+func inTempDir(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	if err := os.MkdirAll(Dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadLatestSnapshot_FallsBackOnCorruptFile(t *testing.T) {
+	inTempDir(t)
+	session.InitManager()
+	game.InitGameState()
+	session.GlobalManager.GetSession(1).AddMessage("user", "hello")
+
+	older := filepath.Join(Dir, "snapshot_20261017_120000.ss")
+	if err := writeSnapshot(older); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(older + ".tmp"); !os.IsNotExist(err) {
+		t.Error("temporary file should be renamed away")
+	}
+	// 写到一半的更新快照
+	newer := filepath.Join(Dir, "snapshot_20261018_120000_auto.ss")
+	if err := os.WriteFile(newer, []byte(`{"Sessions": {`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(newer, later, later)
+
+	snap, filename, err := LoadLatestSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filename != older || len(snap.Sessions[1].History) != 1 {
+		t.Errorf("expected fallback to the older snapshot, got %s", filename)
+	}
+}
+
+func TestSaveSnapshot_WritesUnderDir(t *testing.T) {
+	inTempDir(t)
+	session.InitManager()
+	game.InitGameState()
+
+	// 旧版本留在工作目录中的快照只在 Dir 下没有快照时读取
+	if err := writeSnapshot("snapshot_20261001_120000.ss"); err != nil {
+		t.Fatal(err)
+	}
+	if _, filename, err := LoadLatestSnapshot(); err != nil || filename != "snapshot_20261001_120000.ss" {
+		t.Errorf("expected the legacy snapshot, got %q %v", filename, err)
+	}
+
+	filename, err := SaveAutoSnapshot(Retention{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(filename) != Dir {
+		t.Errorf("autosave written outside %s: %s", Dir, filename)
+	}
+	if _, latest, err := LoadLatestSnapshot(); err != nil || latest != filename {
+		t.Errorf("expected %s, got %q %v", filename, latest, err)
+	}
+}
+
+func TestDeleteLatestSnapshot_SkipsAutosaves(t *testing.T) {
+	inTempDir(t)
+	manual, auto := filepath.Join(Dir, "snapshot_1.ss"), filepath.Join(Dir, "snapshot_2_auto.ss")
+	for i, name := range []string{manual, auto} {
+		if err := os.WriteFile(name, []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		at := time.Now().Add(time.Duration(i) * time.Minute)
+		os.Chtimes(name, at, at)
+	}
+
+	if name, err := DeleteLatestSnapshot(); err != nil || name != manual {
+		t.Errorf("expected the manual snapshot deleted, got %q %v", name, err)
+	}
+	if _, err := os.Stat(auto); err != nil {
+		t.Error("autosave should be kept")
+	}
+	if _, err := DeleteLatestSnapshot(); err == nil {
+		t.Error("no manual snapshot left, delete should fail")
+	}
+}
@@ -0,0 +1,352 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"dndbot/pkg/campaign"
+	"dndbot/pkg/game"
+	"dndbot/pkg/session"
+
+	openai "github.com/sashabaranov/go-openai"
+	_ "modernc.org/sqlite" // 纯 Go 实现的 SQLite 驱动，无需 CGO
+)
+
+// schema 数据库结构
+// 会话的对话记录与群组的角色卡各占一行，便于逐条写入；其余状态以 JSON 保存
+const schema = `
+CREATE TABLE IF NOT EXISTS sessions (
+	group_id   INTEGER PRIMARY KEY,
+	summary    TEXT NOT NULL DEFAULT '',
+	versions   TEXT NOT NULL DEFAULT 'null',
+	chapters   TEXT NOT NULL DEFAULT 'null',
+	max_length INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS messages (
+	id       INTEGER PRIMARY KEY AUTOINCREMENT,
+	group_id INTEGER NOT NULL,
+	role     TEXT NOT NULL,
+	content  TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS messages_group ON messages (group_id, id);
+CREATE TABLE IF NOT EXISTS group_states (
+	group_id INTEGER PRIMARY KEY,
+	data     TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS characters (
+	group_id INTEGER NOT NULL,
+	key      TEXT NOT NULL,
+	data     TEXT NOT NULL,
+	PRIMARY KEY (group_id, key)
+);
+CREATE TABLE IF NOT EXISTS campaigns (
+	group_id INTEGER PRIMARY KEY,
+	data     TEXT NOT NULL
+);`
+
+// SQLite 基于 SQLite 的存储，同时实现 session.Store、game.Store 与 campaign.Store
+// 每次写入都在一个事务中完成，进程中途退出也不会留下写了一半的状态
+type SQLite struct {
+	db *sql.DB
+}
+
+var (
+	_ session.Store  = (*SQLite)(nil)
+	_ game.Store     = (*SQLite)(nil)
+	_ campaign.Store = (*SQLite)(nil)
+)
+
+// Open 打开 (不存在时创建) 数据库文件
+func Open(path string) (*SQLite, error) {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// 所有写入串行执行，避免 SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("初始化数据库失败: %w", err)
+	}
+	return &SQLite{db: db}, nil
+}
+
+// Close 关闭数据库
+func (s *SQLite) Close() error {
+	return s.db.Close()
+}
+
+// HasData 数据库中是否已有会话或群组状态，首次启动时为 false
+func (s *SQLite) HasData() (bool, error) {
+	var exists bool
+	err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM sessions) OR EXISTS (SELECT 1 FROM group_states)`).Scan(&exists)
+	return exists, err
+}
+
+// tx 在一个事务中执行 fn，fn 返回错误时回滚
+func (s *SQLite) tx(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// AppendMessage 追加一条消息并删除 keep 条之前的旧消息
+func (s *SQLite) AppendMessage(groupID int64, msg openai.ChatCompletionMessage, keep int) error {
+	return s.tx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO sessions (group_id) VALUES (?)`, groupID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO messages (group_id, role, content) VALUES (?, ?, ?)`, groupID, msg.Role, msg.Content); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`DELETE FROM messages WHERE group_id = ? AND id <=
+			(SELECT id FROM messages WHERE group_id = ? ORDER BY id DESC LIMIT 1 OFFSET ?)`, groupID, groupID, keep)
+		return err
+	})
+}
+
+// SaveSession 覆盖写入一个会话
+func (s *SQLite) SaveSession(data *session.SessionData) error {
+	return s.tx(func(tx *sql.Tx) error {
+		return saveSession(tx, data)
+	})
+}
+
+// saveSession 在事务 tx 中覆盖写入一个会话
+func saveSession(tx *sql.Tx, data *session.SessionData) error {
+	versions, err := json.Marshal(data.Versions)
+	if err != nil {
+		return err
+	}
+	chapters, err := json.Marshal(data.Chapters)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`INSERT INTO sessions (group_id, summary, versions, chapters, max_length) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (group_id) DO UPDATE SET summary = excluded.summary, versions = excluded.versions,
+		chapters = excluded.chapters, max_length = excluded.max_length`,
+		data.GroupID, data.Summary, string(versions), string(chapters), data.MaxLength); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM messages WHERE group_id = ?`, data.GroupID); err != nil {
+		return err
+	}
+	for _, m := range data.History {
+		if _, err := tx.Exec(`INSERT INTO messages (group_id, role, content) VALUES (?, ?, ?)`, data.GroupID, m.Role, m.Content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadSessions 读取所有会话
+func (s *SQLite) LoadSessions() (map[int64]*session.SessionData, error) {
+	data := make(map[int64]*session.SessionData)
+
+	rows, err := s.db.Query(`SELECT group_id, summary, versions, chapters, max_length FROM sessions`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			d                  session.SessionData
+			versions, chapters string
+		)
+		if err := rows.Scan(&d.GroupID, &d.Summary, &versions, &chapters, &d.MaxLength); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(versions), &d.Versions); err != nil {
+			return nil, fmt.Errorf("会话 %d 的历史摘要损坏: %w", d.GroupID, err)
+		}
+		if err := json.Unmarshal([]byte(chapters), &d.Chapters); err != nil {
+			return nil, fmt.Errorf("会话 %d 的章节损坏: %w", d.GroupID, err)
+		}
+		data[d.GroupID] = &d
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	msgs, err := s.db.Query(`SELECT group_id, role, content FROM messages ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer msgs.Close()
+	for msgs.Next() {
+		var (
+			groupID int64
+			m       openai.ChatCompletionMessage
+		)
+		if err := msgs.Scan(&groupID, &m.Role, &m.Content); err != nil {
+			return nil, err
+		}
+		if d := data[groupID]; d != nil {
+			d.History = append(d.History, m)
+		}
+	}
+	return data, msgs.Err()
+}
+
+// SaveGroupState 覆盖写入一个群组的状态，角色卡逐个写入 characters 表
+func (s *SQLite) SaveGroupState(data *game.GroupStateData) error {
+	return s.tx(func(tx *sql.Tx) error {
+		return saveGroupState(tx, data)
+	})
+}
+
+// saveGroupState 在事务 tx 中覆盖写入一个群组的状态
+func saveGroupState(tx *sql.Tx, data *game.GroupStateData) error {
+	rest := *data
+	rest.Characters = nil
+	state, err := json.Marshal(rest)
+	if err != nil {
+		return err
+	}
+	chars := make(map[string][]byte, len(data.Characters))
+	for key, c := range data.Characters {
+		if chars[key], err = json.Marshal(c); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(`INSERT INTO group_states (group_id, data) VALUES (?, ?)
+		ON CONFLICT (group_id) DO UPDATE SET data = excluded.data`, data.GroupID, string(state)); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM characters WHERE group_id = ?`, data.GroupID); err != nil {
+		return err
+	}
+	for key, c := range chars {
+		if _, err := tx.Exec(`INSERT INTO characters (group_id, key, data) VALUES (?, ?, ?)`, data.GroupID, key, string(c)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadGroupStates 读取所有群组的状态
+func (s *SQLite) LoadGroupStates() (map[int64]*game.GroupStateData, error) {
+	data := make(map[int64]*game.GroupStateData)
+
+	rows, err := s.db.Query(`SELECT group_id, data FROM group_states`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			groupID int64
+			raw     string
+		)
+		if err := rows.Scan(&groupID, &raw); err != nil {
+			return nil, err
+		}
+		d := &game.GroupStateData{}
+		if err := json.Unmarshal([]byte(raw), d); err != nil {
+			return nil, fmt.Errorf("群组 %d 的状态损坏: %w", groupID, err)
+		}
+		d.GroupID = groupID
+		d.Characters = make(map[string]*game.Character)
+		data[groupID] = d
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	chars, err := s.db.Query(`SELECT group_id, key, data FROM characters`)
+	if err != nil {
+		return nil, err
+	}
+	defer chars.Close()
+	for chars.Next() {
+		var (
+			groupID  int64
+			key, raw string
+		)
+		if err := chars.Scan(&groupID, &key, &raw); err != nil {
+			return nil, err
+		}
+		c := &game.Character{}
+		if err := json.Unmarshal([]byte(raw), c); err != nil {
+			return nil, fmt.Errorf("群组 %d 的角色 %s 损坏: %w", groupID, key, err)
+		}
+		if d := data[groupID]; d != nil {
+			d.Characters[key] = c
+		}
+	}
+	return data, chars.Err()
+}
+
+// SaveCampaigns 覆盖写入一个群组的战役列表
+func (s *SQLite) SaveCampaigns(groupID int64, data *campaign.GroupData) error {
+	return s.tx(func(tx *sql.Tx) error {
+		return saveCampaigns(tx, groupID, data)
+	})
+}
+
+// saveCampaigns 在事务 tx 中覆盖写入一个群组的战役列表
+func saveCampaigns(tx *sql.Tx, groupID int64, data *campaign.GroupData) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO campaigns (group_id, data) VALUES (?, ?)
+		ON CONFLICT (group_id) DO UPDATE SET data = excluded.data`, groupID, string(raw))
+	return err
+}
+
+// SaveSwitch 切换战役时在一个事务中写入新的当前会话、群组状态与战役列表
+// 进程中途退出时要么仍是切换前的战役，要么完整切换到新战役
+func (s *SQLite) SaveSwitch(groupID int64, sess *session.SessionData, state *game.GroupStateData, campaigns *campaign.GroupData) error {
+	return s.tx(func(tx *sql.Tx) error {
+		if err := saveSession(tx, sess); err != nil {
+			return err
+		}
+		if err := saveGroupState(tx, state); err != nil {
+			return err
+		}
+		return saveCampaigns(tx, groupID, campaigns)
+	})
+}
+
+// LoadCampaigns 读取所有群组的战役列表
+func (s *SQLite) LoadCampaigns() (map[int64]*campaign.GroupData, error) {
+	data := make(map[int64]*campaign.GroupData)
+
+	rows, err := s.db.Query(`SELECT group_id, data FROM campaigns`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			groupID int64
+			raw     string
+		)
+		if err := rows.Scan(&groupID, &raw); err != nil {
+			return nil, err
+		}
+		d := &campaign.GroupData{}
+		if err := json.Unmarshal([]byte(raw), d); err != nil {
+			return nil, fmt.Errorf("群组 %d 的战役列表损坏: %w", groupID, err)
+		}
+		data[groupID] = d
+	}
+	return data, rows.Err()
+}
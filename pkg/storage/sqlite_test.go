@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+
+	"dndbot/pkg/campaign"
+	"dndbot/pkg/game"
+	"dndbot/pkg/session"
+)
+
+// reopen 模拟重启: 新建空白的管理器并从数据库恢复
+func reopen(t *testing.T, path string) (*SQLite, *campaign.Registry) {
+	t.Helper()
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	session.InitManager()
+	game.InitGameState()
+	registry := campaign.NewRegistry()
+	session.GlobalManager.SetStore(db)
+	game.GlobalGameState.SetStore(db)
+	registry.SetStore(db)
+	return db, registry
+}
+
+func TestSQLite_WritesThroughAndRestores(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "test.db")
+	const gid = 100
+
+	db, registry := reopen(t, path)
+	if has, err := db.HasData(); err != nil || has {
+		t.Fatalf("new database should be empty: %v %v", has, err)
+	}
+
+	sess := session.GlobalManager.GetSession(gid)
+	sess.MaxLength = 3
+	for _, msg := range []string{"一", "二", "三", "四"} {
+		sess.AddMessage("user", msg)
+	}
+	sess.EditSummary("队伍来到了微光镇")
+	gs := game.GlobalGameState.GetGroupState(gid)
+	gs.AddCharacter(&game.Character{Name: "Arthur", HP: 10, MaxHP: 10, OwnerID: 1001})
+	if _, err := gs.ApplyHPChange("Arthur", -4); err != nil {
+		t.Fatal(err)
+	}
+	// 新战役开始后，默认战役的进度保存在战役列表中
+	if err := registry.New(gid, "一发团"); err != nil {
+		t.Fatal(err)
+	}
+	session.GlobalManager.GetSession(gid).AddMessage("user", "一发团开场")
+
+	db, registry = reopen(t, path)
+	if has, _ := db.HasData(); !has {
+		t.Fatal("database should have data after writes")
+	}
+	for _, load := range []func() error{
+		func() error { return session.GlobalManager.Load(db) },
+		func() error { return game.GlobalGameState.Load(db) },
+		func() error { return registry.Load(db) },
+	} {
+		if err := load(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if h := session.GlobalManager.GetSession(gid).GetHistory(); len(h) != 1 || h[0].Content != "一发团开场" {
+		t.Errorf("active campaign history not restored: %+v", h)
+	}
+	if registry.Active(gid).Name != "一发团" {
+		t.Errorf("unexpected active campaign %q", registry.Active(gid).Name)
+	}
+	if _, err := registry.Switch(gid, campaign.DefaultName); err != nil {
+		t.Fatal(err)
+	}
+
+	sess = session.GlobalManager.GetSession(gid)
+	if h := sess.GetHistory(); len(h) != 3 || h[0].Content != "二" || h[2].Content != "四" {
+		t.Errorf("sliding window not restored: %+v", h)
+	}
+	if sess.GetSummary() != "队伍来到了微光镇" {
+		t.Errorf("summary not restored: %q", sess.GetSummary())
+	}
+	if c := game.GlobalGameState.GetGroupState(gid).GetCharacter("Arthur"); c == nil || c.HP != 6 || c.OwnerID != 1001 {
+		t.Errorf("character not restored: %+v", c)
+	}
+
+	// 切换回默认战役后写入的消息同样落盘
+	sess.AddMessage("user", "五")
+	db, _ = reopen(t, path)
+	if err := session.GlobalManager.Load(db); err != nil {
+		t.Fatal(err)
+	}
+	if h := session.GlobalManager.GetSession(gid).GetHistory(); len(h) != 3 || h[2].Content != "五" {
+		t.Errorf("message after switch not persisted: %+v", h)
+	}
+}